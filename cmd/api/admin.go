@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+)
+
+// swagger:model rotateJWTSecretReq
+type rotateJWTSecretReq struct {
+	// ID of a secret already listed in OTP_JWT_SECRET on every instance, as
+	// shown in the startup summary (jwt.key_ids)
+	// required: true
+	KeyID string `json:"key_id"`
+}
+
+// handleRotateJWTSecret godoc
+// @Summary     Rotate JWT secret
+// @Description Makes the configured secret with the given ID the signing key on every instance. The secret must already be in OTP_JWT_SECRET everywhere; only its ID is shared through Redis. The other configured secrets keep verifying existing tokens. Changing the first OTP_JWT_SECRET entry overrides earlier rotations. Requires a recent OTP verification.
+// @Tags        Admin
+// @Security    BearerAuth
+// @Accept      json
+// @Produce     json
+// @Param       payload body     rotateJWTSecretReq true "ID of the secret to sign with"
+// @Success     200     {object} map[string]interface{} "success/message/signing_key_id/key_ids"
+// @Failure     400     {object} map[string]string
+// @Failure     401     {object} map[string]string "error/code (step_up_required)"
+// @Failure     403     {object} map[string]string
+// @Router      /admin/jwt/rotate [post]
+func (app *application) handleRotateJWTSecret(w http.ResponseWriter, r *http.Request) error {
+	var input rotateJWTSecretReq
+	if err := app.readJSON(w, r, &input); err != nil {
+		return badRequest("Invalid request payload")
+	}
+
+	err := app.rotateJWTKey(r.Context(), input.KeyID)
+	if errors.Is(err, errUnknownJWTKey) {
+		return badRequest("key_id is not one of the configured JWT secrets")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rotate JWT secret: %w", err)
+	}
+
+	admin := app.contextGetUser(r)
+	app.logger.InfoContext(r.Context(), "audit: rotated the JWT secret", "user_id", admin.ID, "key_id", input.KeyID)
+
+	return app.writeJSON(w, http.StatusOK, envelope{
+		"success":        true,
+		"message":        "JWT secret rotated",
+		"signing_key_id": app.jwtKeys.signingKeyID(),
+		"key_ids":        app.jwtKeys.keyIDs(),
+	}, nil)
+}
+
+// swagger:model testSMSReq
+type testSMSReq struct {
+	// required: true
+	PhoneNumber string `json:"phone_number"`
+}
+
+// handleTestSMS godoc
+// @Summary     Send test SMS
+// @Description Sends a test message through the configured SMS provider. Only numbers in the SMS test allowlist are accepted.
+// @Tags        Admin
+// @Security    BearerAuth
+// @Accept      json
+// @Produce     json
+// @Param       payload body     testSMSReq true "Allowlisted destination"
+// @Success     200     {object} map[string]interface{} "success/result"
+// @Failure     400     {object} map[string]string
+// @Failure     403     {object} map[string]string
+// @Failure     422     {object} map[string]string     "invalid phone number"
+// @Failure     502     {object} map[string]interface{} "success/error"
+// @Router      /admin/sms/test [post]
+func (app *application) handleTestSMS(w http.ResponseWriter, r *http.Request) error {
+	var input struct {
+		PhoneNumber string `json:"phone_number"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		return badRequest("Invalid request payload")
+	}
+	phone, err := data.ValidatePhoneNumber(input.PhoneNumber)
+	if err != nil {
+		return invalidPhone(err)
+	}
+	input.PhoneNumber = phone
+	if !app.isSMSTestNumber(input.PhoneNumber) {
+		return &apiError{Status: http.StatusForbidden, Code: "forbidden", Message: "Phone number is not in the SMS test allowlist"}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	admin := app.contextGetUser(r)
+	app.logger.InfoContext(r.Context(), "audit: sent a test SMS", "user_id", admin.ID, "phone", maskPhone(input.PhoneNumber))
+
+	// the provider's error is the point of a test send, so it is shown
+	// to the admin as is
+	result, err := app.sms.Send(ctx, input.PhoneNumber, "Test message from OTP Login")
+	if err != nil {
+		return &apiError{Status: http.StatusBadGateway, Code: "sms_failed", Message: err.Error(), Err: err,
+			Fields: envelope{"success": false}}
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{
+		"success": true,
+		"result":  result,
+	}, nil)
+}
+
+// swagger:model resetRateLimitReq
+type resetRateLimitReq struct {
+	// required: true
+	PhoneNumber string `json:"phone_number"`
+}
+
+// handleResetRateLimit godoc
+// @Summary     Reset OTP rate limit
+// @Description Clears the OTP rate-limit and lockout keys for a phone so it can request OTPs again immediately.
+// @Tags        Admin
+// @Security    BearerAuth
+// @Accept      json
+// @Produce     json
+// @Param       payload body     resetRateLimitReq true "Phone to reset"
+// @Success     200     {object} map[string]interface{} "success/cleared"
+// @Failure     400     {object} map[string]string
+// @Failure     403     {object} map[string]string
+// @Failure     422     {object} map[string]string
+// @Failure     500     {object} map[string]string
+// @Router      /admin/rate-limit/reset [post]
+func (app *application) handleResetRateLimit(w http.ResponseWriter, r *http.Request) error {
+	var input struct {
+		PhoneNumber string `json:"phone_number"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		return badRequest("Invalid request payload")
+	}
+	phone, err := data.ValidatePhoneNumber(input.PhoneNumber)
+	if err != nil {
+		return invalidPhone(err)
+	}
+	input.PhoneNumber = phone
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	cleared, err := app.clearOTPRateLimits(ctx, otpRateLimitKeys(input.PhoneNumber))
+	if err != nil {
+		return fmt.Errorf("failed to reset rate limit: %w", err)
+	}
+
+	admin := app.contextGetUser(r)
+	app.logger.InfoContext(r.Context(), "audit: reset the OTP rate limit", "user_id", admin.ID, "phone", maskPhone(input.PhoneNumber), "cleared", cleared)
+
+	return app.writeJSON(w, http.StatusOK, envelope{
+		"success": true,
+		"cleared": cleared,
+	}, nil)
+}
+
+type introspectTokenReq struct {
+	Token string `json:"token"`
+}
+
+// handleIntrospectToken godoc
+// @Summary     Introspect a refresh token
+// @Description Looks up a refresh token by its plaintext and returns its owner and expiry. The token is not used or changed and no session is issued.
+// @Tags        Admin
+// @Security    BearerAuth
+// @Accept      json
+// @Produce     json
+// @Param       payload body     introspectTokenReq true "Refresh token to inspect"
+// @Success     200     {object} map[string]interface{} "token: id/user_id/created_at/expiry/expired"
+// @Failure     400     {object} map[string]string
+// @Failure     403     {object} map[string]string
+// @Failure     404     {object} map[string]string
+// @Failure     500     {object} map[string]string
+// @Router      /admin/tokens/introspect [post]
+func (app *application) handleIntrospectToken(w http.ResponseWriter, r *http.Request) error {
+	var input introspectTokenReq
+	if err := app.readJSON(w, r, &input); err != nil {
+		return badRequest("Invalid request payload")
+	}
+	if input.Token == "" {
+		return badRequest("token is required")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	hash := sha256.Sum256([]byte(input.Token))
+	token, err := app.models.Token.GetByHash(ctx, hash[:])
+	if err != nil {
+		return err
+	}
+
+	admin := app.contextGetUser(r)
+	app.logger.InfoContext(r.Context(), "audit: introspected a refresh token", "user_id", admin.ID, "token_id", token.ID, "token_user_id", token.UserId)
+
+	return app.writeJSON(w, http.StatusOK, envelope{
+		"token": envelope{
+			"id":         token.ID,
+			"user_id":    token.UserId,
+			"created_at": token.CreatedAt,
+			"expiry":     token.Expiry,
+			"expired":    !token.Expiry.After(time.Now()),
+		},
+	}, nil)
+}
+
+// handleSMSOutbox godoc
+// @Summary     Simulated SMS outbox
+// @Description Lists the latest messages captured by the "simulate" SMS provider, newest first. Only registered when that provider is configured (never in production).
+// @Tags        Admin
+// @Security    BearerAuth
+// @Produce     json
+// @Success     200 {object} map[string][]sms.OutboxEntry "envelope with 'messages' key"
+// @Failure     403 {object} map[string]string
+// @Router      /admin/sms/outbox [get]
+func (app *application) handleSMSOutbox(w http.ResponseWriter, r *http.Request) error {
+	return app.writeJSON(w, http.StatusOK, envelope{"messages": app.outbox.Outbox()}, nil)
+}
+
+// handleRuntimeStats godoc
+// @Summary     Runtime stats
+// @Description Returns goroutine count, memory and GC stats, and database/Redis connection pool stats. Also served without a token to OTP_INTERNAL_CIDRS when listed in OTP_INTERNAL_ROUTES.
+// @Tags        Admin
+// @Security    BearerAuth
+// @Produce     json
+// @Success     200 {object} map[string]interface{} "runtime/db/redis"
+// @Failure     401 {object} map[string]string
+// @Failure     403 {object} map[string]string
+// @Router      /admin/runtime [get]
+func (app *application) handleRuntimeStats(w http.ResponseWriter, r *http.Request) error {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	var lastPause time.Duration
+	if mem.NumGC > 0 {
+		lastPause = time.Duration(mem.PauseNs[(mem.NumGC+255)%256])
+	}
+
+	dbStats := app.db.Stats()
+	poolStats := app.cache.PoolStats()
+
+	return app.writeJSON(w, http.StatusOK, envelope{
+		"runtime": envelope{
+			"goroutines":        runtime.NumGoroutine(),
+			"heap_alloc_bytes":  mem.HeapAlloc,
+			"heap_sys_bytes":    mem.HeapSys,
+			"heap_objects":      mem.HeapObjects,
+			"num_gc":            mem.NumGC,
+			"gc_pause_total_ms": float64(mem.PauseTotalNs) / float64(time.Millisecond),
+			"gc_pause_last_ms":  float64(lastPause) / float64(time.Millisecond),
+		},
+		"db": envelope{
+			"max_open_connections": dbStats.MaxOpenConnections,
+			"open_connections":     dbStats.OpenConnections,
+			"in_use":               dbStats.InUse,
+			"idle":                 dbStats.Idle,
+			"wait_count":           dbStats.WaitCount,
+			"wait_duration_ms":     dbStats.WaitDuration.Milliseconds(),
+		},
+		"redis": envelope{
+			"hits":        poolStats.Hits,
+			"misses":      poolStats.Misses,
+			"timeouts":    poolStats.Timeouts,
+			"total_conns": poolStats.TotalConns,
+			"idle_conns":  poolStats.IdleConns,
+			"stale_conns": poolStats.StaleConns,
+		},
+	}, nil)
+}
+
+// handleFlushOTPs godoc
+// @Summary     Invalidate all pending OTPs
+// @Description Deletes every pending OTP code and challenge window, e.g. during a security incident. Users have to request a new code. Rate limits and lockouts are left in place. Requires a recent OTP verification.
+// @Tags        Admin
+// @Security    BearerAuth
+// @Produce     json
+// @Success     200 {object} map[string]interface{} "success/cleared"
+// @Failure     401 {object} map[string]string "error/code (step_up_required)"
+// @Failure     403 {object} map[string]string
+// @Failure     500 {object} map[string]string
+// @Router      /admin/otp/flush [post]
+func (app *application) handleFlushOTPs(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	cleared, err := app.flushOTPChallenges(ctx)
+	admin := app.contextGetUser(r)
+	if err != nil {
+		// a partial flush still removed keys; record it before failing
+		app.logger.InfoContext(r.Context(), "audit: flushing pending OTPs failed", "user_id", admin.ID, "keys", cleared)
+		return fmt.Errorf("failed to flush OTPs: %w", err)
+	}
+	app.logger.InfoContext(r.Context(), "audit: flushed pending OTPs", "user_id", admin.ID, "keys", cleared)
+
+	return app.writeJSON(w, http.StatusOK, envelope{
+		"success": true,
+		"cleared": cleared,
+	}, nil)
+}
+
+// handleOTPOrigins godoc
+// @Summary     Recent OTP request origins
+// @Description Returns metadata (IP, user agent, masked phone, calling code, time) of the newest OTP requests for fraud scoring. Empty unless the origin log is enabled. Also served without a token to OTP_INTERNAL_CIDRS when listed in OTP_INTERNAL_ROUTES.
+// @Tags        Admin
+// @Security    BearerAuth
+// @Produce     json
+// @Param       limit query    int false "Number of entries (default 100, max 1000)"
+// @Success     200   {object} map[string]interface{} "envelope with 'requests' key"
+// @Failure     403   {object} map[string]string
+// @Failure     500   {object} map[string]string
+// @Router      /admin/otp/requests [get]
+func (app *application) handleOTPOrigins(w http.ResponseWriter, r *http.Request) error {
+	limit := atoiDefault(r.URL.Query().Get("limit"), 100)
+	limit = min(max(limit, 1), 1000)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	origins, err := app.recentOTPOrigins(ctx, limit)
+	if err != nil {
+		return fmt.Errorf("failed to read OTP origins: %w", err)
+	}
+	return app.writeJSON(w, http.StatusOK, envelope{"requests": origins}, nil)
+}
+
+// handleOTPStatus godoc
+// @Summary     OTP challenge status
+// @Description Shows whether a phone has a pending OTP, and for each purpose whether its code is pending and when it expires, plus failed attempts, resend cooldown and request rate-limit state. The codes themselves are never returned.
+// @Tags        Admin
+// @Security    BearerAuth
+// @Produce     json
+// @Param       phone query    string true "Phone number"
+// @Success     200   {object} map[string]interface{} "phone/active/purposes/attempts/rate_limit/..."
+// @Failure     400   {object} map[string]string
+// @Failure     403   {object} map[string]string
+// @Failure     422   {object} map[string]string
+// @Failure     500   {object} map[string]string
+// @Router      /admin/otp/status [get]
+func (app *application) handleOTPStatus(w http.ResponseWriter, r *http.Request) error {
+	phone := r.URL.Query().Get("phone")
+	phone, err := data.ValidatePhoneNumber(phone)
+	if err != nil {
+		return invalidPhone(err)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// only the TTLs of the code hashes are read, never their fields
+	active := false
+	purposes := envelope{}
+	for _, purpose := range purposeNames(app.conf.otpPurposes) {
+		codeTTL, err := app.cache.PTTL(ctx, otpCodeKey(phone, purpose)).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read OTP status: %w", err)
+		}
+		// PTTL reports -2 for a missing key and -1 for one without expiry
+		expiresIn := int64(0)
+		if codeTTL > 0 {
+			expiresIn = ceilSeconds(codeTTL)
+		}
+		purposes[purpose] = envelope{"active": codeTTL != -2, "expires_in": expiresIn}
+		active = active || codeTTL != -2
+	}
+	attempts, err := app.otpAttempts(ctx, phone)
+	if err != nil {
+		return fmt.Errorf("failed to read OTP attempts: %w", err)
+	}
+	cooldown, err := app.otpCooldownRemaining(ctx, phone)
+	if err != nil {
+		return fmt.Errorf("failed to read resend cooldown: %w", err)
+	}
+	limit, err := app.peekOTPRateLimit(ctx, phone)
+	if err != nil {
+		return fmt.Errorf("failed to read rate limit: %w", err)
+	}
+
+	admin := app.contextGetUser(r)
+	app.logger.InfoContext(r.Context(), "audit: viewed the OTP status", "user_id", admin.ID, "phone", maskPhone(phone))
+
+	return app.writeJSON(w, http.StatusOK, envelope{
+		"phone":               phone,
+		"active":              active,
+		"purposes":            purposes,
+		"attempts":            attempts,
+		"max_attempts":        app.conf.otp.maxAttempts,
+		"locked":              attempts >= int64(app.conf.otp.maxAttempts),
+		"resend_available_in": ceilSeconds(cooldown),
+		"rate_limit": envelope{
+			"algorithm": app.conf.otp.rateLimitAlgorithm,
+			"count":     limit.count,
+			"limit":     limit.limit,
+			"allowed":   limit.allowed,
+			"reset_in":  ceilSeconds(limit.resetIn),
+		},
+	}, nil)
+}
+
+// ActiveUsersListResponse is the payload returned by /admin/users/active.
+type ActiveUsersListResponse struct {
+	Items []data.ActiveUser `json:"items"`
+	Pagination
+}
+
+// ActiveUsersListResponseEnvelope is used only for Swagger to document the envelope shape.
+type ActiveUsersListResponseEnvelope struct {
+	Data ActiveUsersListResponse `json:"data"`
+}
+
+// handleListActiveUsers godoc
+// @Summary     Recently active users
+// @Description Lists users whose last login or refresh token use is at or after 'since', most recent first. last_active_at is when the user last logged in or used a refresh token; requests made with an access token are not tracked, so a user can be active for up to an access token lifetime (longer with sliding sessions) after it. Users who never signed in are not included.
+// @Tags        Admin
+// @Security    BearerAuth
+// @Produce     json
+// @Param       since     query    string true  "RFC 3339 timestamp, e.g. 2024-05-01T00:00:00Z"
+// @Param       page      query    int    false "Page number (1-based, default 1)"
+// @Param       page_size query    int    false "Page size (max 100, default 20)"
+// @Success     200       {object} map[string]ActiveUsersListResponseEnvelope "envelope with 'data' key ('response' in legacy mode)"
+// @Failure     400       {object} map[string]string
+// @Failure     403       {object} map[string]string
+// @Failure     500       {object} map[string]string
+// @Router      /admin/users/active [get]
+func (app *application) handleListActiveUsers(w http.ResponseWriter, r *http.Request) error {
+	since, err := time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+	if err != nil {
+		return badRequest("since must be an RFC 3339 timestamp")
+	}
+	page, pageSize, err := app.parsePagination(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	users, total, err := app.models.User.ListActiveSince(ctx, since, page, pageSize)
+	if err != nil {
+		return fmt.Errorf("failed to fetch active users: %w", err)
+	}
+
+	resp := ActiveUsersListResponse{
+		Items:      users,
+		Pagination: paginationMeta(page, pageSize, total),
+	}
+	return app.writeJSON(w, http.StatusOK, app.listEnvelope(resp), nil)
+}
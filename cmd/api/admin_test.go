@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var testAdmin = &data.User{ID: 1, PhoneNumber: "+989120000001"}
+
+func TestAdminTestSMS(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.sms.testNumbers = []string{testPhone}
+	sender := &fakeSender{}
+	app.sms = sender
+	h := withUser(app, testAdmin, app.handle(app.handleTestSMS))
+
+	w := postJSON(h, "/admin/sms/test", `{"phone_number":"+98 912 123 4567"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("allowlisted number: want 200, got %d %s", w.Code, w.Body)
+	}
+	body := decodeBody(t, w)
+	if result, _ := body["result"].(map[string]any); result["provider"] != "fake" || result["status"] != "sent" {
+		t.Fatalf("result = %v, want the provider's answer", body["result"])
+	}
+	if len(sender.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sender.sent))
+	}
+
+	w = postJSON(h, "/admin/sms/test", `{"phone_number":"+989129999999"}`)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("number off the allowlist: want 403, got %d %s", w.Code, w.Body)
+	}
+	if len(sender.sent) != 1 {
+		t.Fatal("a message went to a number off the allowlist")
+	}
+
+	sender.err = errors.New("provider rejected the message")
+	w = postJSON(h, "/admin/sms/test", `{"phone_number":"`+testPhone+`"}`)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("provider failure: want 502, got %d %s", w.Code, w.Body)
+	}
+	if body := decodeBody(t, w); body["success"] != false || body["error"] != "provider rejected the message" {
+		t.Fatalf("provider failure body = %v", body)
+	}
+}
+
+func TestAdminResetRateLimit(t *testing.T) {
+	app, _ := newTestApp(t)
+	for i := 0; i < app.conf.otp.rateLimitMax; i++ {
+		requestCount(t, app, testPhone)
+	}
+	if _, err := app.recordFailedOTPAttempt(context.Background(), testPhone); err != nil {
+		t.Fatal(err)
+	}
+	if w := postJSON(app.handle(app.handleRequestOTP), "/request", `{"phone_number":"`+testPhone+`"}`); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit: want 429, got %d %s", w.Code, w.Body)
+	}
+
+	h := withUser(app, testAdmin, app.handle(app.handleResetRateLimit))
+	w := postJSON(h, "/admin/rate-limit/reset", `{"phone_number":"`+testPhone+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset: want 200, got %d %s", w.Code, w.Body)
+	}
+	cleared, _ := decodeBody(t, w)["cleared"].([]any)
+	for _, key := range []string{otpRateLimitKey(testPhone), otpAttemptsKey(testPhone)} {
+		if !slices.Contains(cleared, any(key)) {
+			t.Errorf("cleared = %v, missing %s", cleared, key)
+		}
+	}
+
+	requestTestOTP(t, app, testPhone)
+}
+
+func TestAdminRuntimeStats(t *testing.T) {
+	app, _ := newTestApp(t)
+	mockDB(t, app)
+	app.db.SetMaxOpenConns(5)
+	if err := app.db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.cache.Ping(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	withUser(app, testAdmin, app.handle(app.handleRuntimeStats))(w, httptest.NewRequest(http.MethodGet, "/admin/runtime", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", w.Code, w.Body)
+	}
+	body := decodeBody(t, w)
+
+	positive := map[string][]string{
+		"runtime": {"goroutines", "heap_alloc_bytes", "heap_sys_bytes", "heap_objects"},
+		"db":      {"max_open_connections", "open_connections"},
+		"redis":   {"total_conns"},
+	}
+	for section, fields := range positive {
+		values, ok := body[section].(map[string]any)
+		if !ok {
+			t.Fatalf("missing %q section in %s", section, w.Body)
+		}
+		for _, f := range fields {
+			if n, _ := values[f].(float64); n <= 0 {
+				t.Errorf("%s.%s = %v, want > 0", section, f, values[f])
+			}
+		}
+	}
+	if db := body["db"].(map[string]any); db["max_open_connections"] != float64(5) {
+		t.Errorf("db.max_open_connections = %v, want 5", db["max_open_connections"])
+	}
+	for _, f := range []string{"in_use", "idle", "wait_count", "wait_duration_ms"} {
+		if _, ok := body["db"].(map[string]any)[f]; !ok {
+			t.Errorf("db.%s missing", f)
+		}
+	}
+}
+
+func TestAdminOTPStatusPerPurpose(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.sms = &fakeSender{}
+	if w := postJSON(app.handle(app.handleRequestOTP), "/request", `{"phone_number":"`+testPhone+`","purpose":"step_up"}`); w.Code != http.StatusOK {
+		t.Fatalf("/request: want 200, got %d %s", w.Code, w.Body)
+	}
+
+	w := httptest.NewRecorder()
+	h := withUser(app, testAdmin, app.handle(app.handleOTPStatus))
+	h(w, httptest.NewRequest(http.MethodGet, "/admin/otp/status?phone="+url.QueryEscape(testPhone), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", w.Code, w.Body)
+	}
+	body := decodeBody(t, w)
+	if body["active"] != true {
+		t.Fatalf("active = %v with a pending step_up code", body["active"])
+	}
+	purposes, _ := body["purposes"].(map[string]any)
+	for _, name := range purposeNames(app.conf.otpPurposes) {
+		status, ok := purposes[name].(map[string]any)
+		if !ok {
+			t.Fatalf("no status for purpose %s in %v", name, purposes)
+		}
+		if pending := name == "step_up"; status["active"] != pending {
+			t.Errorf("%s: active = %v, want %v", name, status["active"], pending)
+		}
+	}
+	if stepUp := purposes["step_up"].(map[string]any); stepUp["expires_in"] != float64(60) {
+		t.Errorf("step_up expires_in = %v, want 60", stepUp["expires_in"])
+	}
+}
+
+func TestAdminListActiveUsers(t *testing.T) {
+	app, _ := newTestApp(t)
+	mock := mockDB(t, app)
+	lastUsed := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`MAX\(last_used_at\) AS last_active_at`).
+		WithArgs(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), 20, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "phone_number", "name", "created_at", "last_active_at", "total_count"}).
+			AddRow(7, testPhone, "Sara", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), lastUsed, 1))
+
+	h := withUser(app, testAdmin, app.handle(app.handleListActiveUsers))
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/admin/users/active?since=2024-05-01T00:00:00Z", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", w.Code, w.Body)
+	}
+	items, _ := decodeBody(t, w)["data"].(map[string]any)["items"].([]any)
+	if len(items) != 1 || items[0].(map[string]any)["last_active_at"] != lastUsed.Format(time.RFC3339) {
+		t.Fatalf("items = %v, want the user with their last token use", items)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/admin/users/active?since=yesterday", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("bad since: want 400, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestAdminIntrospectToken(t *testing.T) {
+	app, _ := newTestApp(t)
+	mock := mockDB(t, app)
+	hash := sha256.Sum256([]byte("refresh-token"))
+	expiry := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	mock.ExpectQuery(`SELECT id, user_id, created_at, expiry FROM tokens WHERE hash = \$1`).
+		WithArgs(hash[:]).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "created_at", "expiry"}).
+			AddRow(3, 7, expiry.Add(-24*time.Hour), expiry))
+	mock.ExpectQuery(`FROM tokens WHERE hash = \$1`).WillReturnError(sql.ErrNoRows)
+
+	h := withUser(app, testAdmin, app.handle(app.handleIntrospectToken))
+	w := postJSON(h, "/admin/tokens/introspect", `{"token":"refresh-token"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", w.Code, w.Body)
+	}
+	token, _ := decodeBody(t, w)["token"].(map[string]any)
+	if token["id"] != float64(3) || token["user_id"] != float64(7) || token["expired"] != true {
+		t.Fatalf("token = %v, want the expired token 3 of user 7", token)
+	}
+
+	if w := postJSON(h, "/admin/tokens/introspect", `{"token":"unknown"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown token: want 404, got %d %s", w.Code, w.Body)
+	}
+	if w := postJSON(h, "/admin/tokens/introspect", `{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("missing token: want 400, got %d %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAdminFlushOTPs(t *testing.T) {
+	app, mr := newTestApp(t)
+	other := "+989120000002"
+	flushed := []string{
+		otpCodeKey(testPhone, purposeLogin),
+		otpCodeKey(testPhone, "step_up"),
+		otpChallengeKey(testPhone),
+		otpCodeKey(other, purposeLogin),
+	}
+	kept := []string{otpRateLimitKey(testPhone), otpAttemptsKey(other)}
+	for _, key := range append(slices.Clone(flushed), kept...) {
+		mr.Set(key, "1")
+	}
+
+	w := httptest.NewRecorder()
+	withUser(app, testAdmin, app.handle(app.handleFlushOTPs))(w, httptest.NewRequest(http.MethodPost, "/admin/otp/flush", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", w.Code, w.Body)
+	}
+	if cleared := decodeBody(t, w)["cleared"]; cleared != float64(len(flushed)) {
+		t.Errorf("cleared = %v, want %d", cleared, len(flushed))
+	}
+	for _, key := range flushed {
+		if mr.Exists(key) {
+			t.Errorf("%s survived the flush", key)
+		}
+	}
+	for _, key := range kept {
+		if !mr.Exists(key) {
+			t.Errorf("%s was flushed, rate limits must stay", key)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maskPhone keeps the first three and last two characters of a phone
+// number, e.g. "+12******90", enough to correlate without exposing it.
+func maskPhone(phone string) string {
+	if len(phone) <= 5 {
+		return strings.Repeat("*", len(phone))
+	}
+	return phone[:3] + strings.Repeat("*", len(phone)-5) + phone[len(phone)-2:]
+}
+
+// eventLimiter allows at most perSecond events per wall-clock second.
+type eventLimiter struct {
+	mu        sync.Mutex
+	perSecond int
+	second    int64
+	count     int
+	dropped   int
+}
+
+// allow reports whether an event may be emitted now, and how many were
+// dropped in the previous second (reported once, with the first event after).
+func (l *eventLimiter) allow() (ok bool, dropped int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().Unix()
+	if now != l.second {
+		l.second, l.count = now, 0
+	}
+	if l.count >= l.perSecond {
+		l.dropped++
+		return false, 0
+	}
+	l.count++
+	dropped, l.dropped = l.dropped, 0
+	return true, dropped
+}
+
+// logVerifyFailure emits a structured warn event for a failed verification,
+// meant for fraud analysis. The phone is masked and the submitted code is
+// never logged.
+func (app *application) logVerifyFailure(r *http.Request, phone, reason string, attempts int64) {
+	if !app.conf.otp.failureLog {
+		return
+	}
+	ok, dropped := app.verifyFailureLimiter.allow()
+	if !ok {
+		return
+	}
+	app.logger.WarnContext(r.Context(), "otp_verify_failed",
+		"phone", maskPhone(phone),
+		"ip", app.clientIP(r),
+		"attempts", attempts,
+		"reason", reason,
+		"dropped", dropped)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMaskPhone(t *testing.T) {
+	tests := []struct{ phone, want string }{
+		{"+989121234567", "+98********67"},
+		{"12345", "*****"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := maskPhone(tt.phone); got != tt.want {
+			t.Errorf("maskPhone(%q) = %q, want %q", tt.phone, got, tt.want)
+		}
+	}
+}
+
+func TestEventLimiterReportsDropped(t *testing.T) {
+	l := &eventLimiter{perSecond: 2}
+	var allowed []bool
+	for i := 0; i < 4; i++ {
+		ok, _ := l.allow()
+		allowed = append(allowed, ok)
+	}
+	if !slices.Equal(allowed, []bool{true, true, false, false}) {
+		t.Fatalf("allow() = %v, want two events then none", allowed)
+	}
+
+	// the first event of the next second reports what was dropped, once
+	l.second--
+	if ok, dropped := l.allow(); !ok || dropped != 2 {
+		t.Fatalf("next second: allow() = %v, %d; want true, 2", ok, dropped)
+	}
+	if _, dropped := l.allow(); dropped != 0 {
+		t.Fatalf("dropped reported twice: %d", dropped)
+	}
+}
+
+func TestVerifyFailureLogIsMasked(t *testing.T) {
+	app, _ := newTestApp(t)
+	var logs bytes.Buffer
+	app.logger = slog.New(slog.NewTextHandler(&logs, nil))
+	issueTestOTP(t, app, testPhone, "123456")
+
+	if w := checkTestOTP(t, app, testPhone, "654321"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("want 401, got %d %s", w.Code, w.Body)
+	}
+	out := logs.String()
+	for _, want := range []string{"otp_verify_failed", "phone=" + maskPhone(testPhone), "reason=wrong_code", "attempts=1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log %q lacks %q", out, want)
+		}
+	}
+	for _, leak := range []string{testPhone, "654321", "123456"} {
+		if strings.Contains(out, leak) {
+			t.Errorf("log %q contains %q", out, leak)
+		}
+	}
+
+	logs.Reset()
+	app.conf.otp.failureLog = false
+	checkTestOTP(t, app, testPhone, "654321")
+	if strings.Contains(logs.String(), "otp_verify_failed") {
+		t.Error("failure logged with the event disabled")
+	}
+}
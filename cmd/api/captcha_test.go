@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubCaptcha accepts the token "solved" and fails with err when set.
+type stubCaptcha struct {
+	err   error
+	calls int
+}
+
+func (c *stubCaptcha) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	c.calls++
+	return token == "solved", c.err
+}
+
+func TestSiteverifyCaptcha(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("secret") != "s3cret" || r.PostFormValue("remoteip") != "203.0.113.9" {
+			t.Errorf("form = %v", r.PostForm)
+		}
+		w.WriteHeader(status)
+		if r.PostFormValue("response") == "solved" {
+			w.Write([]byte(`{"success":true}`))
+			return
+		}
+		w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+	}))
+	defer srv.Close()
+
+	v, err := newCaptchaVerifier(captchaConf{provider: "turnstile", secret: "s3cret", url: srv.URL}, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if ok, err := v.Verify(ctx, "solved", "203.0.113.9"); !ok || err != nil {
+		t.Fatalf("solved token: %t, %v", ok, err)
+	}
+	if ok, err := v.Verify(ctx, "forged", "203.0.113.9"); ok || err != nil {
+		t.Fatalf("forged token: %t, %v", ok, err)
+	}
+	status = http.StatusBadGateway
+	if _, err := v.Verify(ctx, "solved", "203.0.113.9"); err == nil {
+		t.Fatal("provider error not reported")
+	}
+
+	if _, err := newCaptchaVerifier(captchaConf{provider: "recaptcha"}, srv.Client()); err == nil {
+		t.Error("missing secret accepted")
+	}
+	if _, err := newCaptchaVerifier(captchaConf{provider: "geetest", secret: "s"}, srv.Client()); err == nil {
+		t.Error("unknown provider accepted")
+	}
+}
+
+func TestRequestOTPCaptcha(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.sms = &fakeSender{}
+	captcha := &stubCaptcha{}
+	app.captcha = captcha
+	app.conf.captcha.bypassNumbers = []string{"+989120000009"}
+	request := func(phone, token string) *httptest.ResponseRecorder {
+		return postJSON(app.handle(app.handleRequestOTP), "/request", `{"phone_number":"`+phone+`","captcha_token":"`+token+`"}`)
+	}
+
+	if w := request(testPhone, ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("missing token: want 400, got %d %s", w.Code, w.Body)
+	}
+	if w := request(testPhone, "forged"); w.Code != http.StatusForbidden {
+		t.Fatalf("rejected token: want 403, got %d %s", w.Code, w.Body)
+	}
+	captcha.err = errors.New("siteverify unreachable")
+	if w := request(testPhone, "solved"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("provider down: want 503, got %d %s", w.Code, w.Body)
+	}
+	captcha.err = nil
+
+	// failed CAPTCHAs don't use up the number's allowance
+	if n := requestCount(t, app, testPhone); n != 1 {
+		t.Fatalf("request count = %d after failed CAPTCHAs, want 1", n)
+	}
+	if w := request(testPhone, "solved"); w.Code != http.StatusOK {
+		t.Fatalf("solved token: want 200, got %d %s", w.Code, w.Body)
+	}
+
+	calls := captcha.calls
+	if w := request("+989120000009", ""); w.Code != http.StatusOK {
+		t.Fatalf("bypass number: want 200, got %d %s", w.Code, w.Body)
+	}
+	if captcha.calls != calls {
+		t.Fatal("bypass number was checked with the provider")
+	}
+}
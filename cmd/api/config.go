@@ -0,0 +1,672 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+)
+
+// devJWTSecret is the development default, refused in production.
+const devJWTSecret = "my-secret"
+
+// defaultConfig returns the development defaults that loadConfig layers the
+// environment and command-line flags over.
+func defaultConfig() *config {
+	return &config{
+		port: 8000,
+		env:  "development",
+		db: database{
+			host:         "localhost",
+			port:         5433,
+			user:         "postgres",
+			password:     "1234",
+			name:         "optlogin",
+			sslmode:      "disable",
+			maxOpenConns: 25,
+			maxIdleConns: 25,
+			maxIdleTime:  time.Minute,
+		},
+		redis: redisConf{
+			mode:     "single",
+			addr:     "localhost:6379",
+			password: "secret",
+			db:       0,
+		},
+		jwt: jwtConf{
+			secret: devJWTSecret,
+		},
+		sms: smsConf{
+			provider:           "log",
+			breakerEnabled:     true,
+			breakerMaxFailures: 5,
+			breakerOpenTimeout: 30 * time.Second,
+			maxSegments:        1,
+			outboxSize:         50,
+		},
+		otp: otpConf{
+			maxAttempts:          5,
+			attemptsTTL:          15 * time.Minute,
+			rateLimitMax:         3,
+			rateLimitWindow:      10 * time.Minute,
+			rateLimitAlgorithm:   "fixed",
+			resendCooldown:       time.Minute,
+			maxChallengeLifetime: 15 * time.Minute,
+			failureLog:           true,
+			failureLogPerSecond:  20,
+			originLogSize:        1000,
+			maxResends:           3,
+			resendWindow:         15 * time.Minute,
+		},
+		shedding: sheddingConf{
+			maxLatency:    250 * time.Millisecond,
+			checkInterval: 5 * time.Second,
+			lowPriority:   []string{"/users"},
+		},
+		magic: magicConf{
+			baseURL: "http://localhost:8000",
+			ttl:     2 * time.Minute,
+		},
+		lineCheck: lineCheckConf{
+			provider:     "prefixes",
+			blockedTypes: []string{lineTypeVoIP, lineTypeDisposable},
+			cacheTTL:     24 * time.Hour,
+		},
+		slidingSession: slidingSessionConf{
+			refreshWithin: 12 * time.Hour,
+			maxLifetime:   30 * 24 * time.Hour,
+		},
+		metricsPush: metricsPushConf{
+			interval: 15 * time.Second,
+			job:      "go_otp_login",
+		},
+		http: httpClientConf{
+			timeout:               10 * time.Second,
+			dialTimeout:           3 * time.Second,
+			tlsHandshakeTimeout:   3 * time.Second,
+			responseHeaderTimeout: 5 * time.Second,
+			idleConnTimeout:       90 * time.Second,
+			maxIdleConns:          100,
+			maxIdleConnsPerHost:   10,
+		},
+		jsonNaming:       "snake",
+		stepUpMaxAge:     10 * time.Minute,
+		refreshTokenTTL:  30 * 24 * time.Hour,
+		refreshAccessTTL: 15 * time.Minute,
+		maxPageOffset:    10000,
+		maskLogPhones:    true,
+
+		refreshTokenBytes: data.MinTokenBytes,
+		autoCreateUsers:   true,
+		otpLength:         defaultOTPLength,
+
+		emptySearchListsAll: true,
+		otpPurposes: map[string]otpPurposeConf{
+			purposeLogin:   {},
+			"phone_change": {ttl: 5 * time.Minute},
+			"step_up":      {ttl: time.Minute},
+		},
+	}
+}
+
+// loadConfig builds the configuration from defaultConfig, then OTP_*
+// environment variables, then flags in args, each overriding the last, and
+// validates the result. flag.ErrHelp is returned after -h printed usage.
+func loadConfig(args []string, getenv func(string) string, environ []string) (*config, error) {
+	conf := defaultConfig()
+	conf.features = loadFeatureFlags(environ)
+
+	var problems []string
+	for _, s := range stringSettings(conf) {
+		if v, ok := lookupEnv(getenv, s.env); ok {
+			*s.field = v
+		}
+	}
+	for _, s := range intSettings(conf) {
+		v, ok := lookupEnv(getenv, s.env)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %q is not a number", s.env, v))
+			continue
+		}
+		*s.field = n
+	}
+	for _, s := range boolSettings(conf) {
+		v, ok := lookupEnv(getenv, s.env)
+		if !ok {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %q is not true or false", s.env, v))
+			continue
+		}
+		*s.field = b
+	}
+	for _, s := range listSettings(conf) {
+		if v, ok := lookupEnv(getenv, s.env); ok {
+			*s.field = splitList(v)
+		}
+	}
+	if v, ok := lookupEnv(getenv, "OTP_AUDIENCE_RULES"); ok {
+		rules, err := parseAudienceRules(v)
+		if err != nil {
+			problems = append(problems, "OTP_AUDIENCE_RULES: "+err.Error())
+		} else {
+			conf.audienceRules = rules
+		}
+	}
+	if v, ok := lookupEnv(getenv, "OTP_ADMIN_IDS"); ok {
+		conf.adminIDs = nil
+		for _, id := range splitList(v) {
+			n, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("OTP_ADMIN_IDS: %q is not a user ID", id))
+				continue
+			}
+			conf.adminIDs = append(conf.adminIDs, n)
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid settings: %s", strings.Join(problems, "; "))
+	}
+
+	// OTP_<SECTION>_<FIELD>=30s etc. override the duration defaults
+	if err := loadDurations(conf, getenv); err != nil {
+		return nil, err
+	}
+
+	// comma-separated CIDRs or IPs, e.g. "10.0.0.0/8,127.0.0.1"
+	trustedProxies, err := parseTrustedProxies(strings.Split(getenv("OTP_TRUSTED_PROXIES"), ","))
+	if err != nil {
+		return nil, fmt.Errorf("OTP_TRUSTED_PROXIES: %w", err)
+	}
+	conf.trustedProxies = trustedProxies
+
+	// same format as OTP_TRUSTED_PROXIES; routes are comma-separated paths
+	internalCIDRs, err := parseTrustedProxies(strings.Split(getenv("OTP_INTERNAL_CIDRS"), ","))
+	if err != nil {
+		return nil, fmt.Errorf("OTP_INTERNAL_CIDRS: %w", err)
+	}
+	conf.internalAccess.cidrs = internalCIDRs
+
+	// e.g. OTP_ALLOWED_HOSTS="api.example.com,*.example.com"
+	conf.host.allowed = parseHostList(getenv("OTP_ALLOWED_HOSTS"))
+	conf.host.canonical = strings.ToLower(strings.TrimSpace(getenv("OTP_CANONICAL_HOST")))
+
+	conf.dbReplicaDSN = getenv("OTP_DB_REPLICA_DSN")
+
+	// e.g. OTP_PURPOSES="phone_change=5m:8,delete_account=2m"; entries are
+	// added to or replace the defaults
+	if v, ok := lookupEnv(getenv, "OTP_PURPOSES"); ok {
+		purposes, err := parseOTPPurposes(v)
+		if err != nil {
+			return nil, fmt.Errorf("OTP_PURPOSES: %w", err)
+		}
+		for name, p := range purposes {
+			conf.otpPurposes[name] = p
+		}
+	}
+
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	fs.IntVar(&conf.port, "port", conf.port, "HTTP listen port (OTP_PORT)")
+	fs.StringVar(&conf.env, "env", conf.env, "development or production (OTP_ENV)")
+	fs.StringVar(&conf.db.dsn, "db-dsn", conf.db.dsn, "PostgreSQL DSN (OTP_DB_DSN)")
+	fs.StringVar(&conf.redis.addr, "redis-addr", conf.redis.addr, "Redis address (OTP_REDIS_ADDR)")
+	fs.StringVar(&conf.redis.password, "redis-password", conf.redis.password, "Redis password (OTP_REDIS_PASSWORD)")
+	fs.StringVar(&conf.jwt.secret, "jwt-secret", conf.jwt.secret, "comma-separated JWT secrets, newest first (OTP_JWT_SECRET)")
+	fs.IntVar(&conf.otp.rateLimitMax, "rate-limit-max", conf.otp.rateLimitMax, "OTP requests allowed per phone within the window (OTP_RATE_LIMIT_MAX)")
+	fs.DurationVar(&conf.otp.rateLimitWindow, "rate-limit-window", conf.otp.rateLimitWindow, "OTP request rate-limit window (OTP_RATE_LIMIT_WINDOW)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := validateConfig(conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// validateConfig rejects settings the server can't run with. Every field
+// that can be set from the environment is checked here, so a typo fails at
+// startup rather than on the first request that reads it.
+func validateConfig(conf *config) error {
+	if conf.env != "development" && conf.env != "production" {
+		return fmt.Errorf("env must be development or production, got %q", conf.env)
+	}
+	if conf.env == "production" {
+		secret := strings.TrimSpace(conf.jwt.secret)
+		if secret == "" {
+			return errors.New("JWT secret is empty; set OTP_JWT_SECRET or -jwt-secret")
+		}
+		if slices.Contains(strings.Split(secret, ","), devJWTSecret) {
+			return errors.New("JWT secret is the development default; set OTP_JWT_SECRET or -jwt-secret")
+		}
+		primary, previous := parseJWTSecrets(secret)
+		for _, key := range append([][]byte{primary}, previous...) {
+			if len(key) < minJWTSecretLength {
+				return fmt.Errorf("JWT secrets must be at least %d characters", minJWTSecretLength)
+			}
+		}
+	}
+
+	var problems []string
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(validPort(conf.port), "port must be between 1 and 65535, got %d", conf.port)
+	if conf.db.dsn == "" {
+		check(validPort(conf.db.port), "db port must be between 1 and 65535, got %d", conf.db.port)
+		check(conf.db.host != "" && conf.db.name != "", "db host and name are required without a DSN")
+	}
+	check(conf.db.maxOpenConns >= 0 && conf.db.maxIdleConns >= 0,
+		"db connection limits must not be negative")
+
+	switch conf.redis.mode {
+	case "single":
+		check(conf.redis.addr != "", "redis addr is required in single mode")
+	case "sentinel":
+		check(len(conf.redis.addrs) > 0 && conf.redis.masterName != "",
+			"redis sentinel mode needs OTP_REDIS_ADDRS and OTP_REDIS_MASTER")
+	case "cluster":
+		check(len(conf.redis.addrs) > 0, "redis cluster mode needs OTP_REDIS_ADDRS")
+		check(conf.redis.db == 0, "redis db must be 0 in cluster mode, got %d", conf.redis.db)
+	default:
+		problems = append(problems, fmt.Sprintf("redis mode must be single, sentinel or cluster, got %q", conf.redis.mode))
+	}
+	check(conf.redis.db >= 0, "redis db must not be negative, got %d", conf.redis.db)
+
+	switch conf.sms.provider {
+	case "log", "simulate":
+	case "http":
+		check(validURL(conf.sms.url), "sms url must be an absolute URL for the http provider, got %q", conf.sms.url)
+	default:
+		problems = append(problems, fmt.Sprintf("sms provider must be log, simulate or http, got %q", conf.sms.provider))
+	}
+	check(!conf.sms.breakerEnabled || conf.sms.breakerMaxFailures >= 1,
+		"sms breaker max failures must be at least 1, got %d", conf.sms.breakerMaxFailures)
+	check(conf.sms.quotaPerSecond >= 0 && conf.sms.quotaPerDay >= 0, "sms quotas must not be negative")
+	check(conf.sms.outboxSize >= 1, "sms outbox size must be at least 1, got %d", conf.sms.outboxSize)
+	check(conf.sms.maxSegments >= 1, "sms max segments must be at least 1, got %d", conf.sms.maxSegments)
+	check(conf.sms.branding.SupportURL == "" || validURL(conf.sms.branding.SupportURL),
+		"sms support url must be an absolute URL, got %q", conf.sms.branding.SupportURL)
+
+	check(conf.otp.maxAttempts >= 1, "otp max attempts must be at least 1, got %d", conf.otp.maxAttempts)
+	check(conf.otp.attemptsTTL > 0, "otp attempts TTL must be positive")
+	check(conf.otp.reuseWindow >= 0, "otp reuse window must not be negative, got %d", conf.otp.reuseWindow)
+	check(conf.otp.rateLimitMax >= 1, "otp rate limit max must be at least 1, got %d", conf.otp.rateLimitMax)
+	// the fixed window is kept in whole seconds
+	check(conf.otp.rateLimitWindow >= time.Second, "otp rate limit window must be at least 1s, got %s", conf.otp.rateLimitWindow)
+	check(conf.otp.rateLimitAlgorithm == "fixed" || conf.otp.rateLimitAlgorithm == "sliding",
+		"otp rate limit algorithm must be fixed or sliding, got %q", conf.otp.rateLimitAlgorithm)
+	check(!conf.otp.failureLog || conf.otp.failureLogPerSecond >= 1,
+		"otp failure log per second must be at least 1, got %d", conf.otp.failureLogPerSecond)
+	check(!conf.otp.originLog || conf.otp.originLogSize >= 1,
+		"otp origin log size must be at least 1, got %d", conf.otp.originLogSize)
+	check(conf.otp.maxResends >= 0, "otp max resends must not be negative, got %d", conf.otp.maxResends)
+	check(conf.otp.maxResends == 0 || conf.otp.resendWindow > 0, "otp resend window must be positive when resends are capped")
+	// the delay runs while the verify lock is held
+	check(conf.otp.tarpitMax < otpVerifyLockTTL, "otp tarpit max must be below the verify lock TTL (%s)", otpVerifyLockTTL)
+	check(slices.Contains(otpLengths, conf.otpLength), "otp length must be one of %v, got %d", otpLengths, conf.otpLength)
+	if err := validateOTPPurposes(conf.otpPurposes); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if conf.shedding.enabled {
+		check(conf.shedding.maxLatency > 0 && conf.shedding.checkInterval > 0,
+			"shedding max latency and check interval must be positive")
+		check(len(conf.shedding.lowPriority) > 0, "shedding needs at least one low-priority route")
+	}
+	check(validURL(conf.magic.baseURL), "magic base url must be an absolute URL, got %q", conf.magic.baseURL)
+	check(conf.magic.ttl > 0, "magic link TTL must be positive")
+	check(conf.http.maxIdleConns >= 0 && conf.http.maxIdleConnsPerHost >= 0,
+		"http idle connection limits must not be negative")
+
+	if conf.lineCheck.enabled {
+		switch conf.lineCheck.provider {
+		case "prefixes":
+			check(len(conf.lineCheck.voipPrefixes) > 0, "line check prefixes provider needs OTP_LINE_CHECK_VOIP_PREFIXES")
+		case "http":
+			check(validURL(conf.lineCheck.url), "line check url must be an absolute URL for the http provider, got %q", conf.lineCheck.url)
+		default:
+			problems = append(problems, fmt.Sprintf("line check provider must be prefixes or http, got %q", conf.lineCheck.provider))
+		}
+		for _, p := range conf.lineCheck.voipPrefixes {
+			check(validPhonePrefix(p), "line check VoIP prefix must be + followed by digits, got %q", p)
+		}
+		for _, t := range conf.lineCheck.blockedTypes {
+			check(slices.Contains([]string{lineTypeMobile, lineTypeVoIP, lineTypeDisposable}, t),
+				"line check blocked type must be mobile, voip or disposable, got %q", t)
+		}
+	}
+
+	if conf.metricsPush.url != "" {
+		check(validURL(conf.metricsPush.url), "metrics push url must be an absolute URL, got %q", conf.metricsPush.url)
+		check(conf.metricsPush.interval > 0, "metrics push interval must be positive")
+		check(conf.metricsPush.job != "", "metrics push job is required")
+	}
+	if conf.slidingSession.enabled {
+		check(conf.slidingSession.refreshWithin > 0 && conf.slidingSession.maxLifetime > 0,
+			"sliding session refresh window and max lifetime must be positive")
+	}
+	check(conf.refreshTokenTTL > 0, "refresh token TTL must be positive")
+	check(conf.refreshAccessTTL > 0, "refresh access token TTL must be positive")
+	check(conf.refreshTokenBytes >= data.MinTokenBytes,
+		"refresh token length must be at least %d bytes, got %d", data.MinTokenBytes, conf.refreshTokenBytes)
+	check(conf.stepUpMaxAge > 0, "step-up max age must be positive")
+	check(conf.jsonNaming == "snake" || conf.jsonNaming == "camel", "json naming must be snake or camel, got %q", conf.jsonNaming)
+	check(conf.maxPageOffset >= 0, "max page offset must not be negative, got %d", conf.maxPageOffset)
+	for prefix, clients := range conf.audienceRules {
+		for _, id := range clients {
+			check(slices.Contains(conf.clientIDs, id), "audience rule %s: client %q is not in OTP_CLIENT_IDS", prefix, id)
+		}
+	}
+
+	if conf.captcha.provider != "" {
+		_, known := captchaVerifyURLs[conf.captcha.provider]
+		check(known, "captcha provider must be recaptcha, hcaptcha or turnstile, got %q", conf.captcha.provider)
+		check(conf.captcha.secret != "", "captcha secret is required")
+		check(conf.captcha.url == "" || validURL(conf.captcha.url), "captcha url must be an absolute URL, got %q", conf.captcha.url)
+	}
+	check(conf.host.canonical == "" || len(conf.host.allowed) > 0, "canonical host needs OTP_ALLOWED_HOSTS")
+
+	// configured numbers are compared with validated input, so they are
+	// stored in the same E.164 form
+	for _, list := range []struct {
+		env     string
+		numbers []string
+	}{
+		{"OTP_SMS_TEST_NUMBERS", conf.sms.testNumbers},
+		{"OTP_CAPTCHA_BYPASS_NUMBERS", conf.captcha.bypassNumbers},
+	} {
+		for i, n := range list.numbers {
+			phone, err := data.ValidatePhoneNumber(n)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q: %v", list.env, n, err))
+				continue
+			}
+			list.numbers[i] = phone
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid settings: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func validPort(port int) bool {
+	return port >= 1 && port <= 65535
+}
+
+// validPhonePrefix reports whether s is the start of an E.164 number.
+func validPhonePrefix(s string) bool {
+	if len(s) < 2 || len(s) > 16 || s[0] != '+' || s[1] == '0' {
+		return false
+	}
+	for _, c := range s[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validURL reports whether s is an absolute http(s) URL.
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// lookupEnv reports a variable as set only when it is non-empty.
+func lookupEnv(getenv func(string) string, name string) (string, bool) {
+	v := strings.TrimSpace(getenv(name))
+	return v, v != ""
+}
+
+// splitList splits a comma-separated value, dropping empty entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// stringSetting ties an environment variable to a string config field.
+type stringSetting struct {
+	env   string
+	field *string
+}
+
+func stringSettings(conf *config) []stringSetting {
+	return []stringSetting{
+		{"OTP_ENV", &conf.env},
+		{"OTP_DB_DSN", &conf.db.dsn},
+		{"OTP_DB_HOST", &conf.db.host},
+		{"OTP_DB_USER", &conf.db.user},
+		{"OTP_DB_PASSWORD", &conf.db.password},
+		{"OTP_DB_NAME", &conf.db.name},
+		{"OTP_DB_SSLMODE", &conf.db.sslmode},
+		{"OTP_REDIS_MODE", &conf.redis.mode},
+		{"OTP_REDIS_ADDR", &conf.redis.addr},
+		{"OTP_REDIS_PASSWORD", &conf.redis.password},
+		{"OTP_REDIS_MASTER", &conf.redis.masterName},
+		{"OTP_JWT_SECRET", &conf.jwt.secret},
+		{"OTP_SMS_PROVIDER", &conf.sms.provider},
+		{"OTP_SMS_URL", &conf.sms.url},
+		{"OTP_SMS_API_KEY", &conf.sms.apiKey},
+		{"OTP_SMS_FROM", &conf.sms.from},
+		{"OTP_SMS_WEBHOOK_SECRET", &conf.sms.webhookSecret},
+		{"OTP_MAGIC_BASE_URL", &conf.magic.baseURL},
+		{"OTP_CAPTCHA_PROVIDER", &conf.captcha.provider},
+		{"OTP_CAPTCHA_SECRET", &conf.captcha.secret},
+		{"OTP_CAPTCHA_URL", &conf.captcha.url},
+		{"OTP_SMS_APP_NAME", &conf.sms.branding.AppName},
+		{"OTP_SMS_SUPPORT_URL", &conf.sms.branding.SupportURL},
+		{"OTP_RATE_LIMIT_ALGORITHM", &conf.otp.rateLimitAlgorithm},
+		{"OTP_LINE_CHECK_PROVIDER", &conf.lineCheck.provider},
+		{"OTP_LINE_CHECK_URL", &conf.lineCheck.url},
+		{"OTP_LINE_CHECK_API_KEY", &conf.lineCheck.apiKey},
+		{"OTP_METRICS_PUSH_URL", &conf.metricsPush.url},
+		{"OTP_METRICS_PUSH_JOB", &conf.metricsPush.job},
+		{"OTP_METRICS_PUSH_INSTANCE", &conf.metricsPush.instance},
+		{"OTP_JSON_NAMING", &conf.jsonNaming},
+	}
+}
+
+// intSetting ties an environment variable to an int config field.
+type intSetting struct {
+	env   string
+	field *int
+}
+
+func intSettings(conf *config) []intSetting {
+	return []intSetting{
+		{"OTP_PORT", &conf.port},
+		{"OTP_DB_PORT", &conf.db.port},
+		{"OTP_DB_MAX_OPEN_CONNS", &conf.db.maxOpenConns},
+		{"OTP_DB_MAX_IDLE_CONNS", &conf.db.maxIdleConns},
+		{"OTP_REDIS_DB", &conf.redis.db},
+		{"OTP_LENGTH", &conf.otpLength},
+		{"OTP_SMS_BREAKER_MAX_FAILURES", &conf.sms.breakerMaxFailures},
+		{"OTP_SMS_QUOTA_PER_SECOND", &conf.sms.quotaPerSecond},
+		{"OTP_SMS_QUOTA_PER_DAY", &conf.sms.quotaPerDay},
+		{"OTP_SMS_OUTBOX_SIZE", &conf.sms.outboxSize},
+		{"OTP_SMS_MAX_SEGMENTS", &conf.sms.maxSegments},
+		{"OTP_MAX_ATTEMPTS", &conf.otp.maxAttempts},
+		{"OTP_RATE_LIMIT_MAX", &conf.otp.rateLimitMax},
+		{"OTP_REUSE_WINDOW", &conf.otp.reuseWindow},
+		{"OTP_FAILURE_LOG_PER_SECOND", &conf.otp.failureLogPerSecond},
+		{"OTP_ORIGIN_LOG_SIZE", &conf.otp.originLogSize},
+		{"OTP_MAX_RESENDS", &conf.otp.maxResends},
+		{"OTP_HTTP_MAX_IDLE_CONNS", &conf.http.maxIdleConns},
+		{"OTP_HTTP_MAX_IDLE_CONNS_PER_HOST", &conf.http.maxIdleConnsPerHost},
+		{"OTP_REFRESH_TOKEN_BYTES", &conf.refreshTokenBytes},
+		{"OTP_MAX_PAGE_OFFSET", &conf.maxPageOffset},
+	}
+}
+
+// boolSetting ties an environment variable to a bool config field; values
+// are anything strconv.ParseBool accepts.
+type boolSetting struct {
+	env   string
+	field *bool
+}
+
+func boolSettings(conf *config) []boolSetting {
+	return []boolSetting{
+		{"OTP_SMS_BREAKER", &conf.sms.breakerEnabled},
+		{"OTP_SMS_ANTI_PHISHING", &conf.sms.branding.AntiPhishing},
+		{"OTP_RESET_LIMIT_ON_VERIFY", &conf.otp.resetLimitOnVerify},
+		{"OTP_FAILURE_LOG", &conf.otp.failureLog},
+		{"OTP_ORIGIN_LOG", &conf.otp.originLog},
+		{"OTP_SHEDDING", &conf.shedding.enabled},
+		{"OTP_LINE_CHECK", &conf.lineCheck.enabled},
+		{"OTP_SLIDING_SESSION", &conf.slidingSession.enabled},
+		{"OTP_AUTO_CREATE_USERS", &conf.autoCreateUsers},
+		{"OTP_STRICT_PAGE_SIZE", &conf.strictPageSize},
+		{"OTP_LEGACY_LIST_ENVELOPE", &conf.legacyListEnvelope},
+		{"OTP_MASK_LOG_PHONES", &conf.maskLogPhones},
+		{"OTP_EMPTY_SEARCH_LISTS_ALL", &conf.emptySearchListsAll},
+	}
+}
+
+// listSetting ties an environment variable to a comma-separated list field.
+type listSetting struct {
+	env   string
+	field *[]string
+}
+
+func listSettings(conf *config) []listSetting {
+	return []listSetting{
+		{"OTP_REDIS_ADDRS", &conf.redis.addrs},
+		{"OTP_SMS_TEST_NUMBERS", &conf.sms.testNumbers},
+		{"OTP_SHEDDING_LOW_PRIORITY", &conf.shedding.lowPriority},
+		{"OTP_LINE_CHECK_VOIP_PREFIXES", &conf.lineCheck.voipPrefixes},
+		{"OTP_LINE_CHECK_BLOCKED_TYPES", &conf.lineCheck.blockedTypes},
+		{"OTP_CLIENT_IDS", &conf.clientIDs},
+		{"OTP_CAPTCHA_BYPASS_NUMBERS", &conf.captcha.bypassNumbers},
+		{"OTP_INTERNAL_ROUTES", &conf.internalAccess.routes},
+	}
+}
+
+// parseAudienceRules reads "prefix=client|client" entries separated by
+// commas, e.g. "/admin/=web,/partner/=partner-a|partner-b".
+func parseAudienceRules(s string) (map[string][]string, error) {
+	rules := map[string][]string{}
+	for _, entry := range splitList(s) {
+		prefix, clients, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimSpace(prefix)
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("rule %q: expected /prefix=client|client", entry)
+		}
+		var ids []string
+		for _, id := range strings.Split(clients, "|") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("rule %q: no client IDs", entry)
+		}
+		rules[prefix] = ids
+	}
+	return rules, nil
+}
+
+// parseDuration parses a Go duration string such as "30s", "2m" or "1h30m"
+// for the named setting. Negative values and bare numbers are rejected, the
+// latter because the unit would be a guess.
+func parseDuration(name, value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("%s: empty duration", name)
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil && value != "0" {
+		return 0, fmt.Errorf("%s: duration %q has no unit, e.g. %ss or %sm", name, value, value, value)
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid duration %q, expected e.g. 30s, 2m or 1h", name, value)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s: duration %q must not be negative", name, value)
+	}
+	return d, nil
+}
+
+// durationSetting ties an environment variable to a duration config field.
+type durationSetting struct {
+	env   string
+	field *time.Duration
+}
+
+func durationSettings(conf *config) []durationSetting {
+	return []durationSetting{
+		{"OTP_DB_MAX_IDLE_TIME", &conf.db.maxIdleTime},
+		{"OTP_SMS_BREAKER_OPEN_TIMEOUT", &conf.sms.breakerOpenTimeout},
+		{"OTP_ATTEMPTS_TTL", &conf.otp.attemptsTTL},
+		{"OTP_MIN_RESPONSE_TIME", &conf.otp.minResponseTime},
+		{"OTP_RATE_LIMIT_WINDOW", &conf.otp.rateLimitWindow},
+		{"OTP_RESEND_COOLDOWN", &conf.otp.resendCooldown},
+		{"OTP_RESEND_WINDOW", &conf.otp.resendWindow},
+		{"OTP_MAX_CHALLENGE_LIFETIME", &conf.otp.maxChallengeLifetime},
+		{"OTP_PREVIOUS_CODE_GRACE", &conf.otp.previousCodeGrace},
+		{"OTP_TARPIT_BASE", &conf.otp.tarpitBase},
+		{"OTP_TARPIT_STEP", &conf.otp.tarpitStep},
+		{"OTP_TARPIT_MAX", &conf.otp.tarpitMax},
+		{"OTP_SHEDDING_MAX_LATENCY", &conf.shedding.maxLatency},
+		{"OTP_SHEDDING_CHECK_INTERVAL", &conf.shedding.checkInterval},
+		{"OTP_MAGIC_TTL", &conf.magic.ttl},
+		{"OTP_LINE_CHECK_CACHE_TTL", &conf.lineCheck.cacheTTL},
+		{"OTP_METRICS_PUSH_INTERVAL", &conf.metricsPush.interval},
+		{"OTP_SLIDING_SESSION_REFRESH_WITHIN", &conf.slidingSession.refreshWithin},
+		{"OTP_SLIDING_SESSION_MAX_LIFETIME", &conf.slidingSession.maxLifetime},
+		{"OTP_HTTP_TIMEOUT", &conf.http.timeout},
+		{"OTP_HTTP_DIAL_TIMEOUT", &conf.http.dialTimeout},
+		{"OTP_HTTP_TLS_HANDSHAKE_TIMEOUT", &conf.http.tlsHandshakeTimeout},
+		{"OTP_HTTP_RESPONSE_HEADER_TIMEOUT", &conf.http.responseHeaderTimeout},
+		{"OTP_HTTP_IDLE_CONN_TIMEOUT", &conf.http.idleConnTimeout},
+		{"OTP_REFRESH_TOKEN_TTL", &conf.refreshTokenTTL},
+		{"OTP_REFRESH_ACCESS_TTL", &conf.refreshAccessTTL},
+		{"OTP_DELETED_PHONE_COOLDOWN", &conf.deletedPhoneCooldown},
+		{"OTP_STEP_UP_MAX_AGE", &conf.stepUpMaxAge},
+	}
+}
+
+// loadDurations overrides every duration setting whose variable is set,
+// collecting all malformed values into one error.
+func loadDurations(conf *config, getenv func(string) string) error {
+	var problems []string
+	for _, s := range durationSettings(conf) {
+		v := getenv(s.env)
+		if v == "" {
+			continue
+		}
+		d, err := parseDuration(s.env, v)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		*s.field = d
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid duration settings: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
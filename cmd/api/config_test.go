@@ -0,0 +1,285 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadTestConfig runs loadConfig over env and args.
+func loadTestConfig(env map[string]string, args ...string) (*config, error) {
+	var environ []string
+	for k, v := range env {
+		environ = append(environ, k+"="+v)
+	}
+	return loadConfig(args, func(k string) string { return env[k] }, environ)
+}
+
+func TestDefaultConfigIsValid(t *testing.T) {
+	if err := validateConfig(defaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefaultConfigDoesNotShed(t *testing.T) {
+	if defaultConfig().shedding.enabled {
+		t.Fatal("load shedding is on by default")
+	}
+}
+
+func TestDefaultConfigKeepsRequestLimitOnVerify(t *testing.T) {
+	if defaultConfig().otp.resetLimitOnVerify {
+		t.Fatal("a successful verify resets the request limit by default")
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	env := map[string]string{
+		"OTP_PORT":                   "9000",
+		"OTP_SMS_TEST_NUMBERS":       "+989121234567, +989129999999",
+		"OTP_CLIENT_IDS":             "web,ios",
+		"OTP_AUDIENCE_RULES":         "/admin/=web,/me/=web|ios",
+		"OTP_SLIDING_SESSION":        "true",
+		"OTP_JSON_NAMING":            "camel",
+		"OTP_STRICT_PAGE_SIZE":       "1",
+		"OTP_LEGACY_LIST_ENVELOPE":   "true",
+		"OTP_MAX_ATTEMPTS":           "3",
+		"OTP_MAX_RESENDS":            "5",
+		"OTP_REUSE_WINDOW":           "10",
+		"OTP_RATE_LIMIT_ALGORITHM":   "sliding",
+		"OTP_SMS_BREAKER":            "false",
+		"OTP_SHEDDING":               "true",
+		"OTP_MASK_LOG_PHONES":        "false",
+		"OTP_AUTO_CREATE_USERS":      "false",
+		"OTP_EMPTY_SEARCH_LISTS_ALL": "false",
+		"OTP_METRICS_PUSH_URL":       "http://pushgateway:9091",
+		"OTP_SMS_APP_NAME":           "Acme",
+		"OTP_SMS_ANTI_PHISHING":      "true",
+		"OTP_ATTEMPTS_TTL":           "1h",
+		"OTP_FEATURE_VERIFY_WAIT":    "true",
+		"OTP_RESET_LIMIT_ON_VERIFY":  "true",
+		"OTP_REFRESH_ACCESS_TTL":     "5m",
+		"OTP_RATE_LIMIT_MAX":         "7",
+		"OTP_RATE_LIMIT_WINDOW":      "30m",
+	}
+	conf, err := loadTestConfig(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		name string
+		ok   bool
+	}{
+		{"port", conf.port == 9000},
+		{"sms.testNumbers", slices.Equal(conf.sms.testNumbers, []string{"+989121234567", "+989129999999"})},
+		{"clientIDs", slices.Equal(conf.clientIDs, []string{"web", "ios"})},
+		{"audienceRules", slices.Equal(conf.audienceRules["/me/"], []string{"web", "ios"}) && len(conf.audienceRules) == 2},
+		{"slidingSession.enabled", conf.slidingSession.enabled},
+		{"jsonNaming", conf.jsonNaming == "camel"},
+		{"strictPageSize", conf.strictPageSize},
+		{"legacyListEnvelope", conf.legacyListEnvelope},
+		{"otp.maxAttempts", conf.otp.maxAttempts == 3},
+		{"otp.maxResends", conf.otp.maxResends == 5},
+		{"otp.reuseWindow", conf.otp.reuseWindow == 10},
+		{"otp.rateLimitAlgorithm", conf.otp.rateLimitAlgorithm == "sliding"},
+		{"sms.breakerEnabled", !conf.sms.breakerEnabled},
+		{"shedding.enabled", conf.shedding.enabled},
+		{"maskLogPhones", !conf.maskLogPhones},
+		{"autoCreateUsers", !conf.autoCreateUsers},
+		{"emptySearchListsAll", !conf.emptySearchListsAll},
+		{"metricsPush.url", conf.metricsPush.url == "http://pushgateway:9091"},
+		{"sms.branding", conf.sms.branding.AppName == "Acme" && conf.sms.branding.AntiPhishing},
+		{"otp.attemptsTTL", conf.otp.attemptsTTL == time.Hour},
+		{"features", conf.features.verifyWait()},
+		{"otp.resetLimitOnVerify", conf.otp.resetLimitOnVerify},
+		{"refreshAccessTTL", conf.refreshAccessTTL == 5*time.Minute},
+		{"otp.rateLimitMax", conf.otp.rateLimitMax == 7},
+		{"otp.rateLimitWindow", conf.otp.rateLimitWindow == 30*time.Minute},
+	}
+	for _, c := range checks {
+		if !c.ok {
+			t.Errorf("%s not loaded from the environment", c.name)
+		}
+	}
+}
+
+func TestLoadConfigFlagsOverrideEnv(t *testing.T) {
+	conf, err := loadTestConfig(map[string]string{"OTP_PORT": "9000"}, "-port", "9100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.port != 9100 {
+		t.Fatalf("port = %d, want the flag's 9100", conf.port)
+	}
+
+	conf, err = loadTestConfig(map[string]string{"OTP_RATE_LIMIT_MAX": "7"}, "-rate-limit-max", "9", "-rate-limit-window", "1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.otp.rateLimitMax != 9 || conf.otp.rateLimitWindow != time.Hour {
+		t.Fatalf("rate limit = %d per %s, want the flags' 9 per 1h", conf.otp.rateLimitMax, conf.otp.rateLimitWindow)
+	}
+}
+
+func TestLoadConfigRejectsInvalidSettings(t *testing.T) {
+	tests := map[string]string{
+		"OTP_PORT":                 "eighty",
+		"OTP_SLIDING_SESSION":      "maybe",
+		"OTP_ATTEMPTS_TTL":         "15",
+		"OTP_AUDIENCE_RULES":       "admin=web",
+		"OTP_MAX_ATTEMPTS":         "0",
+		"OTP_RATE_LIMIT_ALGORITHM": "token-bucket",
+		"OTP_JSON_NAMING":          "kebab",
+		"OTP_SMS_PROVIDER":         "carrier-pigeon",
+		"OTP_REDIS_MODE":           "cluster",
+		"OTP_LENGTH":               "5",
+		"OTP_METRICS_PUSH_URL":     "pushgateway:9091",
+		"OTP_MAGIC_BASE_URL":       "example.com",
+		"OTP_REFRESH_TOKEN_BYTES":  "4",
+		"OTP_PURPOSES":             "login=1m:7",
+		"OTP_TARPIT_MAX":           "5s",
+		"OTP_REFRESH_ACCESS_TTL":   "0s",
+		"OTP_RATE_LIMIT_MAX":       "0",
+		"OTP_RATE_LIMIT_WINDOW":    "500ms",
+	}
+	for name, value := range tests {
+		if _, err := loadTestConfig(map[string]string{name: value}); err == nil {
+			t.Errorf("%s=%q was accepted", name, value)
+		}
+	}
+
+	// audience rules must name configured clients
+	_, err := loadTestConfig(map[string]string{"OTP_AUDIENCE_RULES": "/admin/=web"})
+	if err == nil || !strings.Contains(err.Error(), "OTP_CLIENT_IDS") {
+		t.Errorf("rule for an unknown client: err = %v", err)
+	}
+}
+
+func TestLoadConfigProductionJWTSecrets(t *testing.T) {
+	long := strings.Repeat("k", minJWTSecretLength)
+	tests := map[string]bool{
+		"":                          false,
+		devJWTSecret:                false,
+		"short":                     false,
+		long + ",short":             false,
+		long:                        true,
+		long + "," + long[1:] + "x": true,
+	}
+	for secret, ok := range tests {
+		_, err := loadTestConfig(map[string]string{"OTP_ENV": "production", "OTP_JWT_SECRET": secret})
+		if (err == nil) != ok {
+			t.Errorf("OTP_JWT_SECRET=%q: err = %v, want ok %v", secret, err, ok)
+		}
+	}
+}
+
+func TestSettingTablesHaveUniqueNames(t *testing.T) {
+	conf := defaultConfig()
+	seen := map[string]bool{}
+	var names []string
+	for _, s := range stringSettings(conf) {
+		names = append(names, s.env)
+	}
+	for _, s := range intSettings(conf) {
+		names = append(names, s.env)
+	}
+	for _, s := range boolSettings(conf) {
+		names = append(names, s.env)
+	}
+	for _, s := range listSettings(conf) {
+		names = append(names, s.env)
+	}
+	for _, s := range durationSettings(conf) {
+		names = append(names, s.env)
+	}
+	for _, name := range names {
+		if seen[name] {
+			t.Errorf("%s is listed twice", name)
+		}
+		seen[name] = true
+	}
+}
+
+func TestLoadConfigNormalizesPhoneLists(t *testing.T) {
+	conf, err := loadTestConfig(map[string]string{
+		"OTP_SMS_TEST_NUMBERS":       "+98 (912) 999-9999",
+		"OTP_CAPTCHA_BYPASS_NUMBERS": "0098 912 123 4567, +1 (555) 123-4567",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"+989121234567", "+15551234567"}
+	if !slices.Equal(conf.captcha.bypassNumbers, want) {
+		t.Fatalf("bypass numbers = %q, want %q", conf.captcha.bypassNumbers, want)
+	}
+	if want := []string{"+989129999999"}; !slices.Equal(conf.sms.testNumbers, want) {
+		t.Fatalf("test numbers = %q, want %q", conf.sms.testNumbers, want)
+	}
+
+	for _, env := range []string{"OTP_SMS_TEST_NUMBERS", "OTP_CAPTCHA_BYPASS_NUMBERS"} {
+		_, err := loadTestConfig(map[string]string{env: "09121234567"})
+		if err == nil || !strings.Contains(err.Error(), env) {
+			t.Errorf("%s without a country code: err = %v", env, err)
+		}
+	}
+
+	for _, prefix := range []string{"98990", "+0", "+98-990"} {
+		_, err := loadTestConfig(map[string]string{
+			"OTP_LINE_CHECK":               "true",
+			"OTP_LINE_CHECK_PROVIDER":      "prefixes",
+			"OTP_LINE_CHECK_VOIP_PREFIXES": prefix,
+		})
+		if err == nil {
+			t.Errorf("VoIP prefix %q was accepted", prefix)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"30s", 30 * time.Second, true},
+		{" 1h30m ", 90 * time.Minute, true},
+		{"0", 0, true},
+		{"30", 0, false},
+		{"1.5", 0, false},
+		{"-1m", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration("OTP_TEST", tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseDuration(%q) = %v, %v; want %v, ok %t", tt.value, got, err, tt.want, tt.ok)
+		}
+		if err != nil && !strings.HasPrefix(err.Error(), "OTP_TEST:") {
+			t.Errorf("parseDuration(%q): error %q doesn't name the setting", tt.value, err)
+		}
+	}
+}
+
+func TestLoadDurationsReportsEveryBadValue(t *testing.T) {
+	conf := defaultConfig()
+	env := map[string]string{
+		"OTP_MAGIC_TTL":       "10",
+		"OTP_STEP_UP_MAX_AGE": "-5m",
+		"OTP_RESEND_COOLDOWN": "2m",
+	}
+	err := loadDurations(conf, func(k string) string { return env[k] })
+	if err == nil {
+		t.Fatal("malformed durations were accepted")
+	}
+	for _, name := range []string{"OTP_MAGIC_TTL", "OTP_STEP_UP_MAX_AGE"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q doesn't mention %s", err, name)
+		}
+	}
+	if conf.otp.resendCooldown != 2*time.Minute {
+		t.Errorf("resendCooldown = %v, want the valid override 2m", conf.otp.resendCooldown)
+	}
+}
@@ -13,6 +13,18 @@ type contextKey string
 // key for storing *data.User in request context.
 const userContextKey contextKey = "OTP.user"
 
+// key for storing the token's scope ("" for session tokens).
+const scopeContextKey contextKey = "OTP.scope"
+
+// key for storing the number a scoped token's OTP proved.
+const verifiedPhoneContextKey contextKey = "OTP.verifiedPhone"
+
+// key for storing the token's aud claim.
+const audienceContextKey contextKey = "OTP.audience"
+
+// key for storing the ID logRequests assigned to the request.
+const requestIDContextKey contextKey = "OTP.requestID"
+
 // attach user to request context
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -27,3 +39,51 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// attach token scope to request context
+func (app *application) contextSetScope(r *http.Request, scope string) *http.Request {
+	ctx := context.WithValue(r.Context(), scopeContextKey, scope)
+	return r.WithContext(ctx)
+}
+
+// get token scope from request context ("" if unscoped or anonymous)
+func (app *application) contextGetScope(r *http.Request) string {
+	scope, _ := r.Context().Value(scopeContextKey).(string)
+	return scope
+}
+
+// attach the token's verified phone number to request context
+func (app *application) contextSetVerifiedPhone(r *http.Request, phone string) *http.Request {
+	ctx := context.WithValue(r.Context(), verifiedPhoneContextKey, phone)
+	return r.WithContext(ctx)
+}
+
+// get the token's verified phone number from request context ("" if none)
+func (app *application) contextGetVerifiedPhone(r *http.Request) string {
+	phone, _ := r.Context().Value(verifiedPhoneContextKey).(string)
+	return phone
+}
+
+// attach token audience to request context
+func (app *application) contextSetAudience(r *http.Request, aud []string) *http.Request {
+	ctx := context.WithValue(r.Context(), audienceContextKey, aud)
+	return r.WithContext(ctx)
+}
+
+// get token audience from request context (nil if none or anonymous)
+func (app *application) contextGetAudience(r *http.Request) []string {
+	aud, _ := r.Context().Value(audienceContextKey).([]string)
+	return aud
+}
+
+// attach the request ID to request context
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// get the request ID from a context ("" outside a request)
+func contextGetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
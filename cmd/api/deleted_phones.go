@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// numbers of deleted accounts are remembered for conf.deletedPhoneCooldown
+// so they can't immediately be used to sign up again
+func deletedPhoneKey(phone string) string {
+	return otpKeyPrefix(phone) + ":deleted"
+}
+
+// recordDeletedPhone starts the re-registration cooldown for the number of
+// a deleted account. It is a no-op when the cooldown is disabled.
+func (app *application) recordDeletedPhone(ctx context.Context, phone string) error {
+	cooldown := app.conf.deletedPhoneCooldown
+	if cooldown <= 0 {
+		return nil
+	}
+	unlockAt := time.Now().Add(cooldown).Unix()
+	return app.cache.Set(ctx, deletedPhoneKey(phone), unlockAt, cooldown).Err()
+}
+
+// deletedPhoneUnlockAt returns when a recently deleted number may sign up
+// again, or the zero time if it isn't blocked.
+func (app *application) deletedPhoneUnlockAt(ctx context.Context, phone string) (time.Time, error) {
+	if app.conf.deletedPhoneCooldown <= 0 {
+		return time.Time{}, nil
+	}
+	v, err := app.cache.Get(ctx, deletedPhoneKey(phone)).Result()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	unix, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// handleDeleteMe godoc
+// @Summary     Delete my account
+// @Description Anonymizes the authenticated user's account: the phone number is replaced by a random placeholder, the name is cleared and every session is revoked. When OTP_DELETED_PHONE_COOLDOWN is set, the number can't sign up again until the cooldown ends.
+// @Tags        Sessions
+// @Security    BearerAuth
+// @Produce     json
+// @Success     200 {object} map[string]interface{} "success/message"
+// @Failure     401 {object} map[string]string
+// @Failure     500 {object} map[string]string
+// @Router      /me [delete]
+func (app *application) handleDeleteMe(w http.ResponseWriter, r *http.Request) error {
+	user := app.contextGetUser(r)
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancel()
+
+	// recorded first: once anonymized the number is gone from the row, so
+	// a failure afterwards would let it sign up again straight away
+	if err := app.recordDeletedPhone(ctx, user.PhoneNumber); err != nil {
+		return fmt.Errorf("failed to record deleted phone: %w", err)
+	}
+	if err := app.models.User.Anonymize(ctx, user.ID); err != nil {
+		// the account still exists, so don't keep its owner from logging in
+		app.cache.Del(ctx, deletedPhoneKey(user.PhoneNumber))
+		return err
+	}
+
+	app.logger.InfoContext(r.Context(), "audit: deleted their account", "user_id", user.ID)
+
+	return app.writeJSON(w, http.StatusOK, envelope{
+		"success": true,
+		"message": "Account deleted",
+	}, nil)
+}
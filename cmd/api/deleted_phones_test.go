@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDeletedPhoneCooldown(t *testing.T) {
+	app, _ := newTestApp(t)
+	ctx := context.Background()
+
+	// disabled by default: recording a deletion changes nothing
+	if err := app.recordDeletedPhone(ctx, testPhone); err != nil {
+		t.Fatal(err)
+	}
+	requestTestOTP(t, app, testPhone)
+
+	app, _ = newTestApp(t)
+	app.conf.deletedPhoneCooldown = time.Hour
+	app.sms = &fakeSender{}
+	mock := mockDB(t, app)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users SET phone_number`).
+		WithArgs(sqlmock.AnyArg(), testSessionUser.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM tokens`).WithArgs(testSessionUser.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	withUser(app, testSessionUser, app.handle(app.handleDeleteMe))(w, httptest.NewRequest(http.MethodDelete, "/me", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete account: want 200, got %d %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	w = postJSON(app.handle(app.handleRequestOTP), "/request", `{"phone_number":"`+testPhone+`"}`)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("deleted number: want 429, got %d %s", w.Code, w.Body)
+	}
+	unlockAt, err := time.Parse(time.RFC3339, decodeBody(t, w)["unlock_at"].(string))
+	if err != nil || unlockAt.Before(time.Now().Add(59*time.Minute)) {
+		t.Fatalf("unlock_at = %v (%v), want about an hour from now", unlockAt, err)
+	}
+
+	requestTestOTP(t, app, "+989120000002")
+}
+
+func TestDeleteMeKeepsNumberWhenAnonymizeFails(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.deletedPhoneCooldown = time.Hour
+	app.sms = &fakeSender{}
+	mock := mockDB(t, app)
+	mock.ExpectBegin().WillReturnError(errors.New("connection refused"))
+
+	w := httptest.NewRecorder()
+	withUser(app, testSessionUser, app.handle(app.handleDeleteMe))(w, httptest.NewRequest(http.MethodDelete, "/me", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("want 500, got %d %s", w.Code, w.Body)
+	}
+
+	// the account still exists, so its owner can still log in
+	requestTestOTP(t, app, testPhone)
+}
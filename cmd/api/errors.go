@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+	"Go-OTP-Login/internal/sms"
+)
+
+// apiError is an error with the response it should produce. Message is
+// shown to the client; Err, if set, is only logged. Fields are added to the
+// JSON body, and RetryAfter, if set, is sent as the Retry-After header.
+type apiError struct {
+	Status     int
+	Code       string
+	Message    string
+	Err        error
+	Fields     envelope
+	RetryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *apiError) Unwrap() error { return e.Err }
+
+func badRequest(message string) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: "bad_request", Message: message}
+}
+
+// tooManyRequests answers 429 with the wait both as a Retry-After header
+// and a retry_after field.
+func tooManyRequests(message string, wait time.Duration) *apiError {
+	return &apiError{Status: http.StatusTooManyRequests, Code: "rate_limited", Message: message,
+		Fields: envelope{"retry_after": ceilSeconds(wait)}, RetryAfter: wait}
+}
+
+// invalidPhone reports a data.ValidatePhoneNumber failure as 422.
+func invalidPhone(err error) *apiError {
+	return &apiError{Status: http.StatusUnprocessableEntity, Code: "invalid_phone", Message: err.Error(), Err: err}
+}
+
+// apiHandler is a handler that reports failure by returning an error
+// instead of writing the error response itself.
+type apiHandler func(http.ResponseWriter, *http.Request) error
+
+// handle adapts an apiHandler for the router, sending any returned error
+// through handleError.
+func (app *application) handle(h apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			app.handleError(w, r, err)
+		}
+	}
+}
+
+// handleError writes the response for err. An *apiError is used as is,
+// known sentinels map to their status, and anything else is logged and
+// answered with a generic 500 so internals never leak.
+func (app *application) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.Is(err, data.ErrRecordNotFound):
+		apiErr = &apiError{Status: http.StatusNotFound, Code: "not_found",
+			Message: "the requested resource could not be found"}
+	case errors.Is(err, data.ErrEditConflict):
+		apiErr = &apiError{Status: http.StatusConflict, Code: "edit_conflict",
+			Message: "unable to update the record due to an edit conflict, please try again"}
+	case errors.Is(err, data.ErrDuplicatePhone):
+		apiErr = &apiError{Status: http.StatusConflict, Code: "duplicate_phone",
+			Message: "a user with this phone number already exists"}
+	case errors.Is(err, errNotRegistered):
+		apiErr = &apiError{Status: http.StatusForbidden, Code: "not_registered",
+			Message: "Phone number is not registered"}
+	case errors.Is(err, errRateLimitTimeout):
+		apiErr = &apiError{Status: http.StatusServiceUnavailable, Code: "rate_limit_timeout",
+			Message: "rate limit check timed out, please retry", Err: err}
+	case errors.Is(err, sms.ErrCircuitOpen), errors.Is(err, sms.ErrQuotaExceeded):
+		apiErr = &apiError{Status: http.StatusServiceUnavailable, Code: "sms_unavailable",
+			Message: "SMS service temporarily unavailable. Please try again later.", Err: err}
+	default:
+		apiErr = &apiError{Status: http.StatusInternalServerError, Code: "internal_error",
+			Message: "the server encountered a problem and could not process your request", Err: err}
+	}
+
+	if apiErr.Status >= http.StatusInternalServerError {
+		app.logger.ErrorContext(r.Context(), "error serving request", "method", r.Method, "path", r.URL.Path, "error", err)
+	}
+
+	if apiErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(apiErr.RetryAfter), 10))
+	}
+	if prefersPlainText(r.Header.Get("Accept")) {
+		app.errorResponse(w, r, apiErr.Status, apiErr.Message)
+		return
+	}
+	body := envelope{
+		"error": apiErr.Message,
+		"code":  apiErr.Code,
+	}
+	for k, v := range apiErr.Fields {
+		body[k] = v
+	}
+	_ = app.writeJSON(w, apiErr.Status, body, nil)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+	"Go-OTP-Login/internal/sms"
+)
+
+func TestHandleErrorMapsErrors(t *testing.T) {
+	app, _ := newTestApp(t)
+	var logs bytes.Buffer
+	app.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+		logged bool
+	}{
+		{"api error", &apiError{Status: http.StatusTeapot, Code: "teapot", Message: "short and stout"}, http.StatusTeapot, "teapot", false},
+		{"bad request", badRequest("invalid id"), http.StatusBadRequest, "bad_request", false},
+		{"not found", fmt.Errorf("get user: %w", data.ErrRecordNotFound), http.StatusNotFound, "not_found", false},
+		{"edit conflict", data.ErrEditConflict, http.StatusConflict, "edit_conflict", false},
+		{"duplicate phone", data.ErrDuplicatePhone, http.StatusConflict, "duplicate_phone", false},
+		{"not registered", fmt.Errorf("register: %w", errNotRegistered), http.StatusForbidden, "not_registered", false},
+		{"rate limit timeout", fmt.Errorf("check: %w", errRateLimitTimeout), http.StatusServiceUnavailable, "rate_limit_timeout", true},
+		{"sms circuit open", fmt.Errorf("send: %w", sms.ErrCircuitOpen), http.StatusServiceUnavailable, "sms_unavailable", true},
+		{"sms quota", fmt.Errorf("send: %w", sms.ErrQuotaExceeded), http.StatusServiceUnavailable, "sms_unavailable", true},
+		{"unexpected", errors.New("pq: connection refused"), http.StatusInternalServerError, "internal_error", true},
+	}
+	for _, tt := range tests {
+		logs.Reset()
+		w := httptest.NewRecorder()
+		app.handleError(w, httptest.NewRequest(http.MethodGet, "/users/1", nil), tt.err)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if code := decodeBody(t, w)["code"]; code != tt.code {
+			t.Errorf("%s: code = %v, want %s", tt.name, code, tt.code)
+		}
+		if strings.Contains(w.Body.String(), "pq:") {
+			t.Errorf("%s: response %s leaks the internal error", tt.name, w.Body)
+		}
+		if logged := logs.Len() > 0; logged != tt.logged {
+			t.Errorf("%s: logged = %v, want %v", tt.name, logged, tt.logged)
+		}
+	}
+}
+
+func TestHandleErrorAddsFieldsAndRetryAfter(t *testing.T) {
+	app, _ := newTestApp(t)
+	w := httptest.NewRecorder()
+	app.handleError(w, httptest.NewRequest(http.MethodPost, "/resend", nil),
+		tooManyRequests("Too many resends. Please try again later.", 1500*time.Millisecond))
+
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("got %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	body := decodeBody(t, w)
+	if body["code"] != "rate_limited" || body["retry_after"] != float64(2) {
+		t.Fatalf("body = %v", body)
+	}
+}
+
+func TestHandleRunsAPIHandlers(t *testing.T) {
+	app, _ := newTestApp(t)
+	h := app.handle(func(w http.ResponseWriter, r *http.Request) error {
+		if r.URL.Query().Get("fail") != "" {
+			return data.ErrRecordNotFound
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+
+	for query, want := range map[string]int{"": http.StatusNoContent, "?fail=1": http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, "/"+query, nil))
+		if w.Code != want {
+			t.Errorf("%q: want %d, got %d", query, want, w.Code)
+		}
+	}
+}
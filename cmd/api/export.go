@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+)
+
+// UserExport is the data portability bundle returned by /me/export.
+type UserExport struct {
+	ExportedAt time.Time    `json:"exported_at"`
+	User       *data.User   `json:"user"`
+	Sessions   []data.Token `json:"sessions"`
+	OTP        OTPExport    `json:"otp"`
+}
+
+// OTPExport is the OTP history kept about a user. Requests are only
+// recorded while the origin log is enabled and only the newest
+// otp.originLogSize requests across all users are kept.
+type OTPExport struct {
+	LastVerifiedAt *time.Time  `json:"last_verified_at"`
+	Requests       []otpOrigin `json:"requests"`
+}
+
+// handleExportMe godoc
+// @Summary     Export my data
+// @Description Returns the authenticated user's record, session metadata and OTP history as a downloadable JSON file. Token values are never included.
+// @Tags        Sessions
+// @Security    BearerAuth
+// @Produce     json
+// @Success     200 {object} map[string]UserExport "envelope with 'export' key"
+// @Failure     401 {object} map[string]string
+// @Failure     500 {object} map[string]string
+// @Router      /me/export [get]
+func (app *application) handleExportMe(w http.ResponseWriter, r *http.Request) error {
+	user := app.contextGetUser(r)
+	// internal-network bypass would otherwise reach here without a user
+	if user.IsAnonymous() {
+		return &apiError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: "Unauthorized"}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	sessions, err := app.models.Token.AllForUser(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch sessions: %w", err)
+	}
+	verifiedAt, err := app.lastVerifiedAt(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to read last verification: %w", err)
+	}
+	requests, err := app.otpOriginsForPhone(ctx, user.PhoneNumber)
+	if err != nil {
+		return fmt.Errorf("failed to read OTP history: %w", err)
+	}
+
+	export := UserExport{
+		ExportedAt: time.Now().UTC(),
+		User:       user,
+		Sessions:   sessions,
+		OTP:        OTPExport{Requests: requests},
+	}
+	if !verifiedAt.IsZero() {
+		export.OTP.LastVerifiedAt = &verifiedAt
+	}
+
+	app.logger.InfoContext(r.Context(), "audit: exported their data", "user_id", user.ID)
+
+	headers := http.Header{}
+	headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.json"`, user.ID))
+	return app.writeJSON(w, http.StatusOK, envelope{"export": export}, headers)
+}
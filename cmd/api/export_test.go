@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestExportMe(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.otp.originLog = true
+	app.sms = &fakeSender{}
+	mock := mockDB(t, app)
+	user := &data.User{ID: 7, PhoneNumber: testPhone, Name: "Sara", CreatedAt: time.Now()}
+
+	for _, phone := range []string{testPhone, "+989120000002"} {
+		if w := postJSON(app.handle(app.handleRequestOTP), "/request", `{"phone_number":"`+phone+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("/request: want 200, got %d %s", w.Code, w.Body)
+		}
+	}
+	if err := app.markVerified(context.Background(), user.ID); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery(`SELECT id, user_id, created_at, expiry\s+FROM tokens\s+WHERE user_id = \$1`).
+		WithArgs(user.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "created_at", "expiry"}).
+			AddRow(2, user.ID, time.Now(), time.Now().Add(time.Hour)).
+			AddRow(1, user.ID, time.Now().Add(-48*time.Hour), time.Now().Add(-time.Hour)))
+
+	w := httptest.NewRecorder()
+	withUser(app, user, app.handle(app.handleExportMe))(w, httptest.NewRequest(http.MethodGet, "/me/export", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", w.Code, w.Body)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="user-7-export.json"` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	export := decodeBody(t, w)["export"].(map[string]any)
+	if sessions, _ := export["sessions"].([]any); len(sessions) != 2 {
+		t.Errorf("sessions = %v, want both tokens, expired included", export["sessions"])
+	}
+	otp := export["otp"].(map[string]any)
+	if otp["last_verified_at"] == nil {
+		t.Error("last_verified_at missing")
+	}
+	requests, _ := otp["requests"].([]any)
+	if len(requests) != 1 || requests[0].(map[string]any)["phone"] != maskPhone(testPhone) {
+		t.Errorf("requests = %v, want only the user's own, masked", requests)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	w = httptest.NewRecorder()
+	withUser(app, data.AnonymousUser, app.handle(app.handleExportMe))(w, httptest.NewRequest(http.MethodGet, "/me/export", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous export: want 401, got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const featureEnvPrefix = "OTP_FEATURE_"
+
+// known feature flags
+const (
+	featureQRLogin    = "QR_LOGIN"
+	featureVerifyWait = "VERIFY_WAIT"
+)
+
+// featureFlags toggles optional behavior. Flags are off unless set.
+type featureFlags map[string]bool
+
+// loadFeatureFlags reads OTP_FEATURE_<NAME>=<bool> entries from environ
+// (as returned by os.Environ). Unparsable values count as off.
+func loadFeatureFlags(environ []string) featureFlags {
+	flags := featureFlags{}
+	for _, kv := range environ {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(k, featureEnvPrefix) {
+			continue
+		}
+		name := strings.ToUpper(strings.TrimPrefix(k, featureEnvPrefix))
+		enabled, err := strconv.ParseBool(strings.TrimSpace(v))
+		flags[name] = err == nil && enabled
+	}
+	return flags
+}
+
+func (f featureFlags) enabled(name string) bool {
+	return f[name]
+}
+
+// enabledNames lists the enabled flags in sorted order.
+func (f featureFlags) enabledNames() []string {
+	names := []string{}
+	for name, on := range f {
+		if on {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f featureFlags) qrLogin() bool {
+	return f.enabled(featureQRLogin)
+}
+
+func (f featureFlags) verifyWait() bool {
+	return f.enabled(featureVerifyWait)
+}
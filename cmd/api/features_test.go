@@ -0,0 +1,27 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLoadFeatureFlags(t *testing.T) {
+	flags := loadFeatureFlags([]string{
+		"OTP_FEATURE_QR_LOGIN=true",
+		"OTP_FEATURE_verify_wait= 1 ",
+		"OTP_FEATURE_BROKEN=yes please",
+		"OTP_FEATURE_OFF=false",
+		"OTP_PORT=8000",
+		"NOT_A_PAIR",
+	})
+
+	if !flags.qrLogin() || !flags.verifyWait() {
+		t.Fatalf("flags = %v, want QR_LOGIN and VERIFY_WAIT on", flags)
+	}
+	if flags.enabled("BROKEN") || flags.enabled("OFF") || flags.enabled("UNSET") {
+		t.Fatalf("flags = %v, want unparsable, false and unset flags off", flags)
+	}
+	if got, want := flags.enabledNames(), []string{featureQRLogin, featureVerifyWait}; !slices.Equal(got, want) {
+		t.Fatalf("enabledNames() = %q, want %q", got, want)
+	}
+}
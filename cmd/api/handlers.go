@@ -2,6 +2,7 @@ package main
 
 import (
 	"Go-OTP-Login/internal/data"
+	"Go-OTP-Login/internal/sms"
 	"context"
 	"errors"
 	"fmt"
@@ -20,6 +21,18 @@ import (
 type requestOTPReq struct {
 	// required: true
 	PhoneNumber string `json:"phone_number"`
+	// return a scan-to-login QR code instead of sending an OTP;
+	// only allowed for the authenticated user's own number and
+	// when OTP_FEATURE_QR_LOGIN is enabled
+	QR bool `json:"qr"`
+	// delivery channel; only "sms" for now (default)
+	Channel string `json:"channel" enums:"sms"`
+	// what the code is for, selecting its TTL and length; "login" (default)
+	// codes are verified on /verify, others on /verify/scoped
+	Purpose string `json:"purpose" example:"login"`
+	// response token of the CAPTCHA widget; required on /request when
+	// CAPTCHA is enabled, unless the number is allowlisted
+	CaptchaToken string `json:"captcha_token"`
 }
 
 // swagger:model verifyOTPReq
@@ -28,6 +41,13 @@ type verifyOTPReq struct {
 	PhoneNumber string `json:"phone_number"`
 	// required: true
 	OTP string `json:"otp"`
+	// optional; must be a configured client and becomes the token's aud
+	ClientID string `json:"client_id"`
+	// channel the code was requested on (default "sms")
+	Channel string `json:"channel" enums:"sms"`
+	// optional; the challenge_id /request returned, completing that
+	// /verify/wait for the phone
+	ChallengeID string `json:"challenge_id"`
 }
 
 // swagger:model verifyOTPRes
@@ -35,7 +55,11 @@ type verifyOTPRes struct {
 	Success bool      `json:"success"`
 	Message string    `json:"message"`
 	Data    data.User `json:"data"`
-	Token   string    `json:"token"` // JWT
+	Created bool      `json:"created"` // true when this verify registered the user
+	Token   string    `json:"token"`   // JWT
+	// RefreshToken identifies the session; exchange it for a new JWT with
+	// /refresh or rotate it with /me/refresh/rotate.
+	RefreshToken string `json:"refresh_token"`
 }
 
 // swagger:model protectedRes
@@ -49,61 +73,264 @@ type protectedRes struct {
 
 // handleRequestOTP godoc
 // @Summary     Request OTP
-// @Description Generates OTP and stores it in Redis for the given phone_number (2 min TTL).
+// @Description Generates OTP, stores it in Redis for the given phone_number (2 min TTL) and sends it by SMS. When CAPTCHA is enabled, captcha_token must be a solved CAPTCHA unless the number is allowlisted.
 // @Tags        Auth
 // @Accept      json
 // @Produce     json
 // @Param       payload body     requestOTPReq true "OTP request payload"
-// @Success     200     {object} map[string]interface{} "success/message"
+// @Success     200     {object} map[string]interface{} "success/message/resend_available_in, plus challenge_id for /verify/wait on login requests when enabled"
 // @Failure     400     {object} map[string]string     "error"
+// @Failure     403     {object} map[string]string     "error"
+// @Failure     422     {object} map[string]string     "invalid phone number"
+// @Failure     429     {object} map[string]interface{} "error/resend_available_in, or error/unlock_at for a recently deleted number"
 // @Failure     500     {object} map[string]string     "error"
+// @Failure     503     {object} map[string]string     "error"
+// @Header      200,429 {integer} RateLimit-Limit     "requests allowed per window"
+// @Header      200,429 {integer} RateLimit-Remaining "requests left in the window"
+// @Header      200,429 {integer} RateLimit-Reset     "seconds until the window resets"
+// @Header      429     {integer} Retry-After         "seconds until a new request can succeed"
 // @Router      /request [post]
-func (app *application) handleRequestOTP(w http.ResponseWriter, r *http.Request) {
+func (app *application) handleRequestOTP(w http.ResponseWriter, r *http.Request) error {
 
 	var input struct {
-		PhoneNumber string `json:"phone_number"`
+		PhoneNumber  string `json:"phone_number"`
+		QR           bool   `json:"qr"`
+		Channel      string `json:"channel"`
+		Purpose      string `json:"purpose"`
+		CaptchaToken string `json:"captcha_token"`
 	}
 	if err := app.readJSON(w, r, &input); err != nil {
-		app.errorResponse(w, http.StatusBadRequest, "Invalid request payload")
-		app.logger.Println("Error reading JSON:", err)
-		return
+		return badRequest("Invalid request payload")
+	}
+	phone, err := data.ValidatePhoneNumber(input.PhoneNumber)
+	if err != nil {
+		return invalidPhone(err)
+	}
+	input.PhoneNumber = phone
+	channel, err := parseChannel(input.Channel)
+	if err != nil {
+		return badRequest(err.Error())
 	}
-	if input.PhoneNumber == "" {
-		app.errorResponse(w, http.StatusBadRequest, "Phone number is required")
-		return
+	purpose, err := app.parsePurpose(input.Purpose)
+	if err != nil {
+		return badRequest(err.Error())
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 3*time.Second)
 	defer cancel()
 
-	allowed, err := app.allowOTPRequest(ctx, input.PhoneNumber)
+	app.recordOTPOrigin(ctx, r, input.PhoneNumber)
+
+	// a number whose account was just deleted can't sign up again yet;
+	// there is no account left for it to log in to either
+	unlockAt, err := app.deletedPhoneUnlockAt(ctx, input.PhoneNumber)
 	if err != nil {
-		app.errorResponse(w, http.StatusInternalServerError, "rate limit error")
-		app.logger.Println("rate limit error:", err)
-		return
+		return fmt.Errorf("failed to look up deleted phone: %w", err)
 	}
-	if !allowed {
-		app.errorResponse(w, http.StatusTooManyRequests, "Too many OTP requests. Please try again later.")
-		return
+	if !unlockAt.IsZero() {
+		return &apiError{Status: http.StatusTooManyRequests, Code: "recently_deleted",
+			Message: "This phone number was recently removed and can't be registered again yet.",
+			Fields:  envelope{"unlock_at": unlockAt.UTC()}}
 	}
 
-	otp := generateOTP()
+	cooldown, err := app.otpCooldownRemaining(ctx, input.PhoneNumber)
+	if err != nil {
+		return fmt.Errorf("failed to read OTP cooldown: %w", err)
+	}
+	if cooldown > 0 {
+		return &apiError{Status: http.StatusTooManyRequests, Code: "rate_limited",
+			Message:    "Please wait before requesting a new OTP.",
+			Fields:     envelope{"resend_available_in": ceilSeconds(cooldown)},
+			RetryAfter: cooldown}
+	}
 
-	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	// checked before the rate limit so unsolved requests can't use up a
+	// number's allowance
+	if app.captcha != nil && !app.captchaBypassed(input.PhoneNumber) {
+		if input.CaptchaToken == "" {
+			return badRequest("captcha_token is required")
+		}
+		cctx, ccancel := context.WithTimeout(context.WithoutCancel(r.Context()), captchaTimeout)
+		ok, err := app.captcha.Verify(cctx, input.CaptchaToken, app.clientIP(r))
+		ccancel()
+		if err != nil {
+			return &apiError{Status: http.StatusServiceUnavailable, Code: "captcha_unavailable",
+				Message: "CAPTCHA verification is unavailable, please retry", Err: err}
+		}
+		if !ok {
+			return &apiError{Status: http.StatusForbidden, Code: "captcha_failed", Message: "CAPTCHA verification failed"}
+		}
+	}
+
+	limit, err := app.allowOTPRequest(ctx, input.PhoneNumber)
+	if err != nil {
+		return fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	limit.setHeaders(w)
+	if !limit.allowed {
+		return &apiError{Status: http.StatusTooManyRequests, Code: "rate_limited",
+			Message:    "Too many OTP requests. Please try again later.",
+			Fields:     envelope{"resend_available_in": ceilSeconds(limit.resetIn)},
+			RetryAfter: limit.resetIn}
+	}
+
+	if app.lineLookup != nil {
+		lctx, lcancel := context.WithTimeout(ctx, lineCheckTimeout)
+		blocked, lineType, err := app.phoneLineBlocked(lctx, input.PhoneNumber)
+		lcancel()
+		// a failed lookup lets the request through rather than locking out
+		// every user while the provider is down
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), "line type lookup error", "error", err)
+		} else if blocked {
+			app.logger.InfoContext(r.Context(), "audit: blocked OTP request", "line_type", lineType, "phone", maskPhone(input.PhoneNumber))
+			return &apiError{Status: http.StatusForbidden, Code: "phone_blocked",
+				Message: "This phone number cannot be used for verification."}
+		}
+	}
+
+	if input.QR {
+		if !app.conf.features.qrLogin() {
+			return badRequest("QR login is not enabled")
+		}
+		// handing a login link to the caller is only safe when the caller
+		// already owns the account
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() || app.contextGetScope(r) != "" || user.PhoneNumber != input.PhoneNumber {
+			return &apiError{Status: http.StatusForbidden, Code: "forbidden",
+				Message: "QR login is only available for your own signed-in number"}
+		}
+		return app.handleMagicQR(w, r, user)
+	}
+
+	if err := app.issueOTP(r, input.PhoneNumber, purpose, channel); err != nil {
+		return err
+	}
+	otpRequests.Inc()
+
+	resp := envelope{
+		"success":             true,
+		"message":             "OTP sent successfully",
+		"resend_available_in": ceilSeconds(app.conf.otp.resendCooldown),
+	}
+	// only a login code can complete a wait challenge
+	if app.conf.features.verifyWait() && purpose == purposeLogin {
+		challengeID, err := app.startVerifyWait(ctx, input.PhoneNumber)
+		if err != nil {
+			// the code is on its way; the client can still verify by hand
+			app.logger.ErrorContext(r.Context(), "Error starting verify wait", "error", err)
+		} else {
+			resp["challenge_id"] = challengeID
+		}
+	}
+	return app.writeJSON(w, http.StatusOK, resp, nil)
+}
+
+// issueOTP generates a code for phone, stores it and sends it on channel,
+// then starts the resend cooldown.
+func (app *application) issueOTP(r *http.Request, phone, purpose, channel string) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
 	defer cancel()
 
-	if err := app.storeOTPInRedis(ctx, input.PhoneNumber, otp); err != nil {
-		app.errorResponse(w, http.StatusInternalServerError, "Failed to store OTP")
-		app.logger.Println("Error storing OTP in Redis:", err)
-		return
+	otp, err := app.generateFreshOTP(ctx, phone, app.otpPurposeLength(purpose))
+	if err != nil {
+		return fmt.Errorf("failed to generate OTP: %w", err)
 	}
 
-	// NOTE: logging OTP is fine in dev; remove in prod
-	app.logger.Printf("OTP for %s: %s\n", input.PhoneNumber, otp)
+	ttl, err := app.otpChallengeTTL(ctx, phone, app.otpPurposeTTL(purpose))
+	if err != nil {
+		return fmt.Errorf("failed to read OTP challenge window: %w", err)
+	}
+
+	if err := app.storeOTPInRedis(ctx, phone, purpose, channel, otp, ttl); err != nil {
+		return fmt.Errorf("failed to store OTP: %w", err)
+	}
+
+	// an open circuit or used-up quota is answered with 503 by handleError
+	if _, err := app.sms.Send(ctx, phone, sms.RenderOTPMessage(app.conf.sms.branding, otp, app.conf.sms.maxSegments)); err != nil {
+		return fmt.Errorf("failed to send OTP: %w", err)
+	}
+
+	if err := app.startOTPCooldown(ctx, phone); err != nil {
+		app.logger.ErrorContext(r.Context(), "Error starting OTP cooldown", "error", err)
+	}
+	return nil
+}
+
+// swagger:model cancelOTPReq
+type cancelOTPReq struct {
+	// required: true
+	PhoneNumber string `json:"phone_number"`
+	// the pending login code; not needed when signed in as the number's owner
+	OTP string `json:"otp"`
+}
+
+// handleCancelOTP godoc
+// @Summary     Cancel OTP
+// @Description Invalidates the pending OTP for the given phone_number so a fresh one can be requested. The caller must send the pending code (checked and counted like a verify) or be signed in as the number's owner. Counts against the same per-phone limit as /request.
+// @Tags        Auth
+// @Accept      json
+// @Produce     json
+// @Param       payload body     cancelOTPReq true "OTP cancel payload"
+// @Success     200     {object} map[string]interface{} "success/message"
+// @Failure     400     {object} map[string]string     "error"
+// @Failure     401     {object} map[string]interface{} "error, or error/code: invalid_otp/attempts_remaining"
+// @Failure     409     {object} map[string]string     "verification in progress"
+// @Failure     422     {object} map[string]string     "invalid phone number"
+// @Failure     429     {object} map[string]interface{} "error/retry_after, or error/code: too_many_attempts"
+// @Failure     500     {object} map[string]string     "error"
+// @Failure     503     {object} map[string]string     "error"
+// @Header      200,429 {integer} RateLimit-Limit     "requests allowed per window"
+// @Header      200,429 {integer} RateLimit-Remaining "requests left in the window"
+// @Header      200,429 {integer} RateLimit-Reset     "seconds until the window resets"
+// @Router      /request/cancel [post]
+func (app *application) handleCancelOTP(w http.ResponseWriter, r *http.Request) error {
+	var input cancelOTPReq
+	if err := app.readJSON(w, r, &input); err != nil {
+		return badRequest("Invalid request payload")
+	}
+	phone, err := data.ValidatePhoneNumber(input.PhoneNumber)
+	if err != nil {
+		return invalidPhone(err)
+	}
+	input.PhoneNumber = phone
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancel()
+
+	// counted like a request so cancels can't be used to hammer a number
+	limit, err := app.allowOTPRequest(ctx, input.PhoneNumber)
+	if err != nil {
+		return fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	limit.setHeaders(w)
+	if !limit.allowed {
+		return tooManyRequests("Too many OTP requests. Please try again later.", limit.resetIn)
+	}
+
+	// only the number's owner may cancel its code: whoever holds the code,
+	// or the user signed in with that number
+	user := app.contextGetUser(r)
+	owner := !user.IsAnonymous() && app.contextGetScope(r) == "" && user.PhoneNumber == input.PhoneNumber
+	if !owner {
+		if input.OTP == "" {
+			return &apiError{Status: http.StatusUnauthorized, Code: "unauthorized",
+				Message: "Send the pending OTP or sign in as the owner of this phone number"}
+		}
+		// only proves ownership: a cancel isn't a verify, so it neither
+		// clears the attempts nor resets the request limit
+		if err := app.matchOTP(ctx, r, input.PhoneNumber, purposeLogin, channelSMS, input.OTP); err != nil {
+			return err
+		}
+	}
+
+	if err := app.cancelOTPInRedis(ctx, input.PhoneNumber); err != nil {
+		return fmt.Errorf("failed to cancel OTP: %w", err)
+	}
 
-	_ = app.writeJSON(w, http.StatusOK, envelope{
+	return app.writeJSON(w, http.StatusOK, envelope{
 		"success": true,
-		"message": "OTP sent successfully",
+		"message": "OTP cancelled",
 	}, nil)
 }
 
@@ -116,55 +343,196 @@ func (app *application) handleRequestOTP(w http.ResponseWriter, r *http.Request)
 // @Param       payload body     verifyOTPReq true "OTP verification payload"
 // @Success     200     {object} verifyOTPRes
 // @Failure     400     {object} map[string]string
-// @Failure     401     {object} map[string]string
+// @Failure     401     {object} map[string]interface{} "error/code: invalid_otp/attempts_remaining"
+// @Failure     403     {object} map[string]string     "not registered (invite-only)"
+// @Failure     409     {object} map[string]string     "verification in progress"
+// @Failure     422     {object} map[string]string     "invalid phone number"
+// @Failure     429     {object} map[string]string     "error/code: too_many_attempts"
 // @Failure     500     {object} map[string]string
 // @Router      /verify [post]
-func (app *application) handleVerifyOTP(w http.ResponseWriter, r *http.Request) {
+func (app *application) handleVerifyOTP(w http.ResponseWriter, r *http.Request) error {
 	var input struct {
 		PhoneNumber string `json:"phone_number"`
 		OTP         string `json:"otp"`
+		ClientID    string `json:"client_id"`
+		Channel     string `json:"channel"`
+		ChallengeID string `json:"challenge_id"`
 	}
 	if err := app.readJSON(w, r, &input); err != nil {
-		app.errorResponse(w, http.StatusBadRequest, "Invalid request payload")
-		app.logger.Println("Error reading JSON:", err)
-		return
+		return badRequest("Invalid request payload")
 	}
 	if input.PhoneNumber == "" || input.OTP == "" {
-		app.errorResponse(w, http.StatusBadRequest, "Phone number and OTP are required")
-		return
+		return badRequest("Phone number and OTP are required")
+	}
+	phone, err := data.ValidatePhoneNumber(input.PhoneNumber)
+	if err != nil {
+		return invalidPhone(err)
+	}
+	input.PhoneNumber = phone
+	if input.ClientID != "" && !app.isKnownClient(input.ClientID) {
+		return badRequest("Unknown client_id")
+	}
+	channel, err := parseChannel(input.Channel)
+	if err != nil {
+		return badRequest(err.Error())
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
 	defer cancel()
 
-	if err := app.verifyOTPInRedis(ctx, input.PhoneNumber, input.OTP); err != nil {
-		app.errorResponse(w, http.StatusUnauthorized, "Invalid or expired OTP")
-		app.logger.Println("OTP verification failed for", input.PhoneNumber, ":", err)
-		return
+	if err := app.checkOTP(ctx, r, input.PhoneNumber, purposeLogin, channel, input.OTP); err != nil {
+		return err
 	}
 
-	user, err := app.createUserIfNotExists(input.PhoneNumber)
+	// errNotRegistered is answered with 403 by handleError
+	user, created, err := app.createUserIfNotExists(ctx, input.PhoneNumber)
 	if err != nil {
-		app.errorResponse(w, http.StatusInternalServerError, "Failed to register user")
-		app.logger.Println("Error registering user:", err)
-		return
+		return fmt.Errorf("failed to register user: %w", err)
 	}
 
-	jwtToken, err := app.generateJWT(user.ID, 48*time.Hour)
+	if err := app.markVerified(ctx, user.ID); err != nil {
+		app.logger.ErrorContext(r.Context(), "Error recording verification time", "error", err)
+	}
+	// only the challenge this request names; a challenge someone else
+	// opened for the phone never receives this login
+	if app.conf.features.verifyWait() && input.ChallengeID != "" {
+		err := app.completeVerifyWait(ctx, input.ChallengeID, input.PhoneNumber, user.ID)
+		if errors.Is(err, errWaitChallengeInvalid) {
+			app.logger.WarnContext(r.Context(), "verify wait challenge not open for phone", "user_id", user.ID)
+		} else if err != nil {
+			app.logger.ErrorContext(r.Context(), "Error completing verify wait", "error", err)
+		}
+	}
+
+	jwtToken, err := app.generateJWT(user.ID, input.ClientID, sessionTokenTTL, app.userClaims(user))
 	if err != nil {
-		app.errorResponse(w, http.StatusInternalServerError, "Failed to generate JWT")
-		app.logger.Println("Error generating JWT for user ID", user.ID, ":", err)
-		return
+		return fmt.Errorf("failed to generate JWT for user %d: %w", user.ID, err)
 	}
 
-	_ = app.writeJSON(w, http.StatusOK, envelope{
-		"success": true,
-		"message": "User authenticated",
-		"data":    user,
-		"token":   jwtToken,
+	refreshToken, err := app.models.Token.New(user.ID, app.conf.refreshTokenTTL)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token for user %d: %w", user.ID, err)
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{
+		"success":       true,
+		"message":       "User authenticated",
+		"data":          user,
+		"created":       created,
+		"token":         jwtToken,
+		"refresh_token": refreshToken.Plaintext,
 	}, nil)
 }
 
+// checkOTP verifies the code through matchOTP. On success it clears the
+// attempt counter, closes the challenge and, with
+// conf.otp.resetLimitOnVerify, the request limit.
+func (app *application) checkOTP(ctx context.Context, r *http.Request, phoneNumber, purpose, channel, otp string) error {
+	if err := app.matchOTP(ctx, r, phoneNumber, purpose, channel, otp); err != nil {
+		return err
+	}
+
+	otpVerifications.WithLabelValues("success").Inc()
+
+	if err := app.clearOTPAttempts(ctx, phoneNumber); err != nil {
+		app.logger.ErrorContext(r.Context(), "Error clearing OTP attempts", "error", err)
+	}
+	if err := app.endOTPChallenge(ctx, phoneNumber); err != nil {
+		app.logger.ErrorContext(r.Context(), "Error ending OTP challenge", "error", err)
+	}
+
+	// a successful verify rewards the phone with a fresh request window;
+	// failed attempts above leave the counter untouched
+	if app.conf.otp.resetLimitOnVerify {
+		if _, err := app.clearOTPRateLimits(ctx, otpRequestLimitKeys(phoneNumber)); err != nil {
+			app.logger.ErrorContext(r.Context(), "rate limit reset error", "error", err)
+		}
+	}
+
+	return nil
+}
+
+// matchOTP enforces the attempt limit and consumes the code if it matches,
+// without the side effects of a verify; a wrong code counts as an attempt.
+// Checks of one phone are serialized by a lock; a concurrent one gets 409.
+func (app *application) matchOTP(ctx context.Context, r *http.Request, phoneNumber, purpose, channel, otp string) error {
+	// malformed input can't match, so it doesn't count as an attempt and
+	// gets no attempts_remaining that could be used to probe the counter
+	if length := app.otpPurposeLength(purpose); !wellFormedOTP(otp, length) {
+		return badRequest(fmt.Sprintf("OTP must be %d digits", length))
+	}
+
+	unlock, locked, err := app.lockOTPVerify(ctx, phoneNumber)
+	if err != nil {
+		return fmt.Errorf("failed to take verify lock: %w", err)
+	}
+	if !locked {
+		return &apiError{Status: http.StatusConflict, Code: "verify_in_progress", Message: "Verification in progress. Please retry."}
+	}
+	defer unlock()
+
+	attempts, err := app.otpAttempts(ctx, phoneNumber)
+	if err != nil {
+		return fmt.Errorf("failed to read OTP attempts: %w", err)
+	}
+	// slows down guessing in proportion to the failures so far
+	app.tarpit(r.Context(), attempts)
+
+	if attempts >= int64(app.conf.otp.maxAttempts) {
+		app.logVerifyFailure(r, phoneNumber, "locked_out", attempts)
+		return tooManyOTPAttempts()
+	}
+
+	err = app.consumeOTPInRedis(ctx, phoneNumber, purpose, channel, otp)
+	if errors.Is(err, errOTPWrongChannel) {
+		// a client mix-up rather than a guess, so it isn't counted as an attempt
+		otpVerifications.WithLabelValues("invalid").Inc()
+		return badRequest(err.Error())
+	}
+	if err != nil && !errors.Is(err, errOTPExpired) && !errors.Is(err, errOTPMismatch) {
+		// an infrastructure failure says nothing about the code, so it
+		// isn't counted against the user
+		return fmt.Errorf("failed to read OTP: %w", err)
+	}
+	if err != nil {
+		reason := "wrong_code"
+		if errors.Is(err, errOTPExpired) {
+			reason = "no_pending_code"
+			otpVerifications.WithLabelValues("expired").Inc()
+		} else {
+			otpVerifications.WithLabelValues("invalid").Inc()
+		}
+
+		invalid := &apiError{Status: http.StatusUnauthorized, Code: "invalid_otp", Message: "Invalid or expired OTP"}
+		attempts, err := app.recordFailedOTPAttempt(ctx, phoneNumber)
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), "Error recording OTP attempt", "error", err)
+			return invalid
+		}
+		app.logVerifyFailure(r, phoneNumber, reason, attempts)
+		if attempts >= int64(app.conf.otp.maxAttempts) {
+			// the code is burned; a new one can only be requested once the
+			// lockout expires
+			if err := app.cancelOTPInRedis(ctx, phoneNumber); err != nil {
+				app.logger.ErrorContext(r.Context(), "Error invalidating OTP", "error", err)
+			}
+			return tooManyOTPAttempts()
+		}
+
+		invalid.Fields = envelope{"attempts_remaining": int64(app.conf.otp.maxAttempts) - attempts}
+		return invalid
+	}
+
+	return nil
+}
+
+// tooManyOTPAttempts answers a verify once the phone used up
+// conf.otp.maxAttempts; the code lets clients tell it from a wrong code.
+func tooManyOTPAttempts() *apiError {
+	return &apiError{Status: http.StatusTooManyRequests, Code: "too_many_attempts",
+		Message: "Too many incorrect attempts. Please try again later."}
+}
+
 // protectedHandler godoc
 // @Summary     Protected resource
 // @Description Requires Bearer token (Authorization: Bearer <token>)
@@ -174,7 +542,7 @@ func (app *application) handleVerifyOTP(w http.ResponseWriter, r *http.Request)
 // @Success     200 {object} protectedRes
 // @Failure     401 {object} map[string]string
 // @Router      /protected [get]
-func (app *application) protectedHandler(w http.ResponseWriter, r *http.Request) {
+func (app *application) protectedHandler(w http.ResponseWriter, r *http.Request) error {
 	user := app.contextGetUser(r)
 
 	// Parse JWT again to read exp
@@ -183,14 +551,12 @@ func (app *application) protectedHandler(w http.ResponseWriter, r *http.Request)
 
 	parsed, _, err := new(jwt.Parser).ParseUnverified(tokenStr, &jwt.RegisteredClaims{})
 	if err != nil {
-		app.errorResponse(w, http.StatusUnauthorized, "Invalid token")
-		return
+		return &apiError{Status: http.StatusUnauthorized, Code: "invalid_token", Message: "Invalid token"}
 	}
 
 	claims, ok := parsed.Claims.(*jwt.RegisteredClaims)
 	if !ok || claims.ExpiresAt == nil {
-		app.errorResponse(w, http.StatusUnauthorized, "Token missing expiration")
-		return
+		return &apiError{Status: http.StatusUnauthorized, Code: "invalid_token", Message: "Token missing expiration"}
 	}
 
 	resp := protectedRes{
@@ -199,7 +565,7 @@ func (app *application) protectedHandler(w http.ResponseWriter, r *http.Request)
 		ExpiresAt: claims.ExpiresAt.Time,
 	}
 
-	_ = app.writeJSON(w, http.StatusOK, envelope{
+	return app.writeJSON(w, http.StatusOK, envelope{
 		"message":    resp.Message,
 		"phone":      resp.Phone,
 		"expires_at": resp.ExpiresAt,
@@ -220,43 +586,34 @@ type SingleUserEnvelope struct {
 // @Param        id   path      int  true  "User ID"
 // @Success      200  {object}  SingleUserEnvelope
 // @Failure      400  {object}  map[string]string  "invalid user id"
-// @Failure      404  {object}  map[string]string  "user not found"
-// @Failure      500  {object}  map[string]string  "failed to fetch user"
+// @Failure      404  {object}  map[string]string  "error/code: not_found"
+// @Failure      500  {object}  map[string]string  "error/code: internal_error"
 // @Security     BearerAuth
 // @Router       /users/{id} [get]
-func (app *application) getSingleUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	idStr := ps.ByName("id")
+func (app *application) getSingleUser(w http.ResponseWriter, r *http.Request) error {
+	idStr := httprouter.ParamsFromContext(r.Context()).ByName("id")
 	userID, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		app.errorResponse(w, http.StatusBadRequest, "invalid user id")
-		return
+		return badRequest("invalid user id")
 	}
 
 	user, err := app.models.User.GetByID(userID)
 	if err != nil {
-		if errors.Is(err, data.ErrRecordNotFound) {
-			app.errorResponse(w, http.StatusNotFound, "user not found")
-			return
-		}
-		app.logger.Println("get user error:", err)
-		app.errorResponse(w, http.StatusInternalServerError, "failed to fetch user")
-		return
+		return err
 	}
 
-	_ = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	return app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 }
 
 // UsersListResponse is the payload returned for user listing.
 type UsersListResponse struct {
-	Items    []data.User `json:"items"`
-	Page     int         `json:"page"`
-	PageSize int         `json:"page_size"`
-	Total    int         `json:"total"`
+	Items []data.User `json:"items"`
+	Pagination
 }
 
 // UsersListResponseEnvelope is used only for Swagger to document the envelope shape.
 type UsersListResponseEnvelope struct {
-	Response UsersListResponse `json:"response"`
+	Data UsersListResponse `json:"data"`
 }
 
 // handleListUsers returns a paginated list of users with optional search.
@@ -266,55 +623,60 @@ type UsersListResponseEnvelope struct {
 // @Tags         users
 // @Accept       json
 // @Produce      json
-// @Param        q          query     string  false  "Search term (matches phone)"
+// @Param        q          query     string  false  "Search term (matches phone); when empty, lists all users or returns an empty page depending on configuration"
+// @Param        match      query     string  false  "Search mode: contains (default) or prefix"  Enums(contains, prefix)
 // @Param        page       query     int     false  "Page number (1-based, default 1)"
 // @Param        page_size  query     int     false  "Page size (max 100, default 20)"
-// @Success      200  {object}  map[string]UsersListResponseEnvelope  "envelope with 'response' key"
+// @Success      200  {object}  map[string]UsersListResponseEnvelope  "envelope with 'data' key ('response' in legacy mode)"
+// @Failure      400  {object}  map[string]string  "invalid match, page_size or pagination too deep"
 // @Failure      500  {object}  map[string]string  "failed to fetch users"
 // @Security     BearerAuth
 // @Router       /users [get]
-func (app *application) handleListUsers(w http.ResponseWriter, r *http.Request) {
+func (app *application) handleListUsers(w http.ResponseWriter, r *http.Request) error {
 	qp := r.URL.Query()
 
 	q := strings.TrimSpace(qp.Get("q"))
 
-	page := atoiDefault(qp.Get("page"), 1)
-	if page < 1 {
-		page = 1
+	page, pageSize, err := app.parsePagination(r)
+	if err != nil {
+		return badRequest(err.Error())
 	}
-	pageSize := atoiDefault(qp.Get("page_size"), 20)
-	if pageSize < 1 {
-		pageSize = 20
+
+	match := qp.Get("match")
+	if match == "" {
+		match = data.MatchContains
 	}
-	if pageSize > 100 {
-		pageSize = 100
+	if match != data.MatchContains && match != data.MatchPrefix {
+		return badRequest("match must be contains or prefix")
 	}
 
 	filter := data.UserFilter{
 		Q:        q,
+		Match:    match,
 		Page:     page,
 		PageSize: pageSize,
 	}
 
+	// without a search term some deployments return nothing rather than
+	// the whole table
+	if q == "" && !app.conf.emptySearchListsAll {
+		resp := UsersListResponse{Pagination: paginationMeta(page, pageSize, 0)}
+		return app.writeJSON(w, http.StatusOK, app.listEnvelope(resp), nil)
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
 	users, total, err := app.models.User.List(ctx, filter)
 	if err != nil {
-		app.logger.Println("list users error:", err)
-		app.errorResponse(w, http.StatusInternalServerError, "failed to fetch users")
-		return
+		return fmt.Errorf("failed to fetch users: %w", err)
 	}
 
 	resp := UsersListResponse{
-		Items:    users,
-		Page:     page,
-		PageSize: pageSize,
-		Total:    total,
+		Items:      users,
+		Pagination: paginationMeta(page, pageSize, total),
 	}
-	app.writeJSON(w, http.StatusOK, envelope{
-		"response": resp,
-	}, nil)
+	return app.writeJSON(w, http.StatusOK, app.listEnvelope(resp), nil)
 }
 
 func atoiDefault(s string, def int) int {
@@ -327,3 +689,67 @@ func atoiDefault(s string, def int) int {
 	}
 	return n
 }
+
+// LimitsResponse lists the public OTP limits. Durations are in seconds.
+type LimitsResponse struct {
+	OTPLength           int    `json:"otp_length"`
+	OTPTTL              int64  `json:"otp_ttl"`
+	MaxRequests         int    `json:"max_requests"`
+	RequestWindow       int64  `json:"request_window"`
+	RateLimitAlgorithm  string `json:"rate_limit_algorithm"`
+	ResendCooldown      int64  `json:"resend_cooldown"`
+	MaxVerifyAttempts   int    `json:"max_verify_attempts"`
+	VerifyLockoutWindow int64  `json:"verify_lockout_window"`
+}
+
+// handleConfigLimits godoc
+// @Summary     Effective limits
+// @Description Returns the OTP and rate-limit settings clients should respect. The OTP length and TTL are those of login codes; see /otp/meta for other purposes. Durations are in seconds. No secrets are included.
+// @Tags        Auth
+// @Produce     json
+// @Success     200 {object} map[string]LimitsResponse "envelope with 'limits' key"
+// @Router      /config/limits [get]
+func (app *application) handleConfigLimits(w http.ResponseWriter, r *http.Request) error {
+	limits := LimitsResponse{
+		OTPLength:           app.otpPurposeLength(purposeLogin),
+		OTPTTL:              ceilSeconds(app.otpPurposeTTL(purposeLogin)),
+		MaxRequests:         app.conf.otp.rateLimitMax,
+		RequestWindow:       ceilSeconds(app.conf.otp.rateLimitWindow),
+		RateLimitAlgorithm:  app.conf.otp.rateLimitAlgorithm,
+		ResendCooldown:      ceilSeconds(app.conf.otp.resendCooldown),
+		MaxVerifyAttempts:   app.conf.otp.maxAttempts,
+		VerifyLockoutWindow: ceilSeconds(app.conf.otp.attemptsTTL),
+	}
+	return app.writeJSON(w, http.StatusOK, envelope{"limits": limits}, nil)
+}
+
+// OTPMetaResponse describes the OTP format for client input fields.
+type OTPMetaResponse struct {
+	Length         int    `json:"length"`
+	Type           string `json:"type" enums:"numeric,alphanumeric"`
+	TTL            int64  `json:"ttl"`
+	ResendCooldown int64  `json:"resend_cooldown"`
+}
+
+// handleOTPMeta godoc
+// @Summary     OTP format hints
+// @Description Returns the OTP length, character type and timings (in seconds) so clients can configure their code input and countdown.
+// @Tags        Auth
+// @Produce     json
+// @Param       purpose query string false "OTP purpose (default login)"
+// @Success     200 {object} map[string]OTPMetaResponse "envelope with 'otp' key"
+// @Failure     400 {object} map[string]string
+// @Router      /otp/meta [get]
+func (app *application) handleOTPMeta(w http.ResponseWriter, r *http.Request) error {
+	purpose, err := app.parsePurpose(r.URL.Query().Get("purpose"))
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	meta := OTPMetaResponse{
+		Length:         app.otpPurposeLength(purpose),
+		Type:           otpType,
+		TTL:            ceilSeconds(app.otpPurposeTTL(purpose)),
+		ResendCooldown: ceilSeconds(app.conf.otp.resendCooldown),
+	}
+	return app.writeJSON(w, http.StatusOK, envelope{"otp": meta}, nil)
+}
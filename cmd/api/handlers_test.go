@@ -0,0 +1,799 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/julienschmidt/httprouter"
+)
+
+const testPhone = "+989121234567"
+
+// issueTestOTP stores code as the phone's pending login code.
+func issueTestOTP(t *testing.T, app *application, phone, code string) {
+	t.Helper()
+	err := app.storeOTPInRedis(context.Background(), phone, purposeLogin, channelSMS, code, otpTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkTestOTP runs checkOTP for a login code and returns the error
+// response it maps to, or nil when the code was accepted.
+func checkTestOTP(t *testing.T, app *application, phone, code string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/verify", nil)
+	err := app.checkOTP(context.Background(), r, phone, purposeLogin, channelSMS, code)
+	if err == nil {
+		return nil
+	}
+	app.handleError(w, r, err)
+	return w
+}
+
+var sentCodeRx = regexp.MustCompile(`\b[0-9]{4,8}\b`)
+
+// requestTestOTP runs /request for phone through a fake sender and returns
+// the code it sent.
+func requestTestOTP(t *testing.T, app *application, phone string) string {
+	t.Helper()
+	sender := &fakeSender{}
+	app.sms = sender
+	w := postJSON(app.handle(app.handleRequestOTP), "/request", `{"phone_number":"`+phone+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("/request: want 200, got %d %s", w.Code, w.Body)
+	}
+	if len(sender.sent) != 1 {
+		t.Fatalf("/request sent %d messages, want 1", len(sender.sent))
+	}
+	code := sentCodeRx.FindString(sender.sent[0])
+	if code == "" {
+		t.Fatalf("no code in %q", sender.sent[0])
+	}
+	return code
+}
+
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+	return body
+}
+
+func TestCheckOTPAttemptsRemainingDecrements(t *testing.T) {
+	app, _ := newTestApp(t)
+	issueTestOTP(t, app, testPhone, "123456")
+
+	max := app.conf.otp.maxAttempts
+	for i := 1; i < max; i++ {
+		w := checkTestOTP(t, app, testPhone, "000000")
+		if w == nil || w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: want 401, got %v", i, w)
+		}
+		body := decodeBody(t, w)
+		if got, want := body["attempts_remaining"], float64(max-i); got != want {
+			t.Fatalf("attempt %d: attempts_remaining = %v, want %v", i, got, want)
+		}
+		if body["code"] != "invalid_otp" {
+			t.Fatalf("attempt %d: code = %v, want invalid_otp", i, body["code"])
+		}
+	}
+
+	// the last allowed attempt burns the code
+	w := checkTestOTP(t, app, testPhone, "000000")
+	if w == nil || w.Code != http.StatusTooManyRequests {
+		t.Fatalf("final attempt: want 429, got %v", w)
+	}
+	if code := decodeBody(t, w)["code"]; code != "too_many_attempts" {
+		t.Fatalf("final attempt: code = %v, want too_many_attempts", code)
+	}
+	if w := checkTestOTP(t, app, testPhone, "123456"); w == nil || w.Code != http.StatusTooManyRequests {
+		t.Fatalf("correct code after lockout: want 429, got %v", w)
+	}
+}
+
+func TestCheckOTPMalformedIsNotAnAttempt(t *testing.T) {
+	app, _ := newTestApp(t)
+	issueTestOTP(t, app, testPhone, "123456")
+
+	for _, code := range []string{"abc", "12345", "1234567", "12345a", "１２３４５６"} {
+		w := checkTestOTP(t, app, testPhone, code)
+		if w == nil || w.Code != http.StatusBadRequest {
+			t.Fatalf("%q: want 400, got %v", code, w)
+		}
+		if _, ok := decodeBody(t, w)["attempts_remaining"]; ok {
+			t.Fatalf("%q: response leaks attempts_remaining", code)
+		}
+	}
+
+	attempts, err := app.otpAttempts(context.Background(), testPhone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 0 {
+		t.Fatalf("attempts = %d after malformed codes, want 0", attempts)
+	}
+	if w := checkTestOTP(t, app, testPhone, "123456"); w != nil {
+		t.Fatalf("correct code rejected: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestCheckOTPRedisErrorIsNotAnAttempt(t *testing.T) {
+	app, mr := newTestApp(t)
+	// a string where the code hash should be makes the consume script fail
+	if err := mr.Set(otpCodeKey(testPhone, purposeLogin), "not-a-hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := checkTestOTP(t, app, testPhone, "123456")
+	if w == nil || w.Code != http.StatusInternalServerError {
+		t.Fatalf("want 500, got %v", w)
+	}
+	if _, ok := decodeBody(t, w)["attempts_remaining"]; ok {
+		t.Fatal("response leaks attempts_remaining")
+	}
+	attempts, err := app.otpAttempts(context.Background(), testPhone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 0 {
+		t.Fatalf("attempts = %d after a Redis error, want 0", attempts)
+	}
+}
+
+func TestCheckOTPLockoutOutlivesCode(t *testing.T) {
+	app, mr := newTestApp(t)
+	issueTestOTP(t, app, testPhone, "123456")
+
+	for i := 0; i < app.conf.otp.maxAttempts; i++ {
+		checkTestOTP(t, app, testPhone, "000000")
+	}
+
+	// the code's TTL is far shorter than the lockout; a code issued once
+	// it has expired must still be refused
+	if app.conf.otp.attemptsTTL <= otpTTL {
+		t.Fatalf("attempts TTL %s must outlast the code TTL %s", app.conf.otp.attemptsTTL, otpTTL)
+	}
+	mr.FastForward(otpTTL + time.Second)
+	issueTestOTP(t, app, testPhone, "654321")
+	w := checkTestOTP(t, app, testPhone, "654321")
+	if w == nil || w.Code != http.StatusTooManyRequests {
+		t.Fatalf("correct code after the old one expired: want 429, got %v", w)
+	}
+	if decodeBody(t, w)["code"] != "too_many_attempts" {
+		t.Fatalf("code = %v, want too_many_attempts", decodeBody(t, w)["code"])
+	}
+
+	// once the lockout itself expires the phone can verify again
+	mr.FastForward(app.conf.otp.attemptsTTL)
+	issueTestOTP(t, app, testPhone, "111111")
+	if w := checkTestOTP(t, app, testPhone, "111111"); w != nil {
+		t.Fatalf("correct code after the lockout: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestCheckOTPConflictsWhileLocked(t *testing.T) {
+	app, _ := newTestApp(t)
+	issueTestOTP(t, app, testPhone, "123456")
+
+	unlock, ok, err := app.lockOTPVerify(context.Background(), testPhone)
+	if err != nil || !ok {
+		t.Fatalf("taking the verify lock: ok %v, err %v", ok, err)
+	}
+
+	w := checkTestOTP(t, app, testPhone, "000000")
+	if w == nil || w.Code != http.StatusConflict {
+		t.Fatalf("verify while locked: want 409, got %v", w)
+	}
+	attempts, err := app.otpAttempts(context.Background(), testPhone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 0 {
+		t.Fatalf("attempts = %d after a 409, want 0", attempts)
+	}
+
+	unlock()
+	if w := checkTestOTP(t, app, testPhone, "123456"); w != nil {
+		t.Fatalf("verify after unlock: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestCheckOTPConcurrentVerifiesConsumeOnce(t *testing.T) {
+	app, _ := newTestApp(t)
+	issueTestOTP(t, app, testPhone, "123456")
+
+	const n = 20
+	results := make(chan *httptest.ResponseRecorder, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- checkTestOTP(t, app, testPhone, "123456")
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	accepted := 0
+	for w := range results {
+		switch {
+		case w == nil:
+			accepted++
+		case w.Code == http.StatusConflict, w.Code == http.StatusUnauthorized:
+			// lost the lock, or took it after the code was consumed
+		default:
+			t.Errorf("unexpected response %d %s", w.Code, w.Body)
+		}
+	}
+	if accepted != 1 {
+		t.Fatalf("%d verifies accepted the code, want 1", accepted)
+	}
+}
+
+func TestOversizedPhoneTouchesNoRedisKeys(t *testing.T) {
+	app, mr := newTestApp(t)
+	phone := "+" + strings.Repeat("9", 50_000)
+
+	for _, tt := range []struct {
+		path string
+		h    http.HandlerFunc
+		body string
+	}{
+		{"/request", app.handle(app.handleRequestOTP), `{"phone_number":"` + phone + `"}`},
+		{"/resend", app.handle(app.handleResendOTP), `{"phone_number":"` + phone + `"}`},
+		{"/verify", app.handle(app.handleVerifyOTP), `{"phone_number":"` + phone + `","otp":"123456"}`},
+	} {
+		if w := postJSON(tt.h, tt.path, tt.body); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: want 422, got %d %s", tt.path, w.Code, w.Body)
+		}
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Fatalf("Redis keys created for an oversized phone: %q", keys)
+	}
+}
+
+// requestCount returns the phone's current OTP request count, counting the
+// check itself.
+func requestCount(t *testing.T, app *application, phone string) int64 {
+	t.Helper()
+	res, err := app.allowOTPRequest(context.Background(), phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.count
+}
+
+func TestVerifyResetsRequestLimit(t *testing.T) {
+	for _, algorithm := range []string{"fixed", "sliding"} {
+		t.Run(algorithm, func(t *testing.T) {
+			app, _ := newTestApp(t)
+			app.conf.otp.rateLimitAlgorithm = algorithm
+			app.conf.otp.resetLimitOnVerify = true
+			issueTestOTP(t, app, testPhone, "123456")
+
+			if n := requestCount(t, app, testPhone); n != 1 {
+				t.Fatalf("first request counted as %d", n)
+			}
+			checkTestOTP(t, app, testPhone, "000000")
+			if n := requestCount(t, app, testPhone); n != 2 {
+				t.Fatalf("count after a failed verify = %d, want 2", n)
+			}
+
+			if w := checkTestOTP(t, app, testPhone, "123456"); w != nil {
+				t.Fatalf("verify: %d %s", w.Code, w.Body)
+			}
+			if n := requestCount(t, app, testPhone); n != 1 {
+				t.Fatalf("count after a successful verify = %d, want a fresh window", n)
+			}
+		})
+	}
+}
+
+func TestVerifyKeepsRequestLimitWhenDisabled(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.otp.resetLimitOnVerify = false
+	issueTestOTP(t, app, testPhone, "123456")
+
+	requestCount(t, app, testPhone)
+	if w := checkTestOTP(t, app, testPhone, "123456"); w != nil {
+		t.Fatalf("verify: %d %s", w.Code, w.Body)
+	}
+	if n := requestCount(t, app, testPhone); n != 2 {
+		t.Fatalf("count after a verify = %d, want 2", n)
+	}
+}
+
+func TestCancelOTP(t *testing.T) {
+	app, _ := newTestApp(t)
+	code := requestTestOTP(t, app, testPhone)
+
+	// the resend cooldown holds back a second request
+	if w := postJSON(app.handle(app.handleRequestOTP), "/request", `{"phone_number":"`+testPhone+`"}`); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request inside the cooldown: want 429, got %d %s", w.Code, w.Body)
+	}
+
+	cancel := withUser(app, data.AnonymousUser, app.handle(app.handleCancelOTP))
+	w := postJSON(cancel, "/request/cancel", `{"phone_number":"+98 912 123 4567","otp":"`+code+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("cancel: want 200, got %d %s", w.Code, w.Body)
+	}
+
+	if w := checkTestOTP(t, app, testPhone, code); w == nil || w.Code != http.StatusUnauthorized {
+		t.Fatalf("cancelled code: want 401, got %v", w)
+	}
+	fresh := requestTestOTP(t, app, testPhone)
+	if w := checkTestOTP(t, app, testPhone, fresh); w != nil {
+		t.Fatalf("code requested after cancelling: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestCancelOTPRequiresOwnership(t *testing.T) {
+	app, _ := newTestApp(t)
+	code := requestTestOTP(t, app, testPhone)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	body := func(otp string) string {
+		return `{"phone_number":"` + testPhone + `","otp":"` + otp + `"}`
+	}
+
+	for _, tt := range []struct {
+		name string
+		user *data.User
+		otp  string
+		want int
+	}{
+		{"anonymous without a code", data.AnonymousUser, "", http.StatusUnauthorized},
+		{"someone else signed in", &data.User{ID: 8, PhoneNumber: "+989121111111"}, "", http.StatusUnauthorized},
+		{"anonymous with a wrong code", data.AnonymousUser, wrong, http.StatusUnauthorized},
+	} {
+		// each refused cancel also counts against the request limit
+		if _, err := app.clearOTPRateLimits(context.Background(), otpRequestLimitKeys(testPhone)); err != nil {
+			t.Fatal(err)
+		}
+		w := postJSON(withUser(app, tt.user, app.handle(app.handleCancelOTP)), "/request/cancel", body(tt.otp))
+		if w.Code != tt.want {
+			t.Errorf("%s: want %d, got %d %s", tt.name, tt.want, w.Code, w.Body)
+		}
+	}
+
+	// the wrong guess counted as a verify attempt
+	if attempts, err := app.otpAttempts(context.Background(), testPhone); err != nil || attempts != 1 {
+		t.Fatalf("attempts = %d, %v; want 1", attempts, err)
+	}
+	if w := checkTestOTP(t, app, testPhone, code); w != nil {
+		t.Fatalf("code after refused cancels: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestCancelOTPByOwner(t *testing.T) {
+	app, _ := newTestApp(t)
+	code := requestTestOTP(t, app, testPhone)
+
+	owner := &data.User{ID: 7, PhoneNumber: testPhone}
+	w := postJSON(withUser(app, owner, app.handle(app.handleCancelOTP)), "/request/cancel", `{"phone_number":"`+testPhone+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("owner cancel: want 200, got %d %s", w.Code, w.Body)
+	}
+	if w := checkTestOTP(t, app, testPhone, code); w == nil || w.Code != http.StatusUnauthorized {
+		t.Fatalf("cancelled code: want 401, got %v", w)
+	}
+}
+
+func TestCancelOTPByCodeIsNotAVerify(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.otp.resetLimitOnVerify = true
+	code := requestTestOTP(t, app, testPhone)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	if w := checkTestOTP(t, app, testPhone, wrong); w == nil || w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong code: want 401, got %v", w)
+	}
+
+	cancel := withUser(app, data.AnonymousUser, app.handle(app.handleCancelOTP))
+	w := postJSON(cancel, "/request/cancel", `{"phone_number":"`+testPhone+`","otp":"`+code+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("cancel: want 200, got %d %s", w.Code, w.Body)
+	}
+
+	if attempts, err := app.otpAttempts(context.Background(), testPhone); err != nil || attempts != 1 {
+		t.Fatalf("attempts after cancelling = %d, %v; want the failed verify kept", attempts, err)
+	}
+	// the request and the cancel, plus this check
+	if n := requestCount(t, app, testPhone); n != 3 {
+		t.Fatalf("count after cancelling = %d, want 3", n)
+	}
+}
+
+func TestCancelOTPIsRateLimited(t *testing.T) {
+	app, _ := newTestApp(t)
+	owner := &data.User{ID: 7, PhoneNumber: testPhone}
+	cancel := withUser(app, owner, app.handle(app.handleCancelOTP))
+
+	for i := 1; i <= app.conf.otp.rateLimitMax; i++ {
+		if w := postJSON(cancel, "/request/cancel", `{"phone_number":"`+testPhone+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("cancel %d: want 200, got %d %s", i, w.Code, w.Body)
+		}
+	}
+	w := postJSON(cancel, "/request/cancel", `{"phone_number":"`+testPhone+`"}`)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("cancel over the limit: want 429, got %d %s", w.Code, w.Body)
+	}
+	// and it shares the bucket with /request
+	if w := postJSON(app.handle(app.handleRequestOTP), "/request", `{"phone_number":"`+testPhone+`"}`); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request after exhausting the limit with cancels: want 429, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRequestOTPPerPurposeCodes(t *testing.T) {
+	app, mr := newTestApp(t)
+	app.conf.otpPurposes = map[string]otpPurposeConf{
+		purposeLogin:   {},
+		"phone_change": {ttl: 5 * time.Minute, length: 8},
+	}
+	sender := &fakeSender{}
+	app.sms = sender
+
+	for _, tt := range []struct {
+		purpose string
+		length  int
+		ttl     time.Duration
+	}{
+		{purposeLogin, app.conf.otpLength, otpTTL},
+		{"phone_change", 8, 5 * time.Minute},
+	} {
+		sender.sent = nil
+		w := postJSON(app.handle(app.handleRequestOTP), "/request", `{"phone_number":"`+testPhone+`","purpose":"`+tt.purpose+`"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: want 200, got %d %s", tt.purpose, w.Code, w.Body)
+		}
+		if code := sentCodeRx.FindString(sender.sent[0]); len(code) != tt.length {
+			t.Errorf("%s: code %q, want %d digits", tt.purpose, code, tt.length)
+		}
+		if ttl := mr.TTL(otpCodeKey(testPhone, tt.purpose)); ttl != tt.ttl {
+			t.Errorf("%s: code TTL = %v, want %v", tt.purpose, ttl, tt.ttl)
+		}
+		mr.Del(otpCooldownKey(testPhone))
+	}
+}
+
+func TestConfigLimitsMatchesLoginMeta(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.otpPurposes[purposeLogin] = otpPurposeConf{ttl: 3 * time.Minute, length: 8}
+
+	w := httptest.NewRecorder()
+	app.handle(app.handleConfigLimits)(w, httptest.NewRequest(http.MethodGet, "/config/limits", nil))
+	limits := decodeBody(t, w)["limits"].(map[string]any)
+
+	w = httptest.NewRecorder()
+	app.handle(app.handleOTPMeta)(w, httptest.NewRequest(http.MethodGet, "/otp/meta", nil))
+	meta := decodeBody(t, w)["otp"].(map[string]any)
+
+	if limits["otp_length"] != meta["length"] || limits["otp_ttl"] != meta["ttl"] {
+		t.Fatalf("limits report length %v ttl %v, /otp/meta %v and %v",
+			limits["otp_length"], limits["otp_ttl"], meta["length"], meta["ttl"])
+	}
+	if limits["otp_ttl"] != float64(180) {
+		t.Fatalf("otp_ttl = %v, want the login override of 180", limits["otp_ttl"])
+	}
+}
+
+func TestRequestOTPOpensVerifyWaitOnlyForLogin(t *testing.T) {
+	app, mr := newTestApp(t)
+	app.conf.features = featureFlags{featureVerifyWait: true}
+	app.sms = &fakeSender{}
+
+	for _, tt := range []struct {
+		purpose string
+		want    bool
+	}{
+		{"step_up", false},
+		{purposeLogin, true},
+	} {
+		mr.Del(otpCooldownKey(testPhone))
+		w := postJSON(app.handle(app.handleRequestOTP), "/request", `{"phone_number":"`+testPhone+`","purpose":"`+tt.purpose+`"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: want 200, got %d %s", tt.purpose, w.Code, w.Body)
+		}
+		if _, ok := decodeBody(t, w)["challenge_id"]; ok != tt.want {
+			t.Errorf("%s: challenge_id returned = %v, want %v", tt.purpose, ok, tt.want)
+		}
+	}
+}
+
+func TestVerifyOTPAuditsOnlyNewUsers(t *testing.T) {
+	for _, inserted := range []bool{true, false} {
+		app, _ := newTestApp(t)
+		var logs bytes.Buffer
+		app.logger = slog.New(slog.NewTextHandler(&logs, nil))
+		mock := mockDB(t, app)
+		expectLogin(mock, &data.User{ID: 7, PhoneNumber: testPhone}, inserted)
+		issueTestOTP(t, app, testPhone, "123456")
+
+		w := postJSON(app.handle(app.handleVerifyOTP), "/verify", `{"phone_number":"`+testPhone+`","otp":"123456"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("inserted=%v: want 200, got %d %s", inserted, w.Code, w.Body)
+		}
+		if created := decodeBody(t, w)["created"]; created != inserted {
+			t.Errorf("inserted=%v: created = %v", inserted, created)
+		}
+		if audited := strings.Contains(logs.String(), "audit: registered user"); audited != inserted {
+			t.Errorf("inserted=%v: registration audited = %v", inserted, audited)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestRequestOTPUsesInjectedRandomness(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.random = otpDraws(424242)
+
+	if code := requestTestOTP(t, app, testPhone); code != "424242" {
+		t.Fatalf("sent code %q, want the injected 424242", code)
+	}
+	if w := checkTestOTP(t, app, testPhone, "424242"); w != nil {
+		t.Fatalf("verify: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestConfigLimitsReportsEffectiveSettings(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.otp.maxAttempts = 3
+	app.conf.otp.rateLimitMax = 7
+	app.conf.otp.rateLimitWindow = 30 * time.Minute
+	app.conf.otp.rateLimitAlgorithm = "sliding"
+	app.conf.otp.resendCooldown = 90 * time.Second
+	app.conf.otp.attemptsTTL = time.Hour
+
+	w := httptest.NewRecorder()
+	app.handle(app.handleConfigLimits)(w, httptest.NewRequest(http.MethodGet, "/config/limits", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", w.Code, w.Body)
+	}
+	limits := decodeBody(t, w)["limits"].(map[string]any)
+	want := map[string]any{
+		"max_requests":          float64(7),
+		"request_window":        float64(1800),
+		"rate_limit_algorithm":  "sliding",
+		"resend_cooldown":       float64(90),
+		"max_verify_attempts":   float64(3),
+		"verify_lockout_window": float64(3600),
+	}
+	for k, v := range want {
+		if limits[k] != v {
+			t.Errorf("%s = %v, want %v", k, limits[k], v)
+		}
+	}
+}
+
+// getUsers calls handleListUsers with query.
+func getUsers(app *application, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	app.handle(app.handleListUsers)(w, httptest.NewRequest(http.MethodGet, "/users?"+query, nil))
+	return w
+}
+
+func TestListUsersMatchMode(t *testing.T) {
+	app, _ := newTestApp(t)
+	mock := mockDB(t, app)
+	mock.ExpectQuery(`phone_number LIKE \$1`).
+		WithArgs("+98912%", defaultPageSize, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "phone_number", "name", "created_at", "total_count"}).
+			AddRow(7, testPhone, "Sara", time.Now(), 1))
+
+	if w := getUsers(app, "q=%2B98912&match=prefix"); w.Code != http.StatusOK {
+		t.Fatalf("prefix search: want 200, got %d %s", w.Code, w.Body)
+	}
+	if w := getUsers(app, "q=912&match=regex"); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown match: want 400, got %d %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetSingleUser(t *testing.T) {
+	app, _ := newTestApp(t)
+	mock := mockDB(t, app)
+	get := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := withParams(httptest.NewRequest(http.MethodGet, "/users/"+id, nil), httprouter.Param{Key: "id", Value: id})
+		app.handle(app.getSingleUser)(w, r)
+		return w
+	}
+
+	expectUserByID(mock, &data.User{ID: 7, PhoneNumber: testPhone})
+	if w := get("7"); w.Code != http.StatusOK {
+		t.Fatalf("existing user: want 200, got %d %s", w.Code, w.Body)
+	}
+
+	mock.ExpectQuery(`FROM users\s+WHERE id = \$1`).WithArgs(8).WillReturnError(sql.ErrNoRows)
+	w := get("8")
+	if w.Code != http.StatusNotFound || decodeBody(t, w)["code"] != "not_found" {
+		t.Fatalf("missing user: want 404 not_found, got %d %s", w.Code, w.Body)
+	}
+
+	w = get("x")
+	if w.Code != http.StatusBadRequest || decodeBody(t, w)["code"] != "bad_request" {
+		t.Fatalf("bad id: want 400 bad_request, got %d %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOTPMetaDescribesIssuedCodes(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.otp.resendCooldown = 90 * time.Second
+
+	w := httptest.NewRecorder()
+	app.handle(app.handleOTPMeta)(w, httptest.NewRequest(http.MethodGet, "/otp/meta", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", w.Code, w.Body)
+	}
+	meta := decodeBody(t, w)["otp"].(map[string]any)
+	if meta["type"] != "numeric" || meta["resend_cooldown"] != float64(90) {
+		t.Errorf("meta = %v, want numeric codes and a 90s cooldown", meta)
+	}
+
+	code := requestTestOTP(t, app, testPhone)
+	if float64(len(code)) != meta["length"] {
+		t.Errorf("issued %q, but meta reports length %v", code, meta["length"])
+	}
+}
+
+func TestVerifyOTPChannel(t *testing.T) {
+	app, mr := newTestApp(t)
+	ctx := context.Background()
+
+	if _, err := parseChannel("carrier-pigeon"); err == nil {
+		t.Fatal("unsupported channel accepted")
+	}
+	if w := postJSON(app.handle(app.handleRequestOTP), "/request", `{"phone_number":"`+testPhone+`","channel":"fax"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("/request on an unsupported channel: want 400, got %d %s", w.Code, w.Body)
+	}
+
+	// a code sent on another channel is refused without counting an attempt
+	if err := app.storeOTPInRedis(ctx, testPhone, purposeLogin, "voice", "123456", otpTTL); err != nil {
+		t.Fatal(err)
+	}
+	w := checkTestOTP(t, app, testPhone, "123456")
+	if w == nil || w.Code != http.StatusBadRequest {
+		t.Fatalf("code from another channel: want 400, got %v", w)
+	}
+	if mr.Exists(otpAttemptsKey(testPhone)) {
+		t.Fatal("a channel mismatch counted as a failed attempt")
+	}
+
+	// codes stored before channels were recorded verify on any channel
+	mr.Del(otpCodeKey(testPhone, purposeLogin))
+	mr.HSet(otpCodeKey(testPhone, purposeLogin), "otp", "654321")
+	if w := checkTestOTP(t, app, testPhone, "654321"); w != nil {
+		t.Fatalf("code without a channel: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestVerifyOTPInviteOnly(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.autoCreateUsers = false
+	mock := mockDB(t, app)
+	byPhone := `SELECT id, created_at, phone_number, name\s+FROM users\s+WHERE phone_number = \$1`
+	mock.ExpectQuery(byPhone).WithArgs(testPhone).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(byPhone).WithArgs(testPhone).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "phone_number", "name"}).
+			AddRow(7, time.Now(), testPhone, "Sara"))
+	mock.ExpectQuery(`INSERT INTO tokens`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+
+	issueTestOTP(t, app, testPhone, "123456")
+	w := postJSON(app.handle(app.handleVerifyOTP), "/verify", `{"phone_number":"`+testPhone+`","otp":"123456"}`)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("unknown phone: want 403, got %d %s", w.Code, w.Body)
+	}
+
+	issueTestOTP(t, app, testPhone, "123456")
+	w = postJSON(app.handle(app.handleVerifyOTP), "/verify", `{"phone_number":"`+testPhone+`","otp":"123456"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("invited phone: want 200, got %d %s", w.Code, w.Body)
+	}
+	if created := decodeBody(t, w)["created"]; created != false {
+		t.Errorf("created = %v for an existing user", created)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRequestOTPRateLimitHeaders(t *testing.T) {
+	app, mr := newTestApp(t)
+	app.sms = &fakeSender{}
+	request := func() *httptest.ResponseRecorder {
+		return postJSON(app.handle(app.handleRequestOTP), "/request", `{"phone_number":"`+testPhone+`"}`)
+	}
+
+	w := request()
+	if w.Code != http.StatusOK {
+		t.Fatalf("/request: want 200, got %d %s", w.Code, w.Body)
+	}
+	h := w.Header()
+	if h.Get("RateLimit-Limit") != strconv.Itoa(app.conf.otp.rateLimitMax) || h.Get("RateLimit-Remaining") != strconv.Itoa(app.conf.otp.rateLimitMax-1) {
+		t.Fatalf("RateLimit-Limit/Remaining = %q/%q", h.Get("RateLimit-Limit"), h.Get("RateLimit-Remaining"))
+	}
+
+	// inside the resend cooldown
+	w = request()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("inside the cooldown: want 429, got %d %s", w.Code, w.Body)
+	}
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry <= 0 {
+		t.Fatalf("cooldown Retry-After = %q", w.Header().Get("Retry-After"))
+	}
+
+	// over the request limit
+	mr.Del(otpCooldownKey(testPhone))
+	for i := 1; i < app.conf.otp.rateLimitMax; i++ {
+		requestCount(t, app, testPhone)
+	}
+	w = request()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("over the limit: want 429, got %d %s", w.Code, w.Body)
+	}
+	if w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("Retry-After") != w.Header().Get("RateLimit-Reset") {
+		t.Fatalf("limited headers = %v", w.Header())
+	}
+}
+
+func TestListUsersEmptySearch(t *testing.T) {
+	app, _ := newTestApp(t)
+	mock := mockDB(t, app)
+	mock.ExpectQuery(`FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "phone_number", "name", "created_at", "total_count"}).
+			AddRow(7, testPhone, "Sara", time.Now(), 1))
+
+	// by default an empty q lists everyone
+	w := getUsers(app, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", w.Code, w.Body)
+	}
+	if total := decodeBody(t, w)[listEnvelopeKey].(map[string]any)["total"]; total != float64(1) {
+		t.Fatalf("total = %v, want every user", total)
+	}
+
+	// switched off it answers an empty page without querying
+	app.conf.emptySearchListsAll = false
+	w = getUsers(app, "page_size=5")
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", w.Code, w.Body)
+	}
+	page := decodeBody(t, w)[listEnvelopeKey].(map[string]any)
+	if items, _ := page["items"].([]any); len(items) != 0 || page["total"] != float64(0) || page["page_size"] != float64(5) {
+		t.Fatalf("page = %v, want an empty page of size 5", page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// healthGauges holds the last observed state of the downstream dependencies.
+type healthGauges struct {
+	redisUp      atomic.Bool
+	redisLatency atomic.Int64 // nanoseconds
+	dbUp         atomic.Bool
+	dbLatency    atomic.Int64 // nanoseconds
+}
+
+// degraded reports whether any dependency is down or slower than maxLatency.
+func (h *healthGauges) degraded(maxLatency time.Duration) bool {
+	if !h.redisUp.Load() || !h.dbUp.Load() {
+		return true
+	}
+	return time.Duration(h.redisLatency.Load()) > maxLatency ||
+		time.Duration(h.dbLatency.Load()) > maxLatency
+}
+
+// checkHealth pings Redis and the database once and records the results.
+func (app *application) checkHealth() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	err := app.cache.Ping(ctx).Err()
+	app.health.redisLatency.Store(int64(time.Since(start)))
+	app.health.redisUp.Store(err == nil)
+
+	start = time.Now()
+	err = app.db.PingContext(ctx)
+	app.health.dbLatency.Store(int64(time.Since(start)))
+	app.health.dbUp.Store(err == nil)
+}
+
+// monitorHealth refreshes the health gauges every interval until ctx is done.
+func (app *application) monitorHealth(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			app.checkHealth()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// isLowPriorityRoute reports whether path is one of the configured
+// sheddable routes (exact match or a sub-path of one).
+func (app *application) isLowPriorityRoute(path string) bool {
+	for _, route := range app.conf.shedding.lowPriority {
+		if path == route || strings.HasPrefix(path, route+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -2,15 +2,21 @@ package main
 
 import (
 	"Go-OTP-Login/internal/data"
+	"bytes"
 	"context"
 	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -19,21 +25,73 @@ import (
 
 type envelope map[string]interface{}
 
-// send JSON error response
-func (app *application) errorResponse(w http.ResponseWriter, status int, message interface{}) {
+// send error response, as plain text if the client prefers it and JSON otherwise
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
+	if prefersPlainText(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprintln(w, message)
+		return
+	}
+
 	env := envelope{"error": message}
 	if err := app.writeJSON(w, status, env, nil); err != nil {
-		app.logger.Println(err)
+		app.logger.ErrorContext(r.Context(), "Error writing error response", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+// report whether an Accept header ranks text/plain above application/json
+func prefersPlainText(accept string) bool {
+	if accept == "" {
+		return false
+	}
+
+	jsonQ, plainQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && k == "q" {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+
+		switch mediaType {
+		case "application/json", "application/*", "*/*":
+			jsonQ = max(jsonQ, q)
+		}
+		switch mediaType {
+		case "text/plain", "text/*":
+			plainQ = max(plainQ, q)
+		}
+	}
+	return plainQ > 0 && plainQ > jsonQ
+}
+
 // write JSON with optional headers
 func (app *application) writeJSON(w http.ResponseWriter, status int, body envelope, headers http.Header) error {
 	js, err := json.Marshal(body)
 	if err != nil {
 		return err
 	}
+	if app.conf.jsonNaming == "camel" {
+		if js, err = camelCaseJSON(js); err != nil {
+			return err
+		}
+	}
+	if app.conf.env == "development" {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, js, "", "\t"); err != nil {
+			return err
+		}
+		js = buf.Bytes()
+	}
 
 	for k, v := range headers {
 		w.Header()[k] = v
@@ -45,22 +103,73 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, body envelo
 	return nil
 }
 
+// re-encode a JSON document with every object key converted to camelCase
+func camelCaseJSON(js []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(camelCaseKeys(v))
+}
+
+func camelCaseKeys(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			out[snakeToCamel(k)] = camelCaseKeys(val)
+		}
+		return out
+	case []interface{}:
+		for i := range t {
+			t[i] = camelCaseKeys(t[i])
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+// phone_number -> phoneNumber
+func snakeToCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// maxRequestBodyBytes caps JSON request bodies; see limitRequestBody for
+// the Content-Length fast path.
+const maxRequestBodyBytes = 104856
+
 // parse and validate a single JSON object
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
-	const maxBytes = 104856
-	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	// authoritative limit, also for chunked bodies without a Content-Length
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
 
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
+	// numbers decoded into interface{} become json.Number instead of
+	// float64, which can't hold IDs above 2^53 exactly
+	dec.UseNumber()
 
 	if err := dec.Decode(dst); err != nil {
 		var (
-			syntaxErr  *json.SyntaxError
-			typeErr    *json.UnmarshalTypeError
-			invalidErr *json.InvalidUnmarshalError
+			syntaxErr   *json.SyntaxError
+			typeErr     *json.UnmarshalTypeError
+			invalidErr  *json.InvalidUnmarshalError
+			maxBytesErr *http.MaxBytesError
 		)
 
 		switch {
+		case errors.As(err, &maxBytesErr):
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesErr.Limit)
 		case errors.As(err, &syntaxErr):
 			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxErr.Offset)
 		case errors.Is(err, io.ErrUnexpectedEOF):
@@ -87,69 +196,420 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
-// generate 4-digit OTP
-func generateOTP() string {
-	otp := make([]byte, 2)
-	if _, err := rand.Read(otp); err != nil {
-		log.Fatal("Error generating OTP:", err)
+// OTP delivery channels; a code can only be verified on the channel it was
+// requested on
+const channelSMS = "sms"
+
+var otpChannels = []string{channelSMS}
+
+// parseChannel validates a requested channel, defaulting to SMS.
+func parseChannel(channel string) (string, error) {
+	if channel == "" {
+		return channelSMS, nil
+	}
+	if !slices.Contains(otpChannels, channel) {
+		return "", fmt.Errorf("unsupported channel %q", channel)
+	}
+	return channel, nil
+}
+
+const (
+	// defaultOTPLength is used unless conf.otpLength picks another of
+	// otpLengths.
+	defaultOTPLength = 6
+	otpTTL           = 2 * time.Minute
+	// otpType tells clients which keyboard to show; codes are digits only.
+	otpType = "numeric"
+)
+
+// otpLengths are the code lengths operators may configure.
+var otpLengths = []int{4, 6, 8}
+
+// generateOTP returns a uniformly random code of length decimal digits read
+// from src (crypto/rand.Reader outside tests). Draws at or above the largest
+// multiple of 10^length that fits in a uint64 are rejected rather than
+// reduced, so every code is equally likely.
+func generateOTP(src io.Reader, length int) (string, error) {
+	if length < 1 || length > 18 {
+		return "", fmt.Errorf("unsupported OTP length %d", length)
+	}
+	bound := uint64(1)
+	for i := 0; i < length; i++ {
+		bound *= 10
+	}
+	limit := math.MaxUint64 - math.MaxUint64%bound
+
+	buf := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(src, buf); err != nil {
+			return "", fmt.Errorf("failed to read random bytes: %w", err)
+		}
+		if v := binary.BigEndian.Uint64(buf); v < limit {
+			return fmt.Sprintf("%0*d", length, v%bound), nil
+		}
+	}
+}
+
+// wellFormedOTP reports whether otp could be a code generateOTP issued:
+// exactly length ASCII digits.
+func wellFormedOTP(otp string, length int) bool {
+	if len(otp) != length {
+		return false
+	}
+	for i := 0; i < len(otp); i++ {
+		if otp[i] < '0' || otp[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// otpRandom is the randomness source for codes; app.random lets tests
+// substitute a deterministic reader.
+func (app *application) otpRandom() io.Reader {
+	if app.random != nil {
+		return app.random
+	}
+	return rand.Reader
+}
+
+// how long issued codes are remembered for reuse prevention
+const recentOTPsTTL = 24 * time.Hour
+
+func recentOTPsKey(phone string) string {
+	return otpKeyPrefix(phone) + ":recent"
+}
+
+// generateFreshOTP returns a code of length digits that is not among the
+// last conf.otp.reuseWindow codes issued for the phone, and records it.
+func (app *application) generateFreshOTP(ctx context.Context, phoneNumber string, length int) (string, error) {
+	n := app.conf.otp.reuseWindow
+	if n <= 0 {
+		return generateOTP(app.otpRandom(), length)
+	}
+
+	key := recentOTPsKey(phoneNumber)
+	recent, err := app.cache.LRange(ctx, key, 0, int64(n-1)).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to read recent OTPs: %w", err)
+	}
+	used := make(map[string]bool, len(recent))
+	for _, code := range recent {
+		used[code] = true
+	}
+
+	const maxTries = 20
+	for i := 0; i < maxTries; i++ {
+		otp, err := generateOTP(app.otpRandom(), length)
+		if err != nil {
+			return "", err
+		}
+		if used[otp] {
+			continue
+		}
+
+		_, err = app.cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LPush(ctx, key, otp)
+			pipe.LTrim(ctx, key, 0, int64(n-1))
+			pipe.Expire(ctx, key, recentOTPsTTL)
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to record OTP: %w", err)
+		}
+		return otp, nil
 	}
-	return fmt.Sprintf("%04d", int(otp[0])%10000)
+	return "", errors.New("could not generate an unused OTP")
 }
 
-// store OTP with TTL in Redis
-func (app *application) storeOTPInRedis(ctx context.Context, phoneNumber, otp string) error {
-	userData := map[string]string{"otp": otp}
-	if err := app.cache.HSet(ctx, phoneNumber, userData).Err(); err != nil {
+// store OTP with TTL in Redis, along with the channel it was sent on. With
+// conf.otp.previousCodeGrace set, the code being replaced is kept as "prev"
+// and stays valid until "prev_until".
+func (app *application) storeOTPInRedis(ctx context.Context, phoneNumber, purpose, channel, otp string, ttl time.Duration) error {
+	userData := map[string]string{"otp": otp, "channel": channel}
+	key := otpCodeKey(phoneNumber, purpose)
+
+	if grace := app.conf.otp.previousCodeGrace; grace > 0 {
+		prev, err := app.cache.HGet(ctx, key, "otp").Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return fmt.Errorf("failed to read previous OTP: %w", err)
+		}
+		if prev != "" {
+			userData["prev"] = prev
+			userData["prev_until"] = strconv.FormatInt(time.Now().Add(grace).UnixMilli(), 10)
+		} else {
+			// don't carry a stale prev over from an earlier rotation
+			userData["prev"] = ""
+		}
+	}
+	if err := app.cache.HSet(ctx, key, userData).Err(); err != nil {
 		return fmt.Errorf("failed to store user data in Redis: %w", err)
 	}
-	if err := app.cache.Expire(ctx, phoneNumber, 2*time.Minute).Err(); err != nil {
+	if err := app.cache.Expire(ctx, key, ttl).Err(); err != nil {
 		return fmt.Errorf("failed to set expiration for Redis key: %w", err)
 	}
 	return nil
 }
 
-// verify OTP from Redis
-func (app *application) verifyOTPInRedis(ctx context.Context, phoneNumber, otp string) error {
-	data, err := app.cache.HGetAll(ctx, phoneNumber).Result()
+var (
+	errOTPExpired  = errors.New("no pending OTP")
+	errOTPMismatch = errors.New("invalid OTP")
+	// the code was requested on another delivery channel
+	errOTPWrongChannel = errors.New("OTP was requested on a different channel")
+)
+
+// compare-and-delete of the pending code in one step, so two concurrent
+// verifies of the same code can't both succeed. The previous code matches
+// only when ARGV[3] is "1" and its prev_until (ms) is after ARGV[2]. A code
+// stored for another channel than ARGV[4] is left alone.
+// Returns 1 (matched and consumed), 0 (no pending code), -1 (mismatch) or
+// -2 (wrong channel).
+var otpConsumeScript = redis.NewScript(`
+local key     = KEYS[1]
+local code    = ARGV[1]
+local now     = tonumber(ARGV[2])
+local grace   = ARGV[3] == "1"
+local channel = ARGV[4]
+
+local h = redis.call("HMGET", key, "otp", "prev", "prev_until", "channel")
+if not h[1] then
+  return 0
+end
+-- codes stored before channels were recorded have none
+if h[4] and h[4] ~= "" and h[4] ~= channel then
+  return -2
+end
+
+local ok = h[1] == code
+if not ok and grace and h[2] and h[2] ~= "" and h[2] == code then
+  ok = (tonumber(h[3]) or 0) > now
+end
+if not ok then
+  return -1
+end
+
+redis.call("DEL", key)
+return 1
+`)
+
+// otpVerifyLockTTL bounds how long a crashed verify can hold the lock.
+const otpVerifyLockTTL = 5 * time.Second
+
+// deletes the lock only if it still holds our token, so a verify that
+// outlived the TTL can't release a lock another request now holds
+var otpUnlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// lockOTPVerify takes the phone's verify lock. ok is false if another
+// verify holds it; otherwise unlock must be called when done.
+func (app *application) lockOTPVerify(ctx context.Context, phone string) (unlock func(), ok bool, err error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, false, err
+	}
+	token := hex.EncodeToString(b)
+	key := otpVerifyLockKey(phone)
+
+	ok, err = app.cache.SetNX(ctx, key, token, otpVerifyLockTTL).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	unlock = func() {
+		// the request context may already be done
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+		defer cancel()
+		if err := otpUnlockScript.Run(ctx, app.cache, []string{key}, token).Err(); err != nil {
+			app.logger.ErrorContext(ctx, "Error releasing verify lock", "error", err)
+		}
+	}
+	return unlock, true, nil
+}
+
+// consumeOTPInRedis checks otp against the pending code and, on a match,
+// deletes it in the same atomic step so it can be used only once. The code
+// must have been requested on channel.
+func (app *application) consumeOTPInRedis(ctx context.Context, phoneNumber, purpose, channel, otp string) error {
+	grace := "0"
+	if app.conf.otp.previousCodeGrace > 0 {
+		grace = "1"
+	}
+
+	res, err := otpConsumeScript.Run(ctx, app.cache, []string{otpCodeKey(phoneNumber, purpose)},
+		otp, time.Now().UnixMilli(), grace, channel).Int64()
 	if err != nil {
-		return fmt.Errorf("invalid or expired OTP")
+		return fmt.Errorf("invalid or expired OTP: %w", err)
+	}
+	switch res {
+	case 1:
+		return nil
+	case 0:
+		return errOTPExpired
+	case -2:
+		return errOTPWrongChannel
+	default:
+		return errOTPMismatch
 	}
-	if data["otp"] != otp {
-		return fmt.Errorf("invalid OTP")
+}
+
+// delete the pending OTPs of every purpose, the resend cooldown and the challenge window. The request rate-limit
+// counter is intentionally kept so cancelling can't be used to bypass it.
+func (app *application) cancelOTPInRedis(ctx context.Context, phoneNumber string) error {
+	keys := []string{otpCooldownKey(phoneNumber), otpChallengeKey(phoneNumber)}
+	for purpose := range app.conf.otpPurposes {
+		keys = append(keys, otpCodeKey(phoneNumber, purpose))
+	}
+	if err := app.cache.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to delete OTP from Redis: %w", err)
 	}
 	return nil
 }
 
-// create user if not exists
-func (app *application) createUserIfNotExists(phoneNumber string) (*data.User, error) {
-	user, err := app.models.User.GetByPhoneNumber(phoneNumber)
-	if err == nil && user != nil {
-		return user, nil
+// otpChallengeTTL opens the phone's verification challenge on its first
+// request and returns how long a code issued now may live: codeTTL, cut
+// short so no code outlives conf.otp.maxChallengeLifetime counted from the
+// first request. Once that window has passed a new challenge starts.
+func (app *application) otpChallengeTTL(ctx context.Context, phone string, codeTTL time.Duration) (time.Duration, error) {
+	lifetime := app.conf.otp.maxChallengeLifetime
+	if lifetime <= 0 {
+		return codeTTL, nil
+	}
+
+	key := otpChallengeKey(phone)
+	// the value records when the challenge started, for debugging
+	started, err := app.cache.SetNX(ctx, key, time.Now().Unix(), lifetime).Result()
+	if err != nil {
+		return 0, err
+	}
+	if started {
+		return min(codeTTL, lifetime), nil
+	}
+
+	remaining, err := app.cache.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if remaining <= 0 {
+		// expired between the two calls; start over
+		if err := app.cache.Set(ctx, key, time.Now().Unix(), lifetime).Err(); err != nil {
+			return 0, err
+		}
+		return min(codeTTL, lifetime), nil
+	}
+	return min(codeTTL, remaining), nil
+}
+
+// endOTPChallenge closes the challenge so the next request opens a new one.
+func (app *application) endOTPChallenge(ctx context.Context, phone string) error {
+	return app.cache.Del(ctx, otpChallengeKey(phone)).Err()
+}
+
+// errNotRegistered is returned for unknown phones when conf.autoCreateUsers
+// is off (invite-only).
+var errNotRegistered = errors.New("phone number is not registered")
+
+// create user if not exists, reporting whether it was created by this call.
+// With auto-create off only existing users are returned.
+func (app *application) createUserIfNotExists(ctx context.Context, phoneNumber string) (*data.User, bool, error) {
+	if !app.conf.autoCreateUsers {
+		user, err := app.models.User.GetByPhoneNumber(phoneNumber)
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, false, errNotRegistered
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to look up user: %w", err)
+		}
+		return user, false, nil
+	}
+
+	user, created, err := app.models.User.Upsert(ctx, phoneNumber, "")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create a user: %w", err)
+	}
+	if created {
+		app.logger.InfoContext(ctx, "audit: registered user", "user_id", user.ID, "phone", maskPhone(phoneNumber))
+	}
+	return user, created, nil
+}
+
+// report whether the user id is configured as an admin
+func (app *application) isAdmin(userID int64) bool {
+	for _, id := range app.conf.adminIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// report whether the phone is allowed as an admin test SMS destination
+func (app *application) isSMSTestNumber(phone string) bool {
+	for _, n := range app.conf.sms.testNumbers {
+		if n == phone {
+			return true
+		}
+	}
+	return false
+}
+
+// registered claim names that custom claims must never overwrite
+var reservedJWTClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true,
+	"nbf": true, "iat": true, "jti": true,
+}
+
+// isKnownClient reports whether clientID is one of conf.clientIDs.
+func (app *application) isKnownClient(clientID string) bool {
+	return slices.Contains(app.conf.clientIDs, clientID)
+}
+
+// custom claims embedded in the JWT so downstream services can skip a lookup
+func (app *application) userClaims(user *data.User) map[string]interface{} {
+	roles := []string{"user"}
+	if app.isAdmin(user.ID) {
+		roles = append(roles, "admin")
 	}
-	newUser := data.User{PhoneNumber: phoneNumber}
-	if err := app.models.User.Insert(&newUser); err != nil {
-		return nil, fmt.Errorf("failed to create a user: %s", err)
+	return map[string]interface{}{
+		"phone_number": user.PhoneNumber,
+		"roles":        roles,
 	}
-	return &newUser, nil
 }
 
-// create JWT (HS256)
-func (app *application) generateJWT(userID int64, ttl time.Duration) (string, error) {
+// create JWT (HS256) with optional custom claims merged in, valid from now
+func (app *application) generateJWT(userID int64, audience string, ttl time.Duration, custom map[string]interface{}) (string, error) {
+	return app.generateJWTNotBefore(userID, audience, time.Time{}, ttl, custom)
+}
+
+// generateJWTNotBefore is generateJWT for a token that only becomes valid
+// at notBefore (the zero time means now); ttl counts from then.
+func (app *application) generateJWTNotBefore(userID int64, audience string, notBefore time.Time, ttl time.Duration, custom map[string]interface{}) (string, error) {
 	now := time.Now()
-	claims := jwt.RegisteredClaims{
-		Subject:   strconv.FormatInt(userID, 10),
-		IssuedAt:  jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	if notBefore.IsZero() {
+		notBefore = now
+	}
+	claims := jwt.MapClaims{}
+	for k, v := range custom {
+		if reservedJWTClaims[k] {
+			return "", fmt.Errorf("custom claim %q is reserved", k)
+		}
+		claims[k] = v
 	}
+	claims["sub"] = strconv.FormatInt(userID, 10)
+	claims["iat"] = jwt.NewNumericDate(now)
+	claims["nbf"] = jwt.NewNumericDate(notBefore)
+	claims["exp"] = jwt.NewNumericDate(notBefore.Add(ttl))
+	if audience != "" {
+		claims["aud"] = audience
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(app.jwtSecret)
+	return token.SignedString(app.jwtKeys.signingKey())
 }
 
-const (
-	otpRateLimitMax    = 3
-	otpRateLimitWindow = 10 * time.Minute
-)
-
 var otpRateLimitScript = redis.NewScript(`
 local key   = KEYS[1]
 local win   = tonumber(ARGV[1]) -- window seconds
@@ -165,25 +625,421 @@ else
 end
 `)
 
-// allowOTPRequest increments the counter and tells if it's allowed.
-// It returns: allowed, count, remaining, resetAt.
-func (app *application) allowOTPRequest(ctx context.Context, phone string) (bool, error) {
-	key := "rl:otp:" + phone
-	winSec := int64(otpRateLimitWindow / time.Second)
+// sliding-window log: one sorted-set member per accepted request, scored by
+// its time in ms. Rejected requests are not logged, so they don't extend
+// the block. Returns {count, allowed, reset_ms}.
+var otpSlidingRateLimitScript = redis.NewScript(`
+local key    = KEYS[1]
+local now    = tonumber(ARGV[1]) -- ms
+local win    = tonumber(ARGV[2]) -- ms
+local limit  = tonumber(ARGV[3])
+local member = ARGV[4]
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now - win)
+local count = redis.call("ZCARD", key)
+local allowed = 0
+if count < limit then
+  redis.call("ZADD", key, now, member)
+  redis.call("PEXPIRE", key, win)
+  allowed = 1
+end
+
+local oldest = redis.call("ZRANGE", key, 0, 0, "WITHSCORES")
+local reset = 0
+if oldest[2] then
+  reset = tonumber(oldest[2]) + win - now
+end
+return {count + 1, allowed, reset}
+`)
+
+// rateLimitResult describes the state of a phone's OTP request limit.
+type rateLimitResult struct {
+	allowed bool
+	count   int64
+	limit   int64
+	// resetIn is how long until the limit frees up again.
+	resetIn time.Duration
+}
+
+// setHeaders writes the IETF draft RateLimit-* headers so clients can
+// throttle themselves; reset is in seconds.
+func (l *rateLimitResult) setHeaders(w http.ResponseWriter) {
+	w.Header().Set("RateLimit-Limit", strconv.FormatInt(l.limit, 10))
+	w.Header().Set("RateLimit-Remaining", strconv.FormatInt(max(l.limit-l.count, 0), 10))
+	w.Header().Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(l.resetIn), 10))
+}
+
+// allowOTPRequest increments the counter and tells if it's allowed, using the
+// configured fixed or sliding window algorithm.
+func (app *application) allowOTPRequest(ctx context.Context, phone string) (*rateLimitResult, error) {
+	var (
+		res *rateLimitResult
+		err error
+	)
+	if app.conf.otp.rateLimitAlgorithm == "sliding" {
+		res, err = app.allowOTPRequestSliding(ctx, phone)
+	} else {
+		res, err = app.allowOTPRequestFixed(ctx, phone)
+	}
+	if err == nil && !res.allowed {
+		otpRateLimited.Inc()
+	}
+	return res, err
+}
+
+// allowOTPRequestFixed counts the request in a fixed window that starts
+// with the phone's first request.
+func (app *application) allowOTPRequestFixed(ctx context.Context, phone string) (*rateLimitResult, error) {
+	key := otpRateLimitKey(phone)
+	winSec := int64(app.conf.otp.rateLimitWindow / time.Second)
 
 	res, err := otpRateLimitScript.Run(ctx, app.cache, []string{key}, winSec).Result()
 	if err != nil {
-		return false, err
+		return nil, rateLimitError(err)
 	}
 
 	arr, ok := res.([]interface{})
 	if !ok || len(arr) != 2 {
-		return false, fmt.Errorf("unexpected rate-limit result")
+		return nil, fmt.Errorf("unexpected rate-limit result %T", res)
+	}
+
+	count, err := scriptInt(arr[0])
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := scriptInt(arr[1])
+	if err != nil {
+		return nil, err
+	}
+
+	limit := int64(app.conf.otp.rateLimitMax)
+	return &rateLimitResult{
+		allowed: count <= limit,
+		count:   count,
+		limit:   limit,
+		resetIn: time.Duration(ttl) * time.Second,
+	}, nil
+}
+
+// errRateLimitTimeout is returned when the rate-limit check didn't finish
+// before the request context was cancelled or timed out.
+var errRateLimitTimeout = errors.New("rate limit check timed out")
+
+func rateLimitError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return fmt.Errorf("%w: %w", errRateLimitTimeout, err)
+	}
+	return err
+}
+
+// scriptInt converts a Lua script reply element to an int64. Integers come
+// back as int64, but depending on the client version and script they can
+// also arrive as other integer types or as strings.
+func scriptInt(v interface{}) (int64, error) {
+	switch t := v.(type) {
+	case int64:
+		return t, nil
+	case int:
+		return int64(t), nil
+	case uint64:
+		return int64(t), nil
+	case float64:
+		return int64(t), nil
+	case string:
+		return strconv.ParseInt(t, 10, 64)
+	case []byte:
+		return strconv.ParseInt(string(t), 10, 64)
+	default:
+		return 0, fmt.Errorf("unexpected rate-limit value %T", v)
+	}
+}
+
+// allowOTPRequestSliding counts requests over the trailing window, so unlike
+// the fixed window it doesn't allow 2x bursts across a window boundary.
+func (app *application) allowOTPRequestSliding(ctx context.Context, phone string) (*rateLimitResult, error) {
+	now := time.Now()
+	args := []interface{}{
+		now.UnixMilli(),
+		app.conf.otp.rateLimitWindow.Milliseconds(),
+		app.conf.otp.rateLimitMax,
+		strconv.FormatInt(now.UnixNano(), 10),
+	}
+
+	res, err := otpSlidingRateLimitScript.Run(ctx, app.cache, []string{otpSlidingRateLimitKey(phone)}, args...).Int64Slice()
+	if err != nil {
+		return nil, rateLimitError(err)
+	}
+	if len(res) != 3 {
+		return nil, fmt.Errorf("unexpected rate-limit result")
+	}
+
+	return &rateLimitResult{
+		allowed: res[1] == 1,
+		count:   res[0],
+		limit:   int64(app.conf.otp.rateLimitMax),
+		resetIn: time.Duration(res[2]) * time.Millisecond,
+	}, nil
+}
+
+// peekOTPRateLimit reports the phone's current request-limit state for the
+// configured algorithm without counting a request.
+func (app *application) peekOTPRateLimit(ctx context.Context, phone string) (*rateLimitResult, error) {
+	res := &rateLimitResult{limit: int64(app.conf.otp.rateLimitMax)}
+
+	if app.conf.otp.rateLimitAlgorithm == "sliding" {
+		key := otpSlidingRateLimitKey(phone)
+		now := time.Now()
+		from := strconv.FormatInt(now.Add(-app.conf.otp.rateLimitWindow).UnixMilli(), 10)
+
+		count, err := app.cache.ZCount(ctx, key, "("+from, "+inf").Result()
+		if err != nil {
+			return nil, err
+		}
+		oldest, err := app.cache.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+			Min: "(" + from, Max: "+inf", Count: 1,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		res.count = count
+		if len(oldest) > 0 {
+			res.resetIn = time.UnixMilli(int64(oldest[0].Score)).Add(app.conf.otp.rateLimitWindow).Sub(now)
+		}
+	} else {
+		key := otpRateLimitKey(phone)
+		count, err := app.cache.Get(ctx, key).Int64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+		ttl, err := app.cache.PTTL(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		res.count = count
+		if ttl > 0 {
+			res.resetIn = ttl
+		}
+	}
+
+	res.allowed = res.count < res.limit
+	return res, nil
+}
+
+// clearOTPRateLimits deletes the given rate-limit keys, e.g.
+// otpRequestLimitKeys to start the phone on a fresh request window, and
+// returns the keys that actually existed.
+func (app *application) clearOTPRateLimits(ctx context.Context, keys []string) ([]string, error) {
+	cleared := []string{}
+	for _, key := range keys {
+		n, err := app.cache.Del(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			cleared = append(cleared, key)
+		}
+	}
+	return cleared, nil
+}
+
+// key patterns of a pending code and its challenge window, for every phone
+var otpChallengeKeyPatterns = []string{"{otp:*}:code", "{otp:*}:code:*", "{otp:*}:challenge"}
+
+// flushOTPChallenges deletes every pending OTP code and challenge window
+// and returns how many keys were removed. It walks the keyspace with SCAN
+// (on each master in cluster mode) so Redis is never blocked the way KEYS
+// would.
+func (app *application) flushOTPChallenges(ctx context.Context) (int64, error) {
+	var deleted atomic.Int64
+
+	flush := func(ctx context.Context, c redis.Cmdable) error {
+		for _, pattern := range otpChallengeKeyPatterns {
+			iter := c.Scan(ctx, 0, pattern, 500).Iterator()
+			var batch []string
+			for iter.Next(ctx) {
+				batch = append(batch, iter.Val())
+				if len(batch) == 500 {
+					if err := deleteKeys(ctx, c, batch, &deleted); err != nil {
+						return err
+					}
+					batch = batch[:0]
+				}
+			}
+			if err := iter.Err(); err != nil {
+				return err
+			}
+			if err := deleteKeys(ctx, c, batch, &deleted); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if cluster, ok := app.cache.(*redis.ClusterClient); ok {
+		err := cluster.ForEachMaster(ctx, func(ctx context.Context, c *redis.Client) error {
+			return flush(ctx, c)
+		})
+		return deleted.Load(), err
+	}
+	err := flush(ctx, app.cache)
+	return deleted.Load(), err
+}
+
+// deleteKeys removes keys with one DEL each, pipelined, since keys of
+// different phones may live in different cluster slots.
+func deleteKeys(ctx context.Context, c redis.Cmdable, keys []string, deleted *atomic.Int64) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	cmds, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, k := range keys {
+			pipe.Del(ctx, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		deleted.Add(cmd.(*redis.IntCmd).Val())
+	}
+	return nil
+}
+
+// otpKeyPrefix is the hash tag shared by every key of one phone, so on
+// Redis Cluster they all hash to the same slot and can be used together in
+// multi-key commands and scripts.
+func otpKeyPrefix(phone string) string {
+	return "{otp:" + phone + "}"
+}
+
+// login codes keep the unqualified key so codes issued before purposes
+// existed still verify
+func otpCodeKey(phone, purpose string) string {
+	if purpose == purposeLogin {
+		return otpKeyPrefix(phone) + ":code"
+	}
+	return otpKeyPrefix(phone) + ":code:" + purpose
+}
+
+func otpChallengeKey(phone string) string {
+	return otpKeyPrefix(phone) + ":challenge"
+}
+
+// the request counters are keyed by the normalized number so formatting
+// variants of one phone share a bucket; both still share one hash tag
+func otpRateLimitKey(phone string) string {
+	return otpKeyPrefix(phone) + ":rl"
+}
+
+func otpSlidingRateLimitKey(phone string) string {
+	return otpKeyPrefix(phone) + ":rl:sliding"
+}
+
+func otpCooldownKey(phone string) string {
+	return otpKeyPrefix(phone) + ":cooldown"
+}
+
+func otpAttemptsKey(phone string) string {
+	return otpKeyPrefix(phone) + ":attempts"
+}
+
+func otpVerifyLockKey(phone string) string {
+	return otpKeyPrefix(phone) + ":verify_lock"
+}
+
+// the request counters of a phone, under either rate-limit algorithm
+func otpRequestLimitKeys(phone string) []string {
+	return []string{otpRateLimitKey(phone), otpSlidingRateLimitKey(phone)}
+}
+
+// all keys that can block a phone from requesting or verifying OTPs
+func otpRateLimitKeys(phone string) []string {
+	return append(otpRequestLimitKeys(phone),
+		otpCooldownKey(phone),
+		otpAttemptsKey(phone),
+		otpResendsKey(phone),
+	)
+}
+
+var otpAttemptsScript = redis.NewScript(`
+local key = KEYS[1]
+local ttl = tonumber(ARGV[1]) -- seconds
+
+local count = redis.call("INCR", key)
+if count == 1 then
+  redis.call("EXPIRE", key, ttl)
+end
+return count
+`)
+
+// otpAttempts returns the number of failed verifications for the phone.
+func (app *application) otpAttempts(ctx context.Context, phone string) (int64, error) {
+	n, err := app.cache.Get(ctx, otpAttemptsKey(phone)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// recordFailedOTPAttempt increments the failed-verification counter. The
+// counter's TTL starts at the first failure and is not extended afterwards.
+func (app *application) recordFailedOTPAttempt(ctx context.Context, phone string) (int64, error) {
+	ttlSec := int64(app.conf.otp.attemptsTTL / time.Second)
+	return otpAttemptsScript.Run(ctx, app.cache, []string{otpAttemptsKey(phone)}, ttlSec).Int64()
+}
+
+// clearOTPAttempts resets the failed-verification counter.
+func (app *application) clearOTPAttempts(ctx context.Context, phone string) error {
+	return app.cache.Del(ctx, otpAttemptsKey(phone)).Err()
+}
+
+// how long a user's last OTP verification time is remembered
+const lastVerifiedTTL = 24 * time.Hour
+
+func lastVerifiedKey(userID int64) string {
+	return "lv:user:" + strconv.FormatInt(userID, 10)
+}
+
+// markVerified records that the user just proved possession of their phone.
+func (app *application) markVerified(ctx context.Context, userID int64) error {
+	return app.cache.Set(ctx, lastVerifiedKey(userID), time.Now().Unix(), lastVerifiedTTL).Err()
+}
+
+// lastVerifiedAt returns when the user last verified an OTP, or the zero time.
+func (app *application) lastVerifiedAt(ctx context.Context, userID int64) (time.Time, error) {
+	unix, err := app.cache.Get(ctx, lastVerifiedKey(userID)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
 	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(unix, 0), nil
+}
 
-	count := arr[0].(int64)
+// startOTPCooldown blocks new OTP requests for the phone for conf.otp.resendCooldown.
+func (app *application) startOTPCooldown(ctx context.Context, phone string) error {
+	return app.cache.Set(ctx, otpCooldownKey(phone), 1, app.conf.otp.resendCooldown).Err()
+}
 
-	allowed := count <= otpRateLimitMax
+// otpCooldownRemaining returns how long until the phone may request a new OTP.
+func (app *application) otpCooldownRemaining(ctx context.Context, phone string) (time.Duration, error) {
+	ttl, err := app.cache.PTTL(ctx, otpCooldownKey(phone)).Result()
+	if err != nil {
+		return 0, err
+	}
+	// -2: no key, -1: no expiry
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
 
-	return allowed, nil
+// round a duration up to whole seconds for client-facing countdowns
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64((d + time.Second - 1) / time.Second)
 }
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+)
+
+func TestConsumeOTPConcurrentVerifiesSucceedOnce(t *testing.T) {
+	app, _ := newTestApp(t)
+	issueTestOTP(t, app, testPhone, "123456")
+
+	const n = 20
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		successes int
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := app.consumeOTPInRedis(context.Background(), testPhone, purposeLogin, channelSMS, "123456")
+			if err == nil {
+				mu.Lock()
+				successes++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if successes != 1 {
+		t.Fatalf("%d of %d concurrent verifies succeeded, want exactly 1", successes, n)
+	}
+}
+
+func TestWriteJSONNaming(t *testing.T) {
+	tests := map[string][]string{
+		"snake": {"id", "created_at", "phone_number", "name"},
+		"camel": {"id", "createdAt", "phoneNumber", "name"},
+	}
+	for naming, want := range tests {
+		t.Run(naming, func(t *testing.T) {
+			app, _ := newTestApp(t)
+			app.conf.jsonNaming = naming
+			user := &data.User{ID: 1, PhoneNumber: testPhone, Name: "Sara", CreatedAt: time.Now()}
+
+			w := httptest.NewRecorder()
+			if err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := decodeBody(t, w)["user"].(map[string]any)
+			var keys []string
+			for k := range got {
+				keys = append(keys, k)
+			}
+			slices.Sort(keys)
+			slices.Sort(want)
+			if !slices.Equal(keys, want) {
+				t.Fatalf("user keys = %q, want %q", keys, want)
+			}
+		})
+	}
+}
+
+func TestWriteJSONPrettyPrintsInDevelopment(t *testing.T) {
+	for env, indented := range map[string]bool{"development": true, "production": false} {
+		app, _ := newTestApp(t)
+		app.conf.env = env
+
+		w := httptest.NewRecorder()
+		if err := app.writeJSON(w, http.StatusOK, envelope{"success": true}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(w.Body.String(), "\n\t\"success\""); got != indented {
+			t.Errorf("%s: indented = %v, want %v in %q", env, got, indented, w.Body)
+		}
+	}
+}
+
+func TestPrefersPlainText(t *testing.T) {
+	tests := map[string]bool{
+		"":                                   false,
+		"application/json":                   false,
+		"text/plain":                         true,
+		"*/*":                                false,
+		"text/plain, application/json":       false,
+		"text/plain, application/json;q=0.5": true,
+		"text/*;q=0.9, */*;q=0.1":            true,
+		"TEXT/PLAIN;q=0.2, application/*":    false,
+	}
+	for accept, want := range tests {
+		if got := prefersPlainText(accept); got != want {
+			t.Errorf("prefersPlainText(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestErrorResponseNegotiatesPlainText(t *testing.T) {
+	app, _ := newTestApp(t)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "text/plain")
+
+	w := httptest.NewRecorder()
+	app.errorResponse(w, r, http.StatusNotFound, "not found")
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Content-Type = %q, want text/plain", ct)
+	}
+	if w.Code != http.StatusNotFound || w.Body.String() != "not found\n" {
+		t.Fatalf("got %d %q", w.Code, w.Body)
+	}
+}
+
+func TestClearOTPRateLimitsReportsExistingKeys(t *testing.T) {
+	app, mr := newTestApp(t)
+	ctx := context.Background()
+	if _, err := app.allowOTPRequest(ctx, testPhone); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.recordFailedOTPAttempt(ctx, testPhone); err != nil {
+		t.Fatal(err)
+	}
+
+	cleared, err := app.clearOTPRateLimits(ctx, otpRequestLimitKeys(testPhone))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{otpRateLimitKey(testPhone)}; !slices.Equal(cleared, want) {
+		t.Fatalf("cleared = %q, want %q", cleared, want)
+	}
+	if !mr.Exists(otpAttemptsKey(testPhone)) {
+		t.Fatal("clearing the request counters dropped the verify attempts")
+	}
+}
+
+// otpDraws is a random source yielding each value as one generateOTP draw.
+func otpDraws(values ...uint64) io.Reader {
+	buf := make([]byte, 0, 8*len(values))
+	for _, v := range values {
+		buf = binary.BigEndian.AppendUint64(buf, v)
+	}
+	return bytes.NewReader(buf)
+}
+
+func TestGenerateFreshOTPSkipsRecentCodes(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.otp.reuseWindow = 2
+	app.random = otpDraws(1111, 2222, 1111, 2222, 3333)
+	ctx := context.Background()
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		otp, err := app.generateFreshOTP(ctx, testPhone, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, otp)
+	}
+	if want := []string{"1111", "2222", "3333"}; !slices.Equal(got, want) {
+		t.Fatalf("codes = %q, want %q", got, want)
+	}
+}
+
+func TestGenerateFreshOTPWindowDisabled(t *testing.T) {
+	app, mr := newTestApp(t)
+	app.random = otpDraws(1111, 1111)
+
+	for i := 0; i < 2; i++ {
+		otp, err := app.generateFreshOTP(context.Background(), testPhone, 4)
+		if err != nil || otp != "1111" {
+			t.Fatalf("code %d = %q, %v; want a repeat with the window off", i, otp, err)
+		}
+	}
+	if mr.Exists(recentOTPsKey(testPhone)) {
+		t.Fatal("recent codes recorded with the window off")
+	}
+}
+
+func TestSlidingRateLimitCountsTrailingWindow(t *testing.T) {
+	app, mr := newTestApp(t)
+	app.conf.otp.rateLimitAlgorithm = "sliding"
+	ctx := context.Background()
+
+	// a request from before the window no longer counts
+	stale := time.Now().Add(-app.conf.otp.rateLimitWindow - time.Minute).UnixMilli()
+	if _, err := mr.ZAdd(otpSlidingRateLimitKey(testPhone), float64(stale), "stale"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= app.conf.otp.rateLimitMax; i++ {
+		res, err := app.allowOTPRequest(ctx, testPhone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.allowed {
+			t.Fatalf("request %d refused inside the limit", i)
+		}
+	}
+	res, err := app.allowOTPRequest(ctx, testPhone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.allowed {
+		t.Fatal("request over the limit allowed")
+	}
+	if res.resetIn <= 0 || res.resetIn > app.conf.otp.rateLimitWindow {
+		t.Fatalf("resetIn = %v, want within the window", res.resetIn)
+	}
+
+	peek, err := app.peekOTPRateLimit(ctx, testPhone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peek.count != int64(app.conf.otp.rateLimitMax) || peek.allowed {
+		t.Fatalf("peek = %+v, want the refused request left uncounted", peek)
+	}
+}
+
+// hashTag returns the part of key Redis Cluster hashes: the contents of the
+// first non-empty {...}, or the whole key.
+func hashTag(key string) string {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key[start+1 : start+1+end]
+		}
+	}
+	return key
+}
+
+func TestPerPhoneKeysShareClusterSlot(t *testing.T) {
+	keys := append(otpRateLimitKeys(testPhone),
+		otpCodeKey(testPhone, purposeLogin),
+		otpCodeKey(testPhone, "reset"),
+		otpChallengeKey(testPhone),
+		otpVerifyLockKey(testPhone),
+		recentOTPsKey(testPhone),
+		deletedPhoneKey(testPhone),
+	)
+	want := hashTag(otpKeyPrefix(testPhone))
+	for _, key := range keys {
+		if got := hashTag(key); got != want {
+			t.Errorf("key %q hashes on %q, want %q", key, got, want)
+		}
+	}
+	if hashTag(otpCodeKey("+989120000000", purposeLogin)) == want {
+		t.Error("different phones share a hash tag")
+	}
+}
+
+func TestOTPChallengeTTLBoundsResends(t *testing.T) {
+	app, mr := newTestApp(t)
+	app.conf.otp.maxChallengeLifetime = 10 * time.Minute
+	ctx := context.Background()
+	ttl := func() time.Duration {
+		t.Helper()
+		d, err := app.otpChallengeTTL(ctx, testPhone, 5*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	if got := ttl(); got != 5*time.Minute {
+		t.Fatalf("first request: ttl = %s, want the code TTL", got)
+	}
+	// a resend late in the challenge gets only what's left of it
+	mr.FastForward(7 * time.Minute)
+	if got := ttl(); got != 3*time.Minute {
+		t.Fatalf("resend after 7m: ttl = %s, want 3m", got)
+	}
+
+	if err := app.endOTPChallenge(ctx, testPhone); err != nil {
+		t.Fatal(err)
+	}
+	if got := ttl(); got != 5*time.Minute {
+		t.Fatalf("after the challenge ended: ttl = %s, want a fresh code TTL", got)
+	}
+
+	app.conf.otp.maxChallengeLifetime = 0
+	mr.FastForward(9 * time.Minute)
+	if got := ttl(); got != 5*time.Minute {
+		t.Fatalf("bound disabled: ttl = %s, want the code TTL", got)
+	}
+}
+
+func TestScriptInt(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want int64
+		ok   bool
+	}{
+		{int64(3), 3, true},
+		{7, 7, true},
+		{uint64(9), 9, true},
+		{float64(60), 60, true},
+		{"42", 42, true},
+		{[]byte("5"), 5, true},
+		{"x", 0, false},
+		{nil, 0, false},
+	}
+	for _, tt := range tests {
+		got, err := scriptInt(tt.v)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("scriptInt(%#v) = %d, %v; want %d, ok %t", tt.v, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRateLimitCheckCancelled(t *testing.T) {
+	app, _ := newTestApp(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := app.allowOTPRequest(ctx, testPhone)
+	if !errors.Is(err, errRateLimitTimeout) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want errRateLimitTimeout wrapping context.Canceled", err)
+	}
+	if err := rateLimitError(errors.New("NOSCRIPT")); errors.Is(err, errRateLimitTimeout) {
+		t.Fatalf("a script error reported as a timeout: %v", err)
+	}
+}
+
+func TestReadJSONKeepsLargeIntegersExact(t *testing.T) {
+	app, _ := newTestApp(t)
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"id":9007199254740993}`))
+
+	var dst map[string]any
+	if err := app.readJSON(httptest.NewRecorder(), r, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := dst["id"].(json.Number); !ok || n.String() != "9007199254740993" {
+		t.Fatalf("id = %#v, want the exact json.Number", dst["id"])
+	}
+}
+
+func TestGenerateOTP(t *testing.T) {
+	tests := []struct {
+		length int
+		draw   uint64
+		want   string
+	}{
+		{4, 7, "0007"},
+		{4, 123456789, "6789"},
+		{6, 42, "000042"},
+		{8, 987654321, "87654321"},
+	}
+	for _, tt := range tests {
+		got, err := generateOTP(otpDraws(tt.draw), tt.length)
+		if err != nil || got != tt.want {
+			t.Errorf("generateOTP(%d, length %d) = %q, %v; want %q", tt.draw, tt.length, got, err, tt.want)
+		}
+	}
+
+	if _, err := generateOTP(otpDraws(1), 19); err == nil {
+		t.Error("length 19 accepted")
+	}
+	if _, err := generateOTP(bytes.NewReader([]byte{1, 2, 3}), 6); err == nil {
+		t.Error("short random read accepted")
+	}
+}
+
+func TestGenerateOTPRejectsBiasedDraws(t *testing.T) {
+	// draws at or above the last full multiple of 10^6 would favour low
+	// codes, so they are skipped for the next one
+	const bound = 1_000_000
+	limit := uint64(math.MaxUint64 - math.MaxUint64%bound)
+	got, err := generateOTP(otpDraws(limit, math.MaxUint64, limit-1), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%06d", (limit-1)%bound); got != want {
+		t.Fatalf("code = %s, want %s from the first draw below the limit", got, want)
+	}
+}
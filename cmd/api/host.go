@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// hostConf guards against spoofed Host headers, which would otherwise end
+// up in redirects and links built from the request.
+type hostConf struct {
+	// allowed are exact host names or "*.example.com" patterns, which match
+	// any subdomain of example.com but not example.com itself. Empty
+	// disables the check.
+	allowed []string
+	// canonical, when set, is where GET and HEAD requests for other hosts
+	// are redirected instead of being rejected. It is always allowed.
+	canonical string
+}
+
+// parseHostList splits a comma-separated host list, lowercasing entries
+// and dropping empty ones.
+func parseHostList(s string) []string {
+	var hosts []string
+	for _, h := range strings.Split(s, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// hostname strips the port and any trailing dot from a Host value.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// hostAllowed reports whether host matches conf.host.
+func (app *application) hostAllowed(host string) bool {
+	conf := app.conf.host
+	if host == "" {
+		return false
+	}
+	// canonical may carry a port for the redirect target
+	if host == hostname(conf.canonical) {
+		return true
+	}
+	for _, pattern := range conf.allowed {
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			// suffix keeps its leading dot, so the bare domain doesn't match
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// enforceHost rejects requests whose Host isn't in conf.host.allowed with
+// 400, or redirects reads to the canonical host when one is configured.
+// /metrics is exempt because scrapers usually address the pod by IP.
+func (app *application) enforceHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		disabled := len(app.conf.host.allowed) == 0 && app.conf.host.canonical == ""
+		if disabled || r.URL.Path == "/metrics" || app.hostAllowed(hostname(r.Host)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if app.conf.host.canonical != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			target := app.requestScheme(r) + "://" + app.conf.host.canonical + r.URL.RequestURI()
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid host")
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHostAllowed(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.host.allowed = parseHostList(" API.example.com, *.example.org ,")
+	app.conf.host.canonical = "login.example.com:8443"
+
+	tests := map[string]bool{
+		"api.example.com:8000": true,
+		"API.EXAMPLE.COM.":     true,
+		"eu.example.org":       true,
+		"a.b.example.org":      true,
+		"example.org":          false,
+		"evil-example.org":     false,
+		"login.example.com":    true,
+		"example.com":          false,
+		"":                     false,
+	}
+	for host, want := range tests {
+		if got := app.hostAllowed(hostname(host)); got != want {
+			t.Errorf("hostAllowed(%q) = %t, want %t", host, got, want)
+		}
+	}
+}
+
+func TestEnforceHost(t *testing.T) {
+	app, _ := newTestApp(t)
+	h := app.enforceHost(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(method, host, target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// off until configured
+	if w := serve(http.MethodPost, "anything.test", "/verify"); w.Code != http.StatusNoContent {
+		t.Fatalf("disabled check: got %d", w.Code)
+	}
+
+	app.conf.host.allowed = []string{"api.example.com"}
+	if w := serve(http.MethodPost, "api.example.com", "/verify"); w.Code != http.StatusNoContent {
+		t.Fatalf("allowed host: got %d", w.Code)
+	}
+	if w := serve(http.MethodGet, "evil.test", "/me"); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown host without a canonical one: want 400, got %d", w.Code)
+	}
+	if w := serve(http.MethodGet, "10.0.0.7:8000", "/metrics"); w.Code != http.StatusNoContent {
+		t.Fatalf("/metrics by IP: got %d", w.Code)
+	}
+
+	app.conf.host.canonical = "api.example.com"
+	w := serve(http.MethodGet, "evil.test", "/users?q=912")
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "http://api.example.com/users?q=912" {
+		t.Fatalf("read on another host: got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := serve(http.MethodPost, "evil.test", "/verify"); w.Code != http.StatusBadRequest {
+		t.Fatalf("write on another host: want 400, got %d", w.Code)
+	}
+}
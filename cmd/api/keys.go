@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// minJWTSecretLength is the shortest HS256 secret accepted for signing.
+const minJWTSecretLength = 32
+
+// jwtKeySyncInterval is how often instances reload the shared signing key
+// choice; a rotation reaches the other instances within this long.
+const jwtKeySyncInterval = 10 * time.Second
+
+var errUnknownJWTKey = errors.New("key is not one of the configured JWT secrets")
+
+// jwtSigningKeyKey holds the ID of the configured secret every instance
+// signs with after a rotation. Only key IDs are shared through Redis, never
+// the secrets, so reading Redis doesn't allow minting tokens. The key is
+// scoped to the configured primary: deploying a new primary in
+// OTP_JWT_SECRET starts from that primary and ignores earlier rotations.
+func jwtSigningKeyKey(primaryID string) string {
+	return "jwt_signing_key:" + primaryID
+}
+
+// jwtKeyID identifies a secret without revealing it: the first 8 bytes of
+// its SHA-256, hex encoded.
+func jwtKeyID(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:8])
+}
+
+// jwtKeySet holds the configured secrets, all of which are accepted for
+// verification, and which of them new tokens are signed with, so a
+// rotation doesn't invalidate live tokens.
+type jwtKeySet struct {
+	mu sync.RWMutex
+	// keys are the configured secrets, the configured primary first.
+	keys [][]byte
+	ids  []string
+	// signing indexes keys; 0 until a rotation picks another key.
+	signing int
+}
+
+func newJWTKeySet(primary []byte, previous [][]byte) *jwtKeySet {
+	ks := &jwtKeySet{keys: append([][]byte{primary}, previous...)}
+	for _, k := range ks.keys {
+		ks.ids = append(ks.ids, jwtKeyID(k))
+	}
+	return ks
+}
+
+// parseJWTSecrets splits a comma-separated secret list: the first entry is
+// the signing key, the rest are only accepted for verification. Listing
+// the old secret after a new one allows rotating without downtime.
+func parseJWTSecrets(list string) (primary []byte, previous [][]byte) {
+	for _, secret := range strings.Split(list, ",") {
+		secret = strings.TrimSpace(secret)
+		if secret == "" {
+			continue
+		}
+		if primary == nil {
+			primary = []byte(secret)
+			continue
+		}
+		previous = append(previous, []byte(secret))
+	}
+	return primary, previous
+}
+
+// signingKey returns the key new tokens are signed with.
+func (ks *jwtKeySet) signingKey() []byte {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys[ks.signing]
+}
+
+// signingKeyID returns the ID of signingKey.
+func (ks *jwtKeySet) signingKeyID() string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.ids[ks.signing]
+}
+
+// primaryID returns the ID of the configured primary secret.
+func (ks *jwtKeySet) primaryID() string {
+	return ks.ids[0]
+}
+
+// keyIDs returns the IDs of every configured secret, primary first.
+func (ks *jwtKeySet) keyIDs() []string {
+	return slices.Clone(ks.ids)
+}
+
+// verificationKeys returns the signing key followed by the other
+// configured keys.
+func (ks *jwtKeySet) verificationKeys() jwt.VerificationKeySet {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	set := jwt.VerificationKeySet{Keys: []jwt.VerificationKey{ks.keys[ks.signing]}}
+	for i, k := range ks.keys {
+		if i != ks.signing {
+			set.Keys = append(set.Keys, k)
+		}
+	}
+	return set
+}
+
+// use makes the configured key with ID id the signing key. It reports
+// false, changing nothing, when no configured key has that ID.
+func (ks *jwtKeySet) use(id string) bool {
+	i := slices.Index(ks.ids, id)
+	if i < 0 {
+		return false
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.signing = i
+	return true
+}
+
+// rotateJWTKey makes the configured secret with ID id the signing key on
+// every instance: the choice is written to Redis first and applied
+// locally, and the other instances pick it up on their next sync. The
+// secret must already be in OTP_JWT_SECRET on every instance, typically
+// deployed as a verification-only entry beforehand.
+func (app *application) rotateJWTKey(ctx context.Context, id string) error {
+	if !slices.Contains(app.jwtKeys.ids, id) {
+		return errUnknownJWTKey
+	}
+	if err := app.cache.Set(ctx, jwtSigningKeyKey(app.jwtKeys.primaryID()), id, 0).Err(); err != nil {
+		return err
+	}
+	app.jwtKeys.use(id)
+	return nil
+}
+
+// syncJWTKeys applies the shared signing key choice from Redis. Without
+// one, or when it names a secret this instance isn't configured with, the
+// configured primary signs.
+func (app *application) syncJWTKeys(ctx context.Context) error {
+	primary := app.jwtKeys.primaryID()
+	id, err := app.cache.Get(ctx, jwtSigningKeyKey(primary)).Result()
+	if errors.Is(err, redis.Nil) {
+		app.jwtKeys.use(primary)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !app.jwtKeys.use(id) {
+		app.logger.Warn("rotated JWT key is not configured on this instance, signing with the configured primary",
+			"key_id", id, "primary_key_id", primary)
+		app.jwtKeys.use(primary)
+	}
+	return nil
+}
+
+// watchJWTKeys reloads the shared signing key choice every interval until
+// ctx is done.
+func (app *application) watchJWTKeys(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := app.syncJWTKeys(ctx); err != nil && ctx.Err() == nil {
+				app.logger.Error("Error loading JWT keys", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
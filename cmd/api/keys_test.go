@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	testJWTOld = []byte(strings.Repeat("o", minJWTSecretLength))
+	testJWTNew = []byte(strings.Repeat("n", minJWTSecretLength))
+)
+
+// verifyWith parses token against app's current verification keys.
+func verifyWith(app *application, token string) error {
+	_, err := jwt.ParseWithClaims(token, &authClaims{}, func(*jwt.Token) (interface{}, error) {
+		return app.jwtKeys.verificationKeys(), nil
+	})
+	return err
+}
+
+// newKeyedTestApp is newTestApp configured with secrets, primary first,
+// sharing mr when it isn't nil.
+func newKeyedTestApp(t *testing.T, mr *miniredis.Miniredis, secrets ...[]byte) (*application, *miniredis.Miniredis) {
+	t.Helper()
+	app, own := newTestApp(t)
+	if mr == nil {
+		mr = own
+	} else {
+		app.cache = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { app.cache.Close() })
+	}
+	app.jwtKeys = newJWTKeySet(secrets[0], secrets[1:])
+	return app, mr
+}
+
+func TestRotateJWTKeyKeepsOldTokensValid(t *testing.T) {
+	app, _ := newKeyedTestApp(t, nil, testJWTOld, testJWTNew)
+	ctx := context.Background()
+
+	old, err := app.generateJWT(1, "", time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.rotateJWTKey(ctx, jwtKeyID(testJWTNew)); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(app.jwtKeys.signingKey()); got != string(testJWTNew) {
+		t.Fatalf("signing with %q after rotation, want the new key", got)
+	}
+
+	fresh, err := app.generateJWT(1, "", time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"old": old, "fresh": fresh} {
+		if err := verifyWith(app, token); err != nil {
+			t.Fatalf("%s token: %v", name, err)
+		}
+	}
+}
+
+func TestRotateJWTKeySharesOnlyKeyIDs(t *testing.T) {
+	app, mr := newKeyedTestApp(t, nil, testJWTOld, testJWTNew)
+
+	if err := app.rotateJWTKey(context.Background(), jwtKeyID(testJWTNew)); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range mr.Keys() {
+		v, _ := mr.Get(key)
+		for _, secret := range [][]byte{testJWTOld, testJWTNew} {
+			if strings.Contains(v, string(secret)) {
+				t.Fatalf("Redis key %s holds a JWT secret", key)
+			}
+		}
+	}
+	if v, _ := mr.Get(jwtSigningKeyKey(jwtKeyID(testJWTOld))); v != jwtKeyID(testJWTNew) {
+		t.Fatalf("shared signing key = %q, want the new key's ID", v)
+	}
+}
+
+func TestRotateJWTKeyReachesOtherInstances(t *testing.T) {
+	app, mr := newKeyedTestApp(t, nil, testJWTOld, testJWTNew)
+	other, _ := newKeyedTestApp(t, mr, testJWTOld, testJWTNew)
+	ctx := context.Background()
+
+	// nothing rotated yet: the configured primary signs
+	if err := other.syncJWTKeys(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(other.jwtKeys.signingKey()); got != string(testJWTOld) {
+		t.Fatalf("signing with %q before rotation, want the configured primary", got)
+	}
+
+	if err := app.rotateJWTKey(ctx, jwtKeyID(testJWTNew)); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.syncJWTKeys(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(other.jwtKeys.signingKey()); got != string(testJWTNew) {
+		t.Fatalf("other instance signs with %q, want the rotated key", got)
+	}
+}
+
+func TestConfiguredJWTSecretOverridesRotation(t *testing.T) {
+	app, mr := newKeyedTestApp(t, nil, testJWTOld, testJWTNew)
+	ctx := context.Background()
+	if err := app.rotateJWTKey(ctx, jwtKeyID(testJWTNew)); err != nil {
+		t.Fatal(err)
+	}
+
+	// an emergency replacement deploys a new primary; the rotation made
+	// under the old primary no longer applies
+	replacement := []byte(strings.Repeat("r", minJWTSecretLength))
+	redeployed, _ := newKeyedTestApp(t, mr, replacement, testJWTNew)
+	if err := redeployed.syncJWTKeys(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(redeployed.jwtKeys.signingKey()); got != string(replacement) {
+		t.Fatalf("redeployed instance signs with %q, want the configured primary", got)
+	}
+
+	// dropping the rotated-to secret from the config falls back to the primary
+	pruned, _ := newKeyedTestApp(t, mr, testJWTOld)
+	if err := pruned.syncJWTKeys(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(pruned.jwtKeys.signingKey()); got != string(testJWTOld) {
+		t.Fatalf("instance without the rotated key signs with %q, want the configured primary", got)
+	}
+	token, _ := app.generateJWT(1, "", time.Hour, nil)
+	if err := verifyWith(pruned, token); err == nil {
+		t.Fatal("token signed with a secret removed from the config still verifies")
+	}
+}
+
+func TestRotateJWTKeyRejectsUnknownKey(t *testing.T) {
+	app, mr := newKeyedTestApp(t, nil, testJWTOld)
+
+	err := app.rotateJWTKey(context.Background(), jwtKeyID(testJWTNew))
+	if !errors.Is(err, errUnknownJWTKey) {
+		t.Fatalf("err = %v, want errUnknownJWTKey", err)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Fatalf("rejected rotation stored %q", keys)
+	}
+	if got := string(app.jwtKeys.signingKey()); got != string(testJWTOld) {
+		t.Fatalf("signing with %q after a rejected rotation", got)
+	}
+}
+
+func TestParseJWTSecrets(t *testing.T) {
+	tests := []struct {
+		list     string
+		primary  string
+		previous []string
+	}{
+		{"", "", nil},
+		{"new", "new", nil},
+		{"new, old ,older", "new", []string{"old", "older"}},
+		{" ,new,,old,", "new", []string{"old"}},
+	}
+	for _, tt := range tests {
+		primary, previous := parseJWTSecrets(tt.list)
+		if string(primary) != tt.primary {
+			t.Errorf("%q: primary = %q, want %q", tt.list, primary, tt.primary)
+		}
+		var got []string
+		for _, p := range previous {
+			got = append(got, string(p))
+		}
+		if !slices.Equal(got, tt.previous) {
+			t.Errorf("%q: previous = %q, want %q", tt.list, got, tt.previous)
+		}
+	}
+}
+
+func TestPreviousSecretStillVerifies(t *testing.T) {
+	old, _ := newKeyedTestApp(t, nil, testJWTOld)
+	token, err := old.generateJWT(1, "", time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deploy the new secret first, keeping the old one for verification
+	primary, previous := parseJWTSecrets(string(testJWTNew) + "," + string(testJWTOld))
+	app, _ := newKeyedTestApp(t, nil, append([][]byte{primary}, previous...)...)
+	if err := verifyWith(app, token); err != nil {
+		t.Fatalf("token signed with the previous secret: %v", err)
+	}
+	if got := string(app.jwtKeys.signingKey()); got != string(testJWTNew) {
+		t.Fatalf("signing with %q, want the first listed secret", got)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	lineTypeMobile     = "mobile"
+	lineTypeVoIP       = "voip"
+	lineTypeDisposable = "disposable"
+)
+
+// lineTypeLookup classifies a phone number by the kind of line behind it.
+type lineTypeLookup interface {
+	LineType(ctx context.Context, phone string) (string, error)
+}
+
+// prefixLineLookup reports numbers starting with one of a local list of
+// VoIP carrier prefixes as VoIP and everything else as mobile.
+type prefixLineLookup struct {
+	prefixes []string
+}
+
+func (l prefixLineLookup) LineType(ctx context.Context, phone string) (string, error) {
+	for _, p := range l.prefixes {
+		if strings.HasPrefix(phone, p) {
+			return lineTypeVoIP, nil
+		}
+	}
+	return lineTypeMobile, nil
+}
+
+// httpLineLookup asks a number-intelligence API with
+// GET <url>?phone=<number> and expects {"line_type": "..."} back.
+type httpLineLookup struct {
+	client *http.Client
+	url    string
+	apiKey string
+}
+
+func (l httpLineLookup) LineType(ctx context.Context, phone string) (string, error) {
+	u, err := url.Parse(l.url)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("phone", phone)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if l.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+l.apiKey)
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("line type lookup failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("line type lookup returned %s", resp.Status)
+	}
+
+	var out struct {
+		LineType string `json:"line_type"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&out); err != nil {
+		return "", fmt.Errorf("decoding line type response: %w", err)
+	}
+	if out.LineType == "" {
+		return "", errors.New("line type lookup returned no line_type")
+	}
+	return strings.ToLower(out.LineType), nil
+}
+
+func newLineTypeLookup(conf lineCheckConf, client *http.Client) (lineTypeLookup, error) {
+	switch conf.provider {
+	case "prefixes":
+		return prefixLineLookup{prefixes: conf.voipPrefixes}, nil
+	case "http":
+		if conf.url == "" {
+			return nil, errors.New("line check url is required for the http provider")
+		}
+		return httpLineLookup{client: client, url: conf.url, apiKey: conf.apiKey}, nil
+	default:
+		return nil, fmt.Errorf("unknown line check provider %q", conf.provider)
+	}
+}
+
+func lineTypeCacheKey(phone string) string {
+	return "linetype:" + phone
+}
+
+// phoneLineBlocked reports whether phone is on a blocked line type. Lookups
+// are cached in Redis for conf.lineCheck.cacheTTL so repeated requests for a
+// number don't each cost a provider call.
+func (app *application) phoneLineBlocked(ctx context.Context, phone string) (bool, string, error) {
+	key := lineTypeCacheKey(phone)
+
+	lineType, err := app.cache.Get(ctx, key).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return false, "", err
+	}
+	if errors.Is(err, redis.Nil) {
+		lineType, err = app.lineLookup.LineType(ctx, phone)
+		if err != nil {
+			return false, "", err
+		}
+		if err := app.cache.Set(ctx, key, lineType, app.conf.lineCheck.cacheTTL).Err(); err != nil {
+			app.logger.ErrorContext(ctx, "Error caching line type", "error", err)
+		}
+	}
+
+	return slices.Contains(app.conf.lineCheck.blockedTypes, lineType), lineType, nil
+}
+
+// lineCheckTimeout bounds the lookup so a slow provider can't stall /request.
+const lineCheckTimeout = 2 * time.Second
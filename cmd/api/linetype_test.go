@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubLineLookup answers every lookup with lineType or err, counting calls.
+type stubLineLookup struct {
+	lineType string
+	err      error
+	calls    int
+}
+
+func (l *stubLineLookup) LineType(ctx context.Context, phone string) (string, error) {
+	l.calls++
+	return l.lineType, l.err
+}
+
+func TestPrefixLineLookup(t *testing.T) {
+	l := prefixLineLookup{prefixes: []string{"+98990"}}
+	for phone, want := range map[string]string{
+		"+989901234567": lineTypeVoIP,
+		"+989121234567": lineTypeMobile,
+	} {
+		if got, _ := l.LineType(context.Background(), phone); got != want {
+			t.Errorf("LineType(%s) = %s, want %s", phone, got, want)
+		}
+	}
+}
+
+func TestHTTPLineLookup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Query().Get("phone") {
+		case testPhone:
+			w.Write([]byte(`{"line_type":"VoIP"}`))
+		case "+989120000000":
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	l := httpLineLookup{client: srv.Client(), url: srv.URL + "/lookup", apiKey: "key"}
+	if got, err := l.LineType(context.Background(), testPhone); err != nil || got != lineTypeVoIP {
+		t.Fatalf("LineType = %q, %v; want voip", got, err)
+	}
+	for _, phone := range []string{"+989120000000", "+989129999999"} {
+		if _, err := l.LineType(context.Background(), phone); err == nil {
+			t.Errorf("%s: bad provider answer accepted", phone)
+		}
+	}
+	l.apiKey = "wrong"
+	if _, err := l.LineType(context.Background(), testPhone); err == nil {
+		t.Error("401 accepted")
+	}
+}
+
+func TestPhoneLineBlockedCachesLookups(t *testing.T) {
+	app, _ := newTestApp(t)
+	lookup := &stubLineLookup{lineType: lineTypeVoIP}
+	app.lineLookup = lookup
+
+	for i := 0; i < 2; i++ {
+		blocked, lineType, err := app.phoneLineBlocked(context.Background(), testPhone)
+		if err != nil || !blocked || lineType != lineTypeVoIP {
+			t.Fatalf("call %d: phoneLineBlocked = %v, %q, %v", i, blocked, lineType, err)
+		}
+	}
+	if lookup.calls != 1 {
+		t.Fatalf("provider called %d times, want 1", lookup.calls)
+	}
+}
+
+func TestRequestOTPLineCheck(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.lineLookup = &stubLineLookup{lineType: lineTypeDisposable}
+	w := postJSON(app.handle(app.handleRequestOTP), "/request", `{"phone_number":"`+testPhone+`"}`)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("disposable line: want 403, got %d %s", w.Code, w.Body)
+	}
+
+	// a failing provider doesn't lock everyone out
+	app, _ = newTestApp(t)
+	app.lineLookup = &stubLineLookup{err: errors.New("provider down")}
+	requestTestOTP(t, app, testPhone)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// newLogger writes JSON lines to out, tagging records logged with a request
+// context with its request_id and masking phone numbers when
+// conf.maskLogPhones is set.
+func newLogger(out io.Writer, conf *config) *slog.Logger {
+	var h slog.Handler = slog.NewJSONHandler(out, nil)
+	h = &requestIDHandler{next: h}
+	if conf != nil && conf.maskLogPhones {
+		h = newPhoneMaskHandler(h)
+	}
+	return slog.New(h)
+}
+
+// fatal logs msg at error level and exits, for startup failures.
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestIDHandler adds the request_id stored in the record's context, so
+// every line logged with a request's context can be correlated.
+type requestIDHandler struct {
+	next slog.Handler
+}
+
+func (h *requestIDHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := contextGetRequestID(ctx); id != "" {
+		r = r.Clone()
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &requestIDHandler{next: h.next.WithAttrs(attrs)}
+}
+
+func (h *requestIDHandler) WithGroup(name string) slog.Handler {
+	return &requestIDHandler{next: h.next.WithGroup(name)}
+}
+
+// newRequestID returns a random (version 4) UUID.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// logRequests gives each request a fresh ID, returned in X-Request-ID and
+// stored in the context for every line logged while serving it, and logs
+// one line per request with its status and latency.
+func (app *application) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := newRequestID()
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+
+		sr := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			status := sr.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			app.logger.InfoContext(r.Context(), "request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", status,
+				"latency_ms", time.Since(start).Milliseconds())
+		}()
+		next.ServeHTTP(sr, r)
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"Go-OTP-Login/internal/sms"
+)
+
+var uuidV4 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// logLines decodes each JSON line written to out.
+func logLines(t *testing.T, out *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	sc := bufio.NewScanner(out)
+	for sc.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", sc.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestLogRequestsTagsLinesWithRequestID(t *testing.T) {
+	app, _ := newTestApp(t)
+	var out bytes.Buffer
+	app.logger = newLogger(&out, nil)
+
+	h := app.logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.logger.InfoContext(r.Context(), "handling")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/ping", nil))
+
+	id := rr.Header().Get("X-Request-ID")
+	if !uuidV4.MatchString(id) {
+		t.Fatalf("X-Request-ID = %q, want a v4 UUID", id)
+	}
+	lines := logLines(t, &out)
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2", len(lines))
+	}
+	for _, line := range lines {
+		if line["request_id"] != id {
+			t.Errorf("line %v: request_id = %v, want %s", line["msg"], line["request_id"], id)
+		}
+	}
+	req := lines[1]
+	if req["msg"] != "request" || req["method"] != "GET" || req["path"] != "/v1/ping" ||
+		req["status"] != float64(http.StatusTeapot) {
+		t.Errorf("request line = %v", req)
+	}
+	if _, ok := req["latency_ms"]; !ok {
+		t.Errorf("request line %v lacks latency_ms", req)
+	}
+}
+
+func TestLogRequestsUsesFreshIDs(t *testing.T) {
+	app, _ := newTestApp(t)
+	h := app.logRequests(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	seen := map[string]bool{}
+	for range 3 {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		id := rr.Header().Get("X-Request-ID")
+		if seen[id] {
+			t.Fatalf("request ID %s reused", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestLoggerWithoutRequestContext(t *testing.T) {
+	var out bytes.Buffer
+	newLogger(&out, nil).Info("startup")
+
+	lines := logLines(t, &out)
+	if len(lines) != 1 {
+		t.Fatalf("got %d log lines, want 1", len(lines))
+	}
+	if _, ok := lines[0]["request_id"]; ok {
+		t.Errorf("line logged outside a request has request_id: %v", lines[0])
+	}
+}
+
+func TestRedactedLogSenderKeepsCodesOut(t *testing.T) {
+	var out bytes.Buffer
+	s := sms.LogSender{Logger: newLogger(&out, nil), Redact: true}
+	if _, err := s.Send(context.Background(), testPhone, "Your code is 482913"); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); strings.Contains(got, "482913") {
+		t.Fatalf("redacted log %q contains the code", got)
+	}
+	lines := logLines(t, &out)
+	if len(lines) != 1 || lines[0]["length"] == nil {
+		t.Fatalf("log lines = %v, want one line with the message length", lines)
+	}
+}
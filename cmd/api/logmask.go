@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+)
+
+// e164Rx matches E.164-looking numbers: an optional +, then 8 to 15 digits
+// not starting with 0.
+var e164Rx = regexp.MustCompile(`\+?\b[1-9]\d{7,14}\b`)
+
+func maskPhonesIn(s string) string {
+	return e164Rx.ReplaceAllStringFunc(s, maskPhone)
+}
+
+// phoneMaskHandler masks phone numbers in the message and string attributes
+// of every record before passing it on, so a raw number logged by mistake
+// never reaches the output.
+type phoneMaskHandler struct {
+	next slog.Handler
+}
+
+func newPhoneMaskHandler(next slog.Handler) *phoneMaskHandler {
+	return &phoneMaskHandler{next: next}
+}
+
+func (h *phoneMaskHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *phoneMaskHandler) Handle(ctx context.Context, r slog.Record) error {
+	masked := slog.NewRecord(r.Time, r.Level, maskPhonesIn(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		masked.AddAttrs(maskAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, masked)
+}
+
+func (h *phoneMaskHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = maskAttr(a)
+	}
+	return &phoneMaskHandler{next: h.next.WithAttrs(out)}
+}
+
+func (h *phoneMaskHandler) WithGroup(name string) slog.Handler {
+	return &phoneMaskHandler{next: h.next.WithGroup(name)}
+}
+
+func maskAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(maskPhonesIn(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		out := make([]slog.Attr, len(group))
+		for i, g := range group {
+			out[i] = maskAttr(g)
+		}
+		a.Value = slog.GroupValue(out...)
+	}
+	return a
+}
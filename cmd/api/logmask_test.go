@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestPhoneMaskHandler(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(newPhoneMaskHandler(slog.NewTextHandler(&out, nil))).
+		With("phone", testPhone)
+
+	logger.WithGroup("req").Info("OTP sent to "+testPhone,
+		"to", "989121234567",
+		slog.Group("user", "phone_number", testPhone),
+		"user_id", 1234567890123,
+		"code", "123456")
+
+	got := out.String()
+	for _, raw := range []string{testPhone, "989121234567"} {
+		if strings.Contains(got, raw) {
+			t.Errorf("log %q contains %s", got, raw)
+		}
+	}
+	if !strings.Contains(got, maskPhone(testPhone)) {
+		t.Errorf("log %q lacks the masked phone", got)
+	}
+	// short codes and non-string values pass through
+	for _, kept := range []string{"code=123456", "user_id=1234567890123"} {
+		if !strings.Contains(got, kept) {
+			t.Errorf("log %q lacks %s", got, kept)
+		}
+	}
+}
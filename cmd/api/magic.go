@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/skip2/go-qrcode"
+)
+
+var errMagicTokenInvalid = errors.New("invalid or expired magic token")
+
+func magicTokenKey(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return "magic:" + hex.EncodeToString(hash[:])
+}
+
+// issueMagicLink stores a single-use login token for the phone and returns
+// the login URL plus a PNG QR code encoding it.
+func (app *application) issueMagicLink(ctx context.Context, phoneNumber string) (string, []byte, error) {
+	randomBytes := make([]byte, 20)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", nil, err
+	}
+	token := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	// only the hash is stored so a Redis dump can't be replayed as logins
+	if err := app.cache.Set(ctx, magicTokenKey(token), phoneNumber, app.conf.magic.ttl).Err(); err != nil {
+		return "", nil, fmt.Errorf("failed to store magic token: %w", err)
+	}
+
+	loginURL := app.conf.magic.baseURL + "/magic?token=" + url.QueryEscape(token)
+	png, err := qrcode.Encode(loginURL, qrcode.Medium, 256)
+	if err != nil {
+		return "", nil, err
+	}
+	return loginURL, png, nil
+}
+
+// consumeMagicToken atomically reads and deletes the token, so it
+// authenticates at most once.
+func (app *application) consumeMagicToken(ctx context.Context, token string) (string, error) {
+	phoneNumber, err := app.cache.GetDel(ctx, magicTokenKey(token)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", errMagicTokenInvalid
+	}
+	return phoneNumber, err
+}
+
+// handleMagicQR returns a scan-to-login QR code for the authenticated user,
+// so another device can sign in to the same account.
+func (app *application) handleMagicQR(w http.ResponseWriter, r *http.Request, user *data.User) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancel()
+
+	loginURL, png, err := app.issueMagicLink(ctx, user.PhoneNumber)
+	if err != nil {
+		return fmt.Errorf("failed to issue magic link: %w", err)
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{
+		"success":    true,
+		"message":    "Scan the QR code to log in",
+		"login_url":  loginURL,
+		"qr_png":     base64.StdEncoding.EncodeToString(png),
+		"expires_in": int(app.conf.magic.ttl / time.Second),
+	}, nil)
+}
+
+// handleMagicLogin godoc
+// @Summary     Magic link login
+// @Description Exchanges a single-use magic token (from a scanned QR code) for a JWT.
+// @Tags        Auth
+// @Produce     json
+// @Param       token query    string true "Magic token"
+// @Success     200   {object} verifyOTPRes
+// @Failure     400   {object} map[string]string
+// @Failure     401   {object} map[string]string
+// @Failure     500   {object} map[string]string
+// @Router      /magic [get]
+func (app *application) handleMagicLogin(w http.ResponseWriter, r *http.Request) error {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		return badRequest("Token is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancel()
+
+	phoneNumber, err := app.consumeMagicToken(ctx, token)
+	if errors.Is(err, errMagicTokenInvalid) {
+		return &apiError{Status: http.StatusUnauthorized, Code: "invalid_token", Message: "Invalid or expired token"}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to consume magic token: %w", err)
+	}
+
+	// errNotRegistered is answered with 403 by handleError
+	user, created, err := app.createUserIfNotExists(ctx, phoneNumber)
+	if err != nil {
+		return fmt.Errorf("failed to register user: %w", err)
+	}
+
+	jwtToken, err := app.generateJWT(user.ID, "", sessionTokenTTL, app.userClaims(user))
+	if err != nil {
+		return fmt.Errorf("failed to generate JWT for user %d: %w", user.ID, err)
+	}
+
+	refreshToken, err := app.models.Token.New(user.ID, app.conf.refreshTokenTTL)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token for user %d: %w", user.ID, err)
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{
+		"success":       true,
+		"message":       "User authenticated",
+		"data":          user,
+		"created":       created,
+		"token":         jwtToken,
+		"refresh_token": refreshToken.Plaintext,
+	}, nil)
+}
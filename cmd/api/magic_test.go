@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"Go-OTP-Login/internal/data"
+)
+
+func TestMagicQROnlyForOwnNumber(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.features = featureFlags{featureQRLogin: true}
+	body := `{"phone_number":"` + testPhone + `","qr":true}`
+
+	for _, tt := range []struct {
+		name string
+		user *data.User
+		want int
+	}{
+		{"anonymous", data.AnonymousUser, http.StatusForbidden},
+		{"someone else", &data.User{ID: 8, PhoneNumber: "+989121111111"}, http.StatusForbidden},
+		{"owner", &data.User{ID: 7, PhoneNumber: testPhone}, http.StatusOK},
+	} {
+		w := postJSON(withUser(app, tt.user, app.handle(app.handleRequestOTP)), "/request", body)
+		if w.Code != tt.want {
+			t.Errorf("%s: want %d, got %d %s", tt.name, tt.want, w.Code, w.Body)
+			continue
+		}
+		if tt.want == http.StatusOK {
+			if resp := decodeBody(t, w); resp["login_url"] == nil || resp["qr_png"] == "" {
+				t.Errorf("%s: no login link in %v", tt.name, resp)
+			}
+		}
+	}
+}
+
+func TestMagicQRNeedsFeature(t *testing.T) {
+	app, _ := newTestApp(t)
+	owner := &data.User{ID: 7, PhoneNumber: testPhone}
+
+	w := postJSON(withUser(app, owner, app.handle(app.handleRequestOTP)), "/request", `{"phone_number":"`+testPhone+`","qr":true}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("QR login disabled: want 400, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestMagicLoginIsSingleUse(t *testing.T) {
+	app, _ := newTestApp(t)
+	mock := mockDB(t, app)
+	expectLogin(mock, &data.User{ID: 7, PhoneNumber: testPhone}, false)
+
+	loginURL, _, err := app.issueMagicLink(context.Background(), testPhone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.handle(app.handleMagicLogin)(w, httptest.NewRequest(http.MethodGet, "/magic?"+u.RawQuery, nil))
+		return w
+	}
+
+	if w := login(); w.Code != http.StatusOK {
+		t.Fatalf("first use: want 200, got %d %s", w.Code, w.Body)
+	}
+	if w := login(); w.Code != http.StatusUnauthorized {
+		t.Fatalf("second use: want 401, got %d %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -16,12 +16,21 @@ package main
 
 import (
 	"Go-OTP-Login/internal/data"
+	"Go-OTP-Login/internal/sms"
 	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	_ "Go-OTP-Login/docs" // generated by swag init
@@ -30,109 +39,554 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 )
 
 type database struct {
+	// dsn, when set, is used as-is and takes precedence over the fields below.
 	dsn          string
+	host         string
+	port         int
+	user         string
+	password     string
+	name         string
+	sslmode      string
 	maxOpenConns int
 	maxIdleConns int
 	maxIdleTime  time.Duration
 }
 
 type redisConf struct {
+	// mode is "single" (default), "sentinel" or "cluster".
+	mode     string
 	addr     string
 	password string
 	db       int
+	// sentinel: addrs are the sentinels and masterName the monitored master.
+	// cluster: addrs are seed nodes; db must be 0.
+	addrs      []string
+	masterName string
+}
+
+type jwtConf struct {
+	// secret is a comma-separated list; see parseJWTSecrets. Every entry
+	// verifies, and /admin/jwt/rotate can only switch signing between them.
+	secret string
+}
+
+type smsConf struct {
+	// provider is "log" (development), "simulate" (staging, see
+	// /admin/sms/outbox) or "http".
+	provider string
+	url      string
+	apiKey   string
+	from     string
+	// testNumbers are the only destinations allowed for admin test messages,
+	// in E.164 form.
+	testNumbers []string
+	// webhookSecret signs the provider's delivery status callbacks.
+	webhookSecret string
+	// the breaker opens after breakerMaxFailures consecutive send failures
+	// and probes the provider again after breakerOpenTimeout.
+	breakerEnabled     bool
+	breakerMaxFailures int
+	breakerOpenTimeout time.Duration
+	// provider-wide send quotas; zero disables a window.
+	quotaPerSecond int
+	quotaPerDay    int
+	// outboxSize is how many messages the "simulate" provider keeps.
+	outboxSize int
+	// branding is added to OTP messages as long as they fit in maxSegments.
+	branding    sms.Branding
+	maxSegments int
+}
+
+type otpConf struct {
+	// resetLimitOnVerify clears the per-phone request counter after a successful
+	// verify. Off by default; OTP_RESET_LIMIT_ON_VERIFY turns it on.
+	resetLimitOnVerify bool
+	// maxAttempts is how many wrong codes lock the phone out of verifying.
+	maxAttempts int
+	// attemptsTTL is how long the failed-attempts counter (and so a lockout)
+	// lives. It is independent of the OTP TTL so a lockout outlasts the code.
+	attemptsTTL time.Duration
+	// minResponseTime pads /request and /verify responses to a uniform
+	// duration so timing doesn't leak the outcome. Zero disables it.
+	minResponseTime time.Duration
+	// reuseWindow is how many recently issued codes per phone are never
+	// reissued. Zero disables the check.
+	reuseWindow int
+	// rateLimitMax OTP requests per phone are allowed within
+	// rateLimitWindow; cancels count against the same limit.
+	rateLimitMax    int
+	rateLimitWindow time.Duration
+	// rateLimitAlgorithm is "fixed" (default, cheapest) or "sliding".
+	rateLimitAlgorithm string
+	// resendCooldown is the minimum gap between OTP requests for a phone.
+	resendCooldown time.Duration
+	// maxChallengeLifetime bounds a verification challenge, resends
+	// included, from its first request. Zero disables the bound.
+	maxChallengeLifetime time.Duration
+	// previousCodeGrace keeps the replaced code valid this long after a
+	// resend, for codes still in transit. Zero (default) disables it.
+	previousCodeGrace time.Duration
+	// failureLog emits a masked otp_verify_failed event per failed verify,
+	// at most failureLogPerSecond per second.
+	failureLog          bool
+	failureLogPerSecond int
+	// originLog keeps IP, user agent, phone and calling code of the last
+	// originLogSize OTP requests in Redis for fraud scoring.
+	originLog     bool
+	originLogSize int
+	// tarpit delays every verify by tarpitBase plus tarpitStep per failed
+	// attempt so far, capped at tarpitMax. A zero tarpitMax disables it.
+	tarpitBase time.Duration
+	tarpitStep time.Duration
+	tarpitMax  time.Duration
+	// maxResends caps /resend calls per phone within resendWindow, which
+	// starts at the first resend. Zero leaves resends uncapped; they are
+	// then only bounded by the cooldown and the request rate limit.
+	maxResends   int
+	resendWindow time.Duration
+}
+
+type sheddingConf struct {
+	// enabled is off by default so a slow dependency doesn't start
+	// rejecting routes nobody opted in to shed.
+	enabled bool
+	// maxLatency is the dependency ping latency above which load is shed.
+	maxLatency    time.Duration
+	checkInterval time.Duration
+	// lowPriority routes get a 503 while dependencies are degraded.
+	lowPriority []string
+}
+
+type magicConf struct {
+	// baseURL is the public origin used to build magic login links.
+	baseURL string
+	ttl     time.Duration
+}
+
+// lineCheckConf blocks OTP requests for numbers on disposable or VoIP lines.
+type lineCheckConf struct {
+	enabled bool
+	// provider is "prefixes" (local voipPrefixes list) or "http" (lookup API at url).
+	provider string
+	// voipPrefixes are leading parts of E.164 numbers, e.g. "+98990".
+	voipPrefixes []string
+	url          string
+	apiKey       string
+	// blockedTypes are the line types rejected with 403.
+	blockedTypes []string
+	// cacheTTL is how long a lookup result is cached in Redis.
+	cacheTTL time.Duration
+}
+
+// metricsPushConf configures pushing metrics to a Prometheus Pushgateway,
+// for environments that can't scrape /metrics. Disabled when url is empty.
+type metricsPushConf struct {
+	url      string
+	interval time.Duration
+	job      string
+	// instance is the grouping label; defaults to the hostname.
+	instance string
+}
+
+// httpClientConf tunes the shared client used for outbound calls.
+type httpClientConf struct {
+	timeout               time.Duration
+	dialTimeout           time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	idleConnTimeout       time.Duration
+	maxIdleConns          int
+	maxIdleConnsPerHost   int
 }
 
 type config struct {
-	port  int
-	db    database
-	redis redisConf
+	port     int
+	env      string // "development" or "production"; development pretty-prints JSON
+	db       database
+	redis    redisConf
+	jwt      jwtConf
+	sms      smsConf
+	otp      otpConf
+	shedding sheddingConf
+	magic    magicConf
+	http     httpClientConf
+	features featureFlags
+	adminIDs []int64
+	// lineCheck rejects OTP requests for VoIP/disposable numbers.
+	lineCheck lineCheckConf
+	// metricsPush is off by default; /metrics is always served for scraping.
+	metricsPush metricsPushConf
+	// slidingSession renews near-expiry session tokens on use.
+	slidingSession slidingSessionConf
+	// refreshTokenTTL is the lifetime of refresh tokens (sessions).
+	refreshTokenTTL time.Duration
+	// refreshAccessTTL is the lifetime of the access JWT /refresh issues.
+	// It is kept short since the refresh token can always get a new one.
+	refreshAccessTTL time.Duration
+	// autoCreateUsers registers unknown phones on their first verify; off
+	// means invite-only and unknown phones get 403.
+	autoCreateUsers bool
+	// deletedPhoneCooldown blocks the number of a deleted account from
+	// signing up again for this long. Zero disables it.
+	deletedPhoneCooldown time.Duration
+	// refreshTokenBytes is the random bytes per refresh token, at least
+	// data.MinTokenBytes.
+	refreshTokenBytes int
+	// stepUpMaxAge is how recent an OTP verification must be for sensitive actions.
+	stepUpMaxAge time.Duration
+	// jsonNaming is the key convention for responses: "snake" or "camel".
+	jsonNaming string
+	// clientIDs are the client apps that may request an audience-scoped
+	// token on /verify.
+	clientIDs []string
+	// audienceRules restricts path prefixes to tokens whose aud is one of
+	// the listed client IDs, e.g. {"/admin/": {"web"}}.
+	audienceRules map[string][]string
+	// strictPageSize rejects page_size above the maximum with 400 instead
+	// of silently capping it.
+	strictPageSize bool
+	// maxPageOffset rejects pages starting beyond this many rows, since deep
+	// OFFSET scans are slow. Zero disables the check.
+	maxPageOffset int
+	// legacyListEnvelope wraps list responses in "response" instead of
+	// "data", for clients not yet moved to the new key.
+	legacyListEnvelope bool
+	// maskLogPhones routes logging through phoneMaskHandler so phone
+	// numbers are masked even where a caller forgot to.
+	maskLogPhones bool
+	// trustedProxies are the peers whose X-Forwarded-* headers are honored.
+	trustedProxies []netip.Prefix
+	// internalAccess lets unauthenticated reads from trusted internal
+	// networks past the guard of allowInternal routes, which need no user.
+	// Off while either list is empty.
+	internalAccess internalAccessConf
+	// otpLength is the number of digits in issued codes: 4, 6 or 8.
+	otpLength int
+	// emptySearchListsAll makes /users without q list every user; off, an
+	// empty q returns an empty page.
+	emptySearchListsAll bool
+	// host restricts which Host headers are served; off by default.
+	host hostConf
+	// dbReplicaDSN points at a read replica for user listing and lookups
+	// by ID, using the primary's pool settings. Empty sends reads to db.
+	dbReplicaDSN string
+	// captcha guards /request against automated abuse; off by default.
+	captcha captchaConf
+	// otpPurposes are the accepted values of the purpose field on /request,
+	// each with its own code TTL and length. login is always present.
+	otpPurposes map[string]otpPurposeConf
+}
+
+type internalAccessConf struct {
+	// cidrs are matched against the direct peer, never X-Forwarded-For.
+	cidrs []netip.Prefix
+	// routes are exact paths; an entry ending in "/" matches as a prefix.
+	routes []string
 }
 
 type application struct {
-	conf      config
-	logger    *log.Logger
-	cache     *redis.Client
-	models    data.Models
-	jwtSecret []byte
+	conf       config
+	logger     *slog.Logger
+	db         *sql.DB
+	replica    *sql.DB // nil without conf.dbReplicaDSN
+	cache      redis.UniversalClient
+	models     data.Models
+	jwtKeys    *jwtKeySet
+	sms        sms.Sender
+	httpClient *http.Client // shared by every outbound HTTP call
+	health     healthGauges
+	// lineLookup classifies numbers for lineCheck; nil when it's disabled.
+	lineLookup lineTypeLookup
+	// captcha checks /request tokens; nil when conf.captcha is off.
+	captcha captchaVerifier
+	// outbox is the "simulate" SMS provider; nil for real providers.
+	outbox *sms.SimulateSender
+	// workers are the background goroutines stopped on shutdown.
+	workers *workerGroup
+	// random overrides crypto/rand.Reader for OTP generation; nil in production.
+	random io.Reader
+
+	// throttles the otp_verify_failed log events
+	verifyFailureLimiter *eventLimiter
 }
 
 func main() {
-	conf := &config{
-		port: 8000,
-		db: database{
-			dsn:          "host=localhost port=5433 user=postgres password=1234 dbname=optlogin sslmode=disable",
-			maxOpenConns: 25,
-			maxIdleConns: 25,
-			maxIdleTime:  time.Minute,
-		},
-		redis: redisConf{
-			addr:     "localhost:6379",
-			password: "secret",
-			db:       0,
-		},
+	conf, err := loadConfig(os.Args[1:], os.Getenv, os.Environ())
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal(newLogger(os.Stdout, nil), "Invalid configuration", "error", err)
 	}
 
-	logger := log.New(os.Stdout, "LOG\t", log.Ldate|log.Ltime)
+	logger := newLogger(os.Stdout, conf)
 
 	db, err := connectDB(conf.db)
 	if err != nil {
-		logger.Fatalf("Connecting to database failed: %s", err)
+		fatal(logger, "Connecting to database failed", "error", err)
 	}
-	logger.Printf("successfully Conected to database\n")
+	logger.Info("successfully connected to database")
 	defer db.Close()
 
+	var replica *sql.DB
+	if conf.dbReplicaDSN != "" {
+		replicaConf := conf.db
+		replicaConf.dsn = conf.dbReplicaDSN
+		replica, err = connectDB(replicaConf)
+		if err != nil {
+			fatal(logger, "Connecting to database replica failed", "error", err)
+		}
+		logger.Info("successfully connected to database replica")
+		defer replica.Close()
+	}
+
 	redisClient, err := connectRedis(conf.redis)
 	if err != nil {
-		logger.Fatalf("Connecting to reddis server failed: %s", err)
+		fatal(logger, "Connecting to redis server failed", "error", err)
 	}
-	logger.Printf("successfully connected to redis server\n")
+	logger.Info("successfully connected to redis server")
 	defer redisClient.Close()
 
-	app := application{
-		conf:      *conf,
-		logger:    logger,
-		cache:     redisClient,
-		models:    data.NewModels(db),
-		jwtSecret: []byte("my-secret"),
+	httpClient := newHTTPClient(conf.http)
+
+	if conf.sms.provider == "simulate" && conf.env == "production" {
+		fatal(logger, "The simulate SMS provider exposes OTP codes and can't be used in production")
+	}
+	smsSender, err := newSMSSender(conf.sms, httpClient, logger, conf.env == "production")
+	if err != nil {
+		fatal(logger, "Configuring SMS sender failed", "error", err)
+	}
+	outbox, _ := innermostSender(smsSender).(*sms.SimulateSender)
+
+	var lineLookup lineTypeLookup
+	if conf.lineCheck.enabled {
+		lineLookup, err = newLineTypeLookup(conf.lineCheck, httpClient)
+		if err != nil {
+			fatal(logger, "Configuring line type lookup failed", "error", err)
+		}
+	}
+
+	var captcha captchaVerifier
+	if conf.captcha.provider != "" {
+		captcha, err = newCaptchaVerifier(conf.captcha, httpClient)
+		if err != nil {
+			fatal(logger, "Configuring CAPTCHA failed", "error", err)
+		}
+	}
+
+	jwtPrimary, jwtPrevious := parseJWTSecrets(conf.jwt.secret)
+	if jwtPrimary == nil {
+		fatal(logger, "JWT secret is not configured")
+	}
+
+	models := data.NewModels(db)
+	models.Token.Bytes = conf.refreshTokenBytes
+	models.User.Replica = replica
+
+	app := &application{
+		conf:       *conf,
+		logger:     logger,
+		db:         db,
+		replica:    replica,
+		cache:      redisClient,
+		models:     models,
+		jwtKeys:    newJWTKeySet(jwtPrimary, jwtPrevious),
+		sms:        smsSender,
+		httpClient: httpClient,
+		lineLookup: lineLookup,
+		captcha:    captcha,
+		outbox:     outbox,
+		workers:    newWorkerGroup(),
+
+		verifyFailureLimiter: &eventLimiter{perSecond: conf.otp.failureLogPerSecond},
+	}
+
+	app.registerMetrics()
+
+	if err := app.selfCheck(); err != nil {
+		fatal(logger, "Startup self-check failed", "error", err)
+	}
+
+	if err := app.syncJWTKeys(context.Background()); err != nil {
+		fatal(logger, "Loading JWT keys failed", "error", err)
+	}
+	app.workers.Go("jwt key sync", func(ctx context.Context) {
+		app.watchJWTKeys(ctx, jwtKeySyncInterval)
+	})
+
+	app.checkHealth()
+	if app.conf.shedding.enabled {
+		app.workers.Go("health monitor", func(ctx context.Context) {
+			app.monitorHealth(ctx, app.conf.shedding.checkInterval)
+		})
+	}
+	if app.conf.metricsPush.url != "" {
+		app.workers.Go("metrics pusher", app.pushMetrics)
 	}
 
-	// routes
+	// routes; every response that can carry a token or login link is
+	// wrapped in noStore
 	router := httprouter.New()
-	router.HandlerFunc(http.MethodPost, "/request", app.handleRequestOTP)
-	router.HandlerFunc(http.MethodPost, "/verify", app.handleVerifyOTP)
-	router.HandlerFunc(http.MethodGet, "/users", app.handleListUsers)
+	router.PanicHandler = app.panicHandler
+	router.HandlerFunc(http.MethodPost, "/request", app.noStore(app.padResponseTime(app.handle(app.handleRequestOTP))))
+	router.HandlerFunc(http.MethodPost, "/resend", app.noStore(app.padResponseTime(app.handle(app.handleResendOTP))))
+	router.HandlerFunc(http.MethodPost, "/request/cancel", app.padResponseTime(app.handle(app.handleCancelOTP)))
+	router.HandlerFunc(http.MethodPost, "/verify", app.noStore(app.padResponseTime(app.handle(app.handleVerifyOTP))))
+	router.HandlerFunc(http.MethodPost, "/verify/scoped", app.noStore(app.padResponseTime(app.handle(app.handleVerifyOTPScoped))))
+	router.HandlerFunc(http.MethodGet, "/verify/scoped/check",
+		app.requireScope(scopePhoneVerify, app.handle(app.handleCheckPhoneVerification)))
+	if app.conf.features.qrLogin() {
+		router.HandlerFunc(http.MethodGet, "/magic", app.noStore(app.handle(app.handleMagicLogin)))
+	}
+	if app.conf.features.verifyWait() {
+		router.HandlerFunc(http.MethodGet, "/verify/wait", app.noStore(app.handle(app.handleVerifyWait)))
+	}
+	router.HandlerFunc(http.MethodGet, "/config/limits", app.handle(app.handleConfigLimits))
+	router.HandlerFunc(http.MethodGet, "/otp/meta", app.handle(app.handleOTPMeta))
+	router.HandlerFunc(http.MethodPost, "/webhooks/sms/status", app.handle(app.handleSMSStatusCallback))
+	router.HandlerFunc(http.MethodGet, "/users", app.handle(app.handleListUsers))
 	// router.HandlerFunc(http.MethodGet, "/user/", app.getSingleUser)
-	router.GET("/users/:id", app.getSingleUser)
+	router.HandlerFunc(http.MethodGet, "/users/:id", app.handle(app.getSingleUser))
 	router.HandlerFunc(http.MethodGet, "/protected",
-		app.requireAuthenticatedUser(app.protectedHandler))
+		app.requireAuthenticatedUser(app.handle(app.protectedHandler)))
+	router.HandlerFunc(http.MethodGet, "/me/sessions",
+		app.requireAuthenticatedUser(app.handle(app.handleListSessions)))
+	router.HandlerFunc(http.MethodPost, "/refresh", app.noStore(app.handle(app.handleRefresh)))
+	router.HandlerFunc(http.MethodPost, "/me/refresh/rotate",
+		app.requireAuthenticatedUser(app.noStore(app.handle(app.handleRotateRefreshToken))))
+	router.HandlerFunc(http.MethodGet, "/me/export",
+		app.requireAuthenticatedUser(app.noStore(app.handle(app.handleExportMe))))
+	router.HandlerFunc(http.MethodDelete, "/me",
+		app.requireAuthenticatedUser(app.handle(app.handleDeleteMe)))
+	router.HandlerFunc(http.MethodDelete, "/me/sessions/:id",
+		app.requireAuthenticatedUser(app.handle(app.handleRevokeSession)))
+	router.HandlerFunc(http.MethodPost, "/admin/jwt/rotate",
+		app.requireAdminUser(app.requireRecentVerification(app.conf.stepUpMaxAge)(app.handle(app.handleRotateJWTSecret))))
+	router.HandlerFunc(http.MethodPost, "/admin/sms/test",
+		app.requireAdminUser(app.handle(app.handleTestSMS)))
+	if app.outbox != nil {
+		router.HandlerFunc(http.MethodGet, "/admin/sms/outbox",
+			app.requireAdminUser(app.noStore(app.handle(app.handleSMSOutbox))))
+	}
+	router.HandlerFunc(http.MethodGet, "/admin/otp/requests",
+		app.allowInternal(app.handle(app.handleOTPOrigins), app.requireAdminUser))
+	router.HandlerFunc(http.MethodGet, "/admin/otp/status",
+		app.requireAdminUser(app.handle(app.handleOTPStatus)))
+	router.HandlerFunc(http.MethodPost, "/admin/otp/flush",
+		app.requireAdminUser(app.requireRecentVerification(app.conf.stepUpMaxAge)(app.handle(app.handleFlushOTPs))))
+	router.HandlerFunc(http.MethodPost, "/admin/tokens/introspect",
+		app.requireAdminUser(app.noStore(app.handle(app.handleIntrospectToken))))
+	router.HandlerFunc(http.MethodPost, "/admin/rate-limit/reset",
+		app.requireAdminUser(app.handle(app.handleResetRateLimit)))
+	router.HandlerFunc(http.MethodGet, "/admin/users/active",
+		app.requireAdminUser(app.handle(app.handleListActiveUsers)))
+	router.HandlerFunc(http.MethodGet, "/admin/runtime",
+		app.allowInternal(app.handle(app.handleRuntimeStats), app.requireAdminUser))
 	router.GET("/", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintln(w, "Welcome to My OTP Login project")
 	})
+	router.Handler(http.MethodGet, "/metrics", promhttp.Handler())
 	// swagger UI
 	router.Handler(http.MethodGet, "/swagger/*any", httpSwagger.WrapHandler)
 
 	// server
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.conf.port),
-		Handler:      app.recoverPanic(app.authenticate(router)),
+		Handler:      app.logRequests(app.instrument(router, app.recoverPanic(app.enforceHost(app.secureHeaders(app.limitRequestBody(app.shedLoad(app.authenticate(app.enforceAudience(router))))))))),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
 
-	app.logger.Printf("Server starting on port: %d\n", app.conf.port)
-	if err := server.ListenAndServe(); err != nil {
-		app.logger.Fatalf("Starting server failed: %s", err)
+	// on SIGINT/SIGTERM: drain requests, then stop the background workers,
+	// then let the deferred DB and Redis closes run
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		app.logger.Info("shutting down server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			app.logger.Error("server shutdown error", "error", err)
+		}
+	}()
+
+	app.logger.Info("server starting", "port", app.conf.port)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatal(app.logger, "Starting server failed", "error", err)
+	}
+	<-shutdownDone
+
+	if err := app.workers.Shutdown(10 * time.Second); err != nil {
+		app.logger.Error("worker shutdown error", "error", err)
+	}
+	app.logger.Info("server stopped")
+}
+
+// buildDSN returns the explicit DSN if set, otherwise assembles a key/value
+// postgres connection string from the individual fields.
+func (d database) buildDSN() (string, error) {
+	if d.dsn != "" {
+		return d.dsn, nil
+	}
+
+	var missing []string
+	if d.host == "" {
+		missing = append(missing, "host")
+	}
+	if d.user == "" {
+		missing = append(missing, "user")
+	}
+	if d.name == "" {
+		missing = append(missing, "dbname")
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("database config missing required fields: %s", strings.Join(missing, ", "))
+	}
+
+	parts := []string{
+		"host=" + dsnValue(d.host),
+		"user=" + dsnValue(d.user),
+		"dbname=" + dsnValue(d.name),
+	}
+	if d.port != 0 {
+		parts = append(parts, fmt.Sprintf("port=%d", d.port))
+	}
+	if d.password != "" {
+		parts = append(parts, "password="+dsnValue(d.password))
+	}
+	if d.sslmode != "" {
+		parts = append(parts, "sslmode="+dsnValue(d.sslmode))
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// quote a DSN value if it is empty or contains spaces, quotes or backslashes
+func dsnValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
 	}
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + r.Replace(v) + "'"
 }
 
 func connectDB(conf database) (*sql.DB, error) {
-	db, err := sql.Open("postgres", conf.dsn)
+	dsn, err := conf.buildDSN()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -148,12 +602,11 @@ func connectDB(conf database) (*sql.DB, error) {
 	return db, nil
 }
 
-func connectRedis(conf redisConf) (*redis.Client, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     conf.addr,
-		Password: conf.password,
-		DB:       conf.db,
-	})
+func connectRedis(conf redisConf) (redis.UniversalClient, error) {
+	client, err := newRedisClient(conf)
+	if err != nil {
+		return nil, err
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if _, err := client.Ping(ctx).Result(); err != nil {
@@ -161,3 +614,103 @@ func connectRedis(conf redisConf) (*redis.Client, error) {
 	}
 	return client, nil
 }
+
+// newRedisClient builds the client for the configured deployment mode.
+func newRedisClient(conf redisConf) (redis.UniversalClient, error) {
+	switch conf.mode {
+	case "", "single":
+		return redis.NewClient(&redis.Options{
+			Addr:     conf.addr,
+			Password: conf.password,
+			DB:       conf.db,
+		}), nil
+	case "sentinel":
+		if conf.masterName == "" || len(conf.addrs) == 0 {
+			return nil, errors.New("redis sentinel mode needs a master name and sentinel addrs")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    conf.masterName,
+			SentinelAddrs: conf.addrs,
+			Password:      conf.password,
+			DB:            conf.db,
+		}), nil
+	case "cluster":
+		if len(conf.addrs) == 0 {
+			return nil, errors.New("redis cluster mode needs seed addrs")
+		}
+		if conf.db != 0 {
+			return nil, errors.New("redis cluster mode only supports db 0")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    conf.addrs,
+			Password: conf.password,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", conf.mode)
+	}
+}
+
+func newHTTPClient(conf httpClientConf) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   conf.dialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Client{
+		Timeout: conf.timeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   conf.tlsHandshakeTimeout,
+			ResponseHeaderTimeout: conf.responseHeaderTimeout,
+			IdleConnTimeout:       conf.idleConnTimeout,
+			MaxIdleConns:          conf.maxIdleConns,
+			MaxIdleConnsPerHost:   conf.maxIdleConnsPerHost,
+			ForceAttemptHTTP2:     true,
+		},
+	}
+}
+
+func newSMSSender(conf smsConf, client *http.Client, logger *slog.Logger, redact bool) (sms.Sender, error) {
+	var sender sms.Sender
+
+	switch conf.provider {
+	case "log":
+		sender = sms.LogSender{Logger: logger, Redact: redact}
+	case "simulate":
+		sender = sms.NewSimulateSender(conf.outboxSize)
+	case "http":
+		if conf.url == "" {
+			return nil, errors.New("sms url is required for the http provider")
+		}
+		sender = sms.HTTPSender{
+			Client: client,
+			URL:    conf.url,
+			APIKey: conf.apiKey,
+			From:   conf.from,
+		}
+	default:
+		return nil, fmt.Errorf("unknown SMS provider %q", conf.provider)
+	}
+
+	if conf.breakerEnabled {
+		sender = sms.NewBreakerSender(sender, uint32(conf.breakerMaxFailures), conf.breakerOpenTimeout, func(from, to string) {
+			logger.Warn("SMS circuit breaker", "from", from, "to", to)
+		})
+	}
+	// outside the breaker so quota rejections don't count as provider failures
+	if conf.quotaPerSecond > 0 || conf.quotaPerDay > 0 {
+		sender = sms.NewQuotaSender(sender, conf.quotaPerSecond, conf.quotaPerDay)
+	}
+	return sender, nil
+}
+
+// innermostSender strips the breaker and quota wrappers off a sender.
+func innermostSender(s sms.Sender) sms.Sender {
+	for {
+		w, ok := s.(interface{ Unwrap() sms.Sender })
+		if !ok {
+			return s
+		}
+		s = w.Unwrap()
+	}
+}
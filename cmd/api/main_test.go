@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"Go-OTP-Login/internal/sms"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestBuildDSN(t *testing.T) {
+	d := database{host: "db", port: 5433, user: "otp", password: "p w'x", name: "optlogin", sslmode: "disable"}
+
+	got, err := d.buildDSN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `host=db user=otp dbname=optlogin port=5433 password='p w\'x' sslmode=disable`
+	if got != want {
+		t.Fatalf("buildDSN() = %q, want %q", got, want)
+	}
+
+	d.dsn = "postgres://other@elsewhere/otp"
+	if got, err := d.buildDSN(); err != nil || got != d.dsn {
+		t.Fatalf("explicit DSN: buildDSN() = %q, %v; want %q", got, err, d.dsn)
+	}
+}
+
+func TestBuildDSNRequiresFields(t *testing.T) {
+	_, err := database{port: 5432}.buildDSN()
+	if err == nil {
+		t.Fatal("empty config built a DSN")
+	}
+	for _, field := range []string{"host", "user", "dbname"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error %q doesn't name %s", err, field)
+		}
+	}
+}
+
+func TestNewRedisClientModes(t *testing.T) {
+	tests := []struct {
+		name string
+		conf redisConf
+		ok   bool
+	}{
+		{"default", redisConf{addr: "localhost:6379"}, true},
+		{"single", redisConf{mode: "single", addr: "localhost:6379", db: 2}, true},
+		{"sentinel", redisConf{mode: "sentinel", masterName: "mymaster", addrs: []string{"s1:26379"}}, true},
+		{"sentinel without master", redisConf{mode: "sentinel", addrs: []string{"s1:26379"}}, false},
+		{"sentinel without addrs", redisConf{mode: "sentinel", masterName: "mymaster"}, false},
+		{"cluster", redisConf{mode: "cluster", addrs: []string{"n1:6379", "n2:6379"}}, true},
+		{"cluster without addrs", redisConf{mode: "cluster"}, false},
+		{"cluster with db", redisConf{mode: "cluster", addrs: []string{"n1:6379"}, db: 1}, false},
+		{"unknown", redisConf{mode: "ring"}, false},
+	}
+	for _, tt := range tests {
+		client, err := newRedisClient(tt.conf)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok %t", tt.name, err, tt.ok)
+			continue
+		}
+		if client != nil {
+			client.Close()
+		}
+	}
+
+	client, _ := newRedisClient(redisConf{mode: "cluster", addrs: []string{"n1:6379"}})
+	defer client.Close()
+	if _, ok := client.(*redis.ClusterClient); !ok {
+		t.Fatalf("cluster mode built a %T", client)
+	}
+}
+
+func TestHTTPClientTimesOutSlowProviders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	client := newHTTPClient(httpClientConf{
+		timeout:               time.Second,
+		dialTimeout:           time.Second,
+		responseHeaderTimeout: 50 * time.Millisecond,
+	})
+	start := time.Now()
+	resp, err := client.Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("slow response did not time out")
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Fatalf("gave up after %v, want the response header timeout", elapsed)
+	}
+}
+
+func TestSimulateOutboxBehindWrappers(t *testing.T) {
+	app, _ := newTestApp(t)
+	conf := smsConf{provider: "simulate", outboxSize: 5, breakerEnabled: true, breakerMaxFailures: 5,
+		breakerOpenTimeout: time.Second, quotaPerSecond: 10}
+	sender, err := newSMSSender(conf, http.DefaultClient, app.logger, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox, ok := innermostSender(sender).(*sms.SimulateSender)
+	if !ok {
+		t.Fatalf("innermost sender is %T, want the simulate sender", innermostSender(sender))
+	}
+	app.sms, app.outbox = sender, outbox
+
+	if w := postJSON(app.handle(app.handleRequestOTP), "/request", `{"phone_number":"`+testPhone+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("/request: want 200, got %d %s", w.Code, w.Body)
+	}
+	w := httptest.NewRecorder()
+	app.handle(app.handleSMSOutbox)(w, httptest.NewRequest(http.MethodGet, "/admin/sms/outbox", nil))
+	messages, _ := decodeBody(t, w)["messages"].([]any)
+	if len(messages) != 1 || messages[0].(map[string]any)["to"] != testPhone {
+		t.Fatalf("messages = %v, want the code sent to %s", messages, testPhone)
+	}
+}
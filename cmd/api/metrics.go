@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+	"Go-OTP-Login/internal/sms"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+var (
+	otpRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "otp_requests_total",
+		Help: "OTP codes issued by /request.",
+	})
+
+	otpVerifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "otp_verify_total",
+		Help: "OTP verifications by result (success, invalid or expired).",
+	}, []string{"result"})
+
+	otpRateLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "otp_rate_limited_total",
+		Help: "OTP requests rejected by the per-phone rate limit.",
+	})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Duration of HTTP requests by route pattern, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+)
+
+// numeric values of the sms_circuit_breaker_state gauge
+var breakerStateValues = map[string]float64{
+	"closed":    0,
+	"half-open": 1,
+	"open":      2,
+}
+
+// registerMetrics registers the application's collectors with the default
+// Prometheus registry served on /metrics.
+func (app *application) registerMetrics() {
+	prometheus.MustRegister(data.Collectors()...)
+	prometheus.MustRegister(otpRequests, otpVerifications, otpRateLimited, httpRequestDuration)
+
+	sender := app.sms
+	if quota, ok := sender.(*sms.QuotaSender); ok {
+		sender = quota.Unwrap()
+	}
+	if breaker, ok := sender.(*sms.BreakerSender); ok {
+		prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "sms_circuit_breaker_state",
+			Help: "State of the SMS provider circuit breaker (0=closed, 1=half-open, 2=open).",
+		}, func() float64 {
+			return breakerStateValues[breaker.State()]
+		}))
+	}
+}
+
+// pushMetrics pushes the default registry to the configured Pushgateway
+// every interval until ctx is cancelled, then pushes once more so the last
+// values before shutdown aren't lost.
+func (app *application) pushMetrics(ctx context.Context) {
+	conf := app.conf.metricsPush
+	instance := conf.instance
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	pusher := push.New(conf.url, conf.job).
+		Gatherer(prometheus.DefaultGatherer).
+		Grouping("instance", instance).
+		Client(app.httpClient)
+
+	ticker := time.NewTicker(conf.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := pusher.PushContext(ctx); err != nil && ctx.Err() == nil {
+				app.logger.ErrorContext(ctx, "metrics push error", "error", err)
+			}
+		case <-ctx.Done():
+			final, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := pusher.PushContext(final); err != nil {
+				app.logger.ErrorContext(ctx, "final metrics push error", "error", err)
+			}
+			cancel()
+			return
+		}
+	}
+}
+
+// instrument records the latency and status of every request in
+// httpRequestDuration, labelled with the route pattern router matches
+// (e.g. /users/:id) so IDs don't become label values.
+func (app *application) instrument(router *httprouter.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			status := sr.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			httpRequestDuration.
+				WithLabelValues(routePattern(router, r), r.Method, strconv.Itoa(status)).
+				Observe(time.Since(start).Seconds())
+		}()
+		next.ServeHTTP(sr, r)
+	})
+}
+
+// routePattern maps a request back to the route it matches by putting the
+// parameter names in place of their values, or "unmatched" for paths the
+// router doesn't serve.
+func routePattern(router *httprouter.Router, r *http.Request) string {
+	handle, params, _ := router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return "unmatched"
+	}
+
+	path := r.URL.Path
+	end := len(path)
+	for i := len(params) - 1; i >= 0; i-- {
+		p := params[i]
+		// catch-all values keep their leading slash
+		if strings.HasPrefix(p.Value, "/") {
+			path = path[:end-len(p.Value)] + "/*" + p.Key
+			end -= len(p.Value)
+			continue
+		}
+		at := strings.LastIndex(path[:end], "/"+p.Value)
+		if at < 0 {
+			continue
+		}
+		path = path[:at] + "/:" + p.Key + path[at+1+len(p.Value):]
+		end = at
+	}
+	return path
+}
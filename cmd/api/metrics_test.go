@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestPushMetricsPushesOnShutdown(t *testing.T) {
+	var pushes atomic.Int32
+	pushed := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/metrics/job/otp/instance/test" {
+			t.Errorf("push to %s %s", r.Method, r.URL.Path)
+		}
+		pushes.Add(1)
+		select {
+		case pushed <- struct{}{}:
+		default:
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	app, _ := newTestApp(t)
+	app.httpClient = srv.Client()
+	app.conf.metricsPush = metricsPushConf{url: srv.URL, interval: 10 * time.Millisecond, job: "otp", instance: "test"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		app.pushMetrics(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-pushed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no periodic push")
+	}
+	before := pushes.Load()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("pushMetrics didn't stop after cancellation")
+	}
+
+	if pushes.Load() <= before {
+		t.Fatal("no final push on shutdown")
+	}
+}
+
+// counterValue reads the current value of c.
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestOTPFlowCounters(t *testing.T) {
+	app, _ := newTestApp(t)
+	requested := counterValue(t, otpRequests)
+	verified := map[string]float64{}
+	for _, result := range []string{"success", "invalid", "expired"} {
+		verified[result] = counterValue(t, otpVerifications.WithLabelValues(result))
+	}
+
+	checkTestOTP(t, app, testPhone, "000000") // nothing pending
+	code := requestTestOTP(t, app, testPhone)
+	checkTestOTP(t, app, testPhone, "000000")
+	if w := checkTestOTP(t, app, testPhone, code); w != nil {
+		t.Fatalf("verify: %d %s", w.Code, w.Body)
+	}
+
+	if got := counterValue(t, otpRequests) - requested; got != 1 {
+		t.Errorf("otp_requests_total grew by %v, want 1", got)
+	}
+	for result, before := range verified {
+		if got := counterValue(t, otpVerifications.WithLabelValues(result)) - before; got != 1 {
+			t.Errorf("otp_verify_total{result=%q} grew by %v, want 1", result, got)
+		}
+	}
+}
+
+func TestOTPRateLimitedCounter(t *testing.T) {
+	for _, algorithm := range []string{"fixed", "sliding"} {
+		t.Run(algorithm, func(t *testing.T) {
+			app, _ := newTestApp(t)
+			app.conf.otp.rateLimitAlgorithm = algorithm
+			before := counterValue(t, otpRateLimited)
+
+			for i := 0; ; i++ {
+				res, err := app.allowOTPRequest(context.Background(), testPhone)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !res.allowed {
+					break
+				}
+				if i == 100 {
+					t.Fatal("never rate limited")
+				}
+				if got := counterValue(t, otpRateLimited) - before; got != 0 {
+					t.Fatal("allowed request counted as rate limited")
+				}
+			}
+			if got := counterValue(t, otpRateLimited) - before; got != 1 {
+				t.Errorf("otp_rate_limited_total grew by %v, want 1", got)
+			}
+		})
+	}
+}
+
+func TestRoutePattern(t *testing.T) {
+	router := httprouter.New()
+	noop := func(http.ResponseWriter, *http.Request, httprouter.Params) {}
+	router.GET("/users", noop)
+	router.GET("/users/:id", noop)
+	router.GET("/users/:id/sessions/:sid", noop)
+	router.GET("/files/*path", noop)
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/users", "/users"},
+		{"/users/42", "/users/:id"},
+		{"/users/7/sessions/7", "/users/:id/sessions/:sid"},
+		{"/files/a/b.txt", "/files/*path"},
+		{"/nope", "unmatched"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if got := routePattern(router, r); got != tt.want {
+			t.Errorf("%s: route = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestInstrumentLabelsByRoute(t *testing.T) {
+	app, _ := newTestApp(t)
+	router := httprouter.New()
+	router.GET("/users/:id", func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	observed := func() uint64 {
+		var m dto.Metric
+		h := httpRequestDuration.WithLabelValues("/users/:id", http.MethodGet, "404")
+		if err := h.(prometheus.Metric).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetHistogram().GetSampleCount()
+	}
+	before := observed()
+	h := app.instrument(router, router)
+	for _, id := range []string{"1", "2"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/"+id, nil))
+	}
+	if got := observed() - before; got != 2 {
+		t.Fatalf("observed %d requests under /users/:id, want 2", got)
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"Go-OTP-Login/internal/data"
 
@@ -16,14 +21,59 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				w.Header().Set("Connection", "close")
-				app.errorResponse(w, http.StatusInternalServerError, "Failed to recover")
+				app.panicHandler(w, r, err)
 			}
 		}()
 		next.ServeHTTP(w, r)
 	})
 }
 
+// panicHandler writes the JSON 500 envelope for a recovered panic. It is also
+// installed as the router's PanicHandler so panics in param handlers
+// registered with router.GET are answered the same way.
+func (app *application) panicHandler(w http.ResponseWriter, r *http.Request, err interface{}) {
+	app.logger.ErrorContext(r.Context(), "panic serving request", "method", r.Method, "path", r.URL.Path, "panic", err)
+	w.Header().Set("Connection", "close")
+	app.errorResponse(w, r, http.StatusInternalServerError, "Failed to recover")
+}
+
+// secureHeaders sets browser security headers. HSTS is only sent when the
+// client reached us over HTTPS, directly or through a trusted proxy.
+func (app *application) secureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "DENY")
+		if app.requestScheme(r) == "https" {
+			w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// noStore keeps token-bearing responses out of browser and proxy caches.
+func (app *application) noStore(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shedLoad returns 503 for low-priority routes while dependencies are degraded,
+// keeping capacity for critical routes like /verify.
+func (app *application) shedLoad(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.conf.shedding.enabled &&
+			app.isLowPriorityRoute(r.URL.Path) &&
+			app.health.degraded(app.conf.shedding.maxLatency) {
+			w.Header().Set("Retry-After", "5")
+			app.errorResponse(w, r, http.StatusServiceUnavailable, "Service temporarily overloaded, please retry later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // authenticate validates Bearer JWT and sets user in context.
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -38,53 +88,300 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		parts := strings.SplitN(auth, " ", 2)
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			app.errorResponse(w, http.StatusUnauthorized, "Invalid authorization header")
+			app.errorResponse(w, r, http.StatusUnauthorized, "Invalid authorization header")
 			return
 		}
 
 		tokenStr := parts[1]
-		parsed, err := jwt.ParseWithClaims(tokenStr, &jwt.RegisteredClaims{}, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("unexpected signing method")
+		parsed, err := jwt.ParseWithClaims(tokenStr, &authClaims{}, func(t *jwt.Token) (interface{}, error) {
+			if t.Method != jwt.SigningMethodHS256 {
+				return nil, errUnexpectedSigningMethod
 			}
-			return app.jwtSecret, nil
+			return app.jwtKeys.verificationKeys(), nil
 		})
+		// the parser rejects tokens before their nbf as well as after exp
+		if errors.Is(err, jwt.ErrTokenNotValidYet) {
+			app.errorResponse(w, r, http.StatusUnauthorized, "Token is not valid yet")
+			return
+		}
+		switch {
+		// ErrTokenUnverifiable without our error means an alg the library
+		// doesn't know; either way it isn't a token we minted
+		case errors.Is(err, errUnexpectedSigningMethod), errors.Is(err, jwt.ErrTokenUnverifiable):
+			app.logger.WarnContext(r.Context(), "jwt signing method mismatch",
+				"alg", tokenAlg(parsed), "ip", app.clientIP(r))
+		case errors.Is(err, jwt.ErrTokenExpired):
+			app.logger.InfoContext(r.Context(), "jwt expired", "ip", app.clientIP(r))
+		}
 		if err != nil || !parsed.Valid {
-			app.errorResponse(w, http.StatusUnauthorized, "Invalid or expired token")
+			app.errorResponse(w, r, http.StatusUnauthorized, "Invalid or expired token")
 			return
 		}
 
-		claims, ok := parsed.Claims.(*jwt.RegisteredClaims)
+		claims, ok := parsed.Claims.(*authClaims)
 		if !ok || claims.Subject == "" {
-			app.errorResponse(w, http.StatusUnauthorized, "Invalid token claims")
+			app.errorResponse(w, r, http.StatusUnauthorized, "Invalid token claims")
 			return
 		}
 
 		userID, err := strconv.ParseInt(claims.Subject, 10, 64)
 		if err != nil {
-			app.errorResponse(w, http.StatusUnauthorized, "Invalid token subject")
+			app.errorResponse(w, r, http.StatusUnauthorized, "Invalid token subject")
 			return
 		}
 
-		user, err := app.models.User.GetByID(userID)
-		if err != nil {
-			app.errorResponse(w, http.StatusUnauthorized, "User not found")
+		user, err := app.models.User.GetByIDPrimary(userID)
+		if err != nil || user.IsAnonymized() {
+			app.errorResponse(w, r, http.StatusUnauthorized, "User not found")
 			return
 		}
 
+		app.slideSession(w, claims, user)
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetScope(r, claims.Scope)
+		r = app.contextSetVerifiedPhone(r, claims.PhoneNumber)
+		r = app.contextSetAudience(r, claims.Audience)
 		next.ServeHTTP(w, r)
 	})
 }
 
-// requireAuthenticatedUser blocks requests from AnonymousUser.
+// errUnexpectedSigningMethod rejects tokens not signed with HS256, the only
+// method generateJWT uses, so alg confusion ("none", RS256 with the secret
+// as a public key, ...) can be told apart from expiry in the logs.
+var errUnexpectedSigningMethod = errors.New("unexpected signing method")
+
+// tokenAlg returns the alg header of a token that failed to parse, for
+// logging; the token itself is never logged.
+func tokenAlg(t *jwt.Token) string {
+	if t == nil {
+		return ""
+	}
+	alg, _ := t.Header["alg"].(string)
+	return alg
+}
+
+// authClaims are the JWT claims read by authenticate.
+type authClaims struct {
+	jwt.RegisteredClaims
+	// Scope limits the token to routes guarded by requireScope; empty for
+	// session tokens.
+	Scope string `json:"scope,omitempty"`
+	// PhoneNumber is the number a scoped token's OTP proved. It can differ
+	// from the user's own, e.g. a new number on phone_change.
+	PhoneNumber string `json:"phone_number,omitempty"`
+	// SessionStart (unix seconds) is set on tokens renewed by slideSession
+	// so the session lifetime is counted from the original login.
+	SessionStart int64 `json:"session_start,omitempty"`
+}
+
+// enforceAudience applies conf.audienceRules: on a matching path prefix an
+// authenticated request's token must have been minted for one of the
+// listed client IDs. Anonymous requests are left to the route's own checks.
+func (app *application) enforceAudience(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, ok := app.audienceRule(r.URL.Path)
+		if !ok || app.contextGetUser(r).IsAnonymous() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, aud := range app.contextGetAudience(r) {
+			if slices.Contains(allowed, aud) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		app.errorResponse(w, r, http.StatusForbidden, "Token audience not allowed for this endpoint")
+	})
+}
+
+// audienceRule returns the client IDs of the longest rule prefix matching path.
+func (app *application) audienceRule(path string) ([]string, bool) {
+	var (
+		best    string
+		allowed []string
+		found   bool
+	)
+	for prefix, clients := range app.conf.audienceRules {
+		if strings.HasPrefix(path, prefix) && len(prefix) >= len(best) {
+			best, allowed, found = prefix, clients, true
+		}
+	}
+	return allowed, found
+}
+
+// requireAuthenticatedUser blocks requests from AnonymousUser and from
+// single-action scoped tokens, which only open requireScope routes.
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 		if user.IsAnonymous() {
-			app.errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+			app.errorResponse(w, r, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		if app.contextGetScope(r) != "" {
+			app.errorResponse(w, r, http.StatusForbidden, "Token is not valid for this endpoint")
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// requireScope only admits tokens issued for exactly this scope.
+func (app *application) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			app.errorResponse(w, r, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		if app.contextGetScope(r) != scope {
+			app.errorResponse(w, r, http.StatusForbidden, "Token is not valid for this endpoint")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAdminUser blocks requests from users not listed in conf.adminIDs.
+func (app *application) requireAdminUser(next http.HandlerFunc) http.HandlerFunc {
+	return app.requireAuthenticatedUser(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		if !app.isAdmin(user.ID) {
+			app.errorResponse(w, r, http.StatusForbidden, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowInternal serves anonymous reads that fromInternalNetwork admits
+// straight from next and everything else through guard(next). Internal
+// callers reach next as AnonymousUser, so it must only wrap handlers that
+// never read the user.
+func (app *application) allowInternal(next http.HandlerFunc, guard func(http.HandlerFunc) http.HandlerFunc) http.HandlerFunc {
+	guarded := guard(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetUser(r).IsAnonymous() && app.fromInternalNetwork(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		guarded.ServeHTTP(w, r)
+	})
+}
+
+// bufferedResponseWriter holds the response in memory so it can be sent later.
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (bw *bufferedResponseWriter) Header() http.Header { return bw.header }
+
+func (bw *bufferedResponseWriter) Write(b []byte) (int, error) {
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	return bw.body.Write(b)
+}
+
+func (bw *bufferedResponseWriter) WriteHeader(status int) {
+	if bw.status == 0 {
+		bw.status = status
+	}
+}
+
+// tarpitDelay is how long a verify is held after attempts failed attempts.
+func (app *application) tarpitDelay(attempts int64) time.Duration {
+	conf := app.conf.otp
+	if conf.tarpitMax <= 0 {
+		return 0
+	}
+	return min(conf.tarpitBase+time.Duration(attempts)*conf.tarpitStep, conf.tarpitMax)
+}
+
+// tarpit sleeps for tarpitDelay, returning early if ctx is done.
+func (app *application) tarpit(ctx context.Context, attempts int64) {
+	d := app.tarpitDelay(attempts)
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// limitRequestBody answers 413 without reading the body when the declared
+// Content-Length is already over maxRequestBodyBytes. Bodies of unknown
+// length are left to the MaxBytesReader in readJSON.
+func (app *application) limitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxRequestBodyBytes {
+			w.Header().Set("Connection", "close")
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("body must not be larger than %d bytes", maxRequestBodyBytes))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// padResponseTime holds the response until conf.otp.minResponseTime has
+// passed since the request started, equalizing timing across outcomes.
+func (app *application) padResponseTime(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		minDuration := app.conf.otp.minResponseTime
+		if minDuration <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		bw := &bufferedResponseWriter{header: w.Header()}
+		next.ServeHTTP(bw, r)
+
+		if remaining := minDuration - time.Since(start); remaining > 0 {
+			timer := time.NewTimer(remaining)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+			}
+		}
+
+		if bw.status == 0 {
+			bw.status = http.StatusOK
+		}
+		w.WriteHeader(bw.status)
+		_, _ = w.Write(bw.body.Bytes())
+	})
+}
+
+// requireRecentVerification demands that the user verified an OTP within
+// maxAge (step-up auth), even if their JWT is still valid.
+func (app *application) requireRecentVerification(maxAge time.Duration) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return app.requireAuthenticatedUser(func(w http.ResponseWriter, r *http.Request) {
+			user := app.contextGetUser(r)
+
+			verifiedAt, err := app.lastVerifiedAt(r.Context(), user.ID)
+			if err != nil {
+				app.logger.ErrorContext(r.Context(), "step-up check error", "error", err)
+				app.errorResponse(w, r, http.StatusInternalServerError, "Failed to check verification")
+				return
+			}
+			if verifiedAt.IsZero() || time.Since(verifiedAt) > maxAge {
+				_ = app.writeJSON(w, http.StatusUnauthorized, envelope{
+					"error": "Recent OTP verification required",
+					"code":  "step_up_required",
+				}, nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
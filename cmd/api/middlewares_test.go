@@ -0,0 +1,583 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/julienschmidt/httprouter"
+)
+
+// timeRequest serves one request through h and reports how long it took.
+func timeRequest(h http.HandlerFunc) (*httptest.ResponseRecorder, time.Duration) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/verify", nil)
+	start := time.Now()
+	h(w, r)
+	return w, time.Since(start)
+}
+
+func TestPadResponseTimeEqualizesOutcomes(t *testing.T) {
+	app, _ := newTestApp(t)
+	const target = 100 * time.Millisecond
+	app.conf.otp.minResponseTime = target
+
+	fast := app.padResponseTime(func(w http.ResponseWriter, r *http.Request) {
+		app.errorResponse(w, r, http.StatusUnauthorized, "Invalid or expired OTP")
+	})
+	slow := app.padResponseTime(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	fastW, fastTook := timeRequest(fast)
+	slowW, slowTook := timeRequest(slow)
+
+	for name, took := range map[string]time.Duration{"fast": fastTook, "slow": slowTook} {
+		if took < target {
+			t.Errorf("%s outcome took %s, want at least %s", name, took, target)
+		}
+	}
+	if diff := (fastTook - slowTook).Abs(); diff > 50*time.Millisecond {
+		t.Errorf("outcomes differ by %s (fast %s, slow %s)", diff, fastTook, slowTook)
+	}
+	if fastW.Code != http.StatusUnauthorized || slowW.Code != http.StatusCreated {
+		t.Errorf("statuses = %d, %d; want 401, 201", fastW.Code, slowW.Code)
+	}
+	if fastW.Body.Len() == 0 {
+		t.Error("padded response lost its body")
+	}
+}
+
+func TestPadResponseTimeDisabled(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.otp.minResponseTime = 0
+
+	_, took := timeRequest(app.padResponseTime(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	if took > 50*time.Millisecond {
+		t.Fatalf("unpadded response took %s", took)
+	}
+}
+
+func TestCustomClaimsRoundTrip(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.adminIDs = []int64{7}
+	mock := mockDB(t, app)
+	user := &data.User{ID: 7, PhoneNumber: testPhone, CreatedAt: time.Now()}
+
+	token, err := app.generateJWT(user.ID, "", time.Hour, app.userClaims(user))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectUserByID(mock, user)
+	var seen *data.User
+	w := httptest.NewRecorder()
+	app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = app.contextGetUser(r)
+	})).ServeHTTP(w, authedRequest("/protected", token))
+	if seen == nil || seen.ID != user.ID {
+		t.Fatalf("authenticate: status %d, user %v", w.Code, seen)
+	}
+
+	// downstream services read the claims straight from the token
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return app.jwtKeys.verificationKeys(), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if claims["phone_number"] != testPhone {
+		t.Errorf("phone_number claim = %v", claims["phone_number"])
+	}
+	if roles, _ := claims["roles"].([]any); len(roles) != 2 || roles[1] != "admin" {
+		t.Errorf("roles claim = %v, want [user admin]", claims["roles"])
+	}
+}
+
+func TestCustomClaimsCannotOverrideReserved(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	for claim := range reservedJWTClaims {
+		if _, err := app.generateJWT(7, "", time.Hour, map[string]interface{}{claim: "spoofed"}); err == nil {
+			t.Errorf("custom %q claim was accepted", claim)
+		}
+	}
+}
+
+func TestShedLoad(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.shedding.enabled = true
+	app.conf.shedding.lowPriority = []string{"/users"}
+	app.health.redisUp.Store(true)
+	app.health.dbUp.Store(true)
+
+	h := app.shedLoad(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	if code := serve("/users"); code != http.StatusNoContent {
+		t.Fatalf("healthy /users: got %d", code)
+	}
+
+	app.health.redisLatency.Store(int64(2 * app.conf.shedding.maxLatency))
+	for path, want := range map[string]int{
+		"/users":    http.StatusServiceUnavailable,
+		"/users/42": http.StatusServiceUnavailable,
+		"/verify":   http.StatusNoContent,
+		"/usersx":   http.StatusNoContent,
+	} {
+		if code := serve(path); code != want {
+			t.Errorf("degraded %s: got %d, want %d", path, code, want)
+		}
+	}
+
+	app.health.redisLatency.Store(0)
+	app.health.dbUp.Store(false)
+	if code := serve("/users"); code != http.StatusServiceUnavailable {
+		t.Errorf("database down /users: got %d, want 503", code)
+	}
+
+	app.conf.shedding.enabled = false
+	if code := serve("/users"); code != http.StatusNoContent {
+		t.Errorf("shedding off /users: got %d", code)
+	}
+}
+
+func TestAllowInternal(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.internalAccess.cidrs = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	app.conf.internalAccess.routes = []string{"/admin/runtime", "/me/sessions"}
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	internal := app.allowInternal(ok, app.requireAdminUser)
+	perUser := app.requireAuthenticatedUser(ok)
+
+	serve := func(h http.HandlerFunc, method, path, peer string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = peer + ":4321"
+		h(w, app.contextSetUser(r, data.AnonymousUser))
+		return w.Code
+	}
+
+	for _, tt := range []struct {
+		name   string
+		h      http.HandlerFunc
+		method string
+		path   string
+		peer   string
+		want   int
+	}{
+		{"internal read", internal, http.MethodGet, "/admin/runtime", "10.1.2.3", http.StatusNoContent},
+		{"external read", internal, http.MethodGet, "/admin/runtime", "203.0.113.7", http.StatusUnauthorized},
+		{"internal write", internal, http.MethodPost, "/admin/runtime", "10.1.2.3", http.StatusUnauthorized},
+		{"internal read off the allowlist", internal, http.MethodGet, "/admin/other", "10.1.2.3", http.StatusUnauthorized},
+		// allowlisting a per-user route must not open it: only allowInternal
+		// routes honor the internal networks
+		{"internal read of a per-user route", perUser, http.MethodGet, "/me/sessions", "10.1.2.3", http.StatusUnauthorized},
+		{"external read of a per-user route", perUser, http.MethodGet, "/me/sessions", "203.0.113.7", http.StatusUnauthorized},
+	} {
+		if got := serve(tt.h, tt.method, tt.path, tt.peer); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// an authenticated non-admin from an internal network still gets the guard
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/admin/runtime", nil)
+	r.RemoteAddr = "10.1.2.3:4321"
+	internal(w, app.contextSetUser(r, &data.User{ID: 7}))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("internal non-admin: got %d, want 403", w.Code)
+	}
+}
+
+func TestPanicsAnswerWithJSONError(t *testing.T) {
+	app, _ := newTestApp(t)
+	panics := func(w http.ResponseWriter, r *http.Request) { panic("boom") }
+
+	router := httprouter.New()
+	router.PanicHandler = app.panicHandler
+	router.GET("/users/:id", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) { panic("boom") })
+
+	for name, h := range map[string]http.Handler{
+		"router":       router,
+		"recoverPanic": app.recoverPanic(http.HandlerFunc(panics)),
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("%s: want 500, got %d %s", name, w.Code, w.Body)
+			continue
+		}
+		if w.Header().Get("Connection") != "close" {
+			t.Errorf("%s: connection left open after a panic", name)
+		}
+		if body := decodeBody(t, w); body["error"] == nil {
+			t.Errorf("%s: body %v is not the JSON error envelope", name, body)
+		}
+	}
+}
+
+func TestRequireRecentVerification(t *testing.T) {
+	app, mr := newTestApp(t)
+	user := &data.User{ID: 7, PhoneNumber: "+989121234567"}
+	h := withUser(app, user, app.requireRecentVerification(5*time.Minute)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for _, tt := range []struct {
+		name     string
+		verified time.Time
+		want     int
+	}{
+		{"never verified", time.Time{}, http.StatusUnauthorized},
+		{"verified long ago", time.Now().Add(-time.Hour), http.StatusUnauthorized},
+		{"verified just now", time.Now(), http.StatusNoContent},
+	} {
+		mr.Del(lastVerifiedKey(user.ID))
+		if !tt.verified.IsZero() {
+			mr.Set(lastVerifiedKey(user.ID), strconv.FormatInt(tt.verified.Unix(), 10))
+		}
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodDelete, "/me", nil))
+		if w.Code != tt.want {
+			t.Errorf("%s: want %d, got %d %s", tt.name, tt.want, w.Code, w.Body)
+			continue
+		}
+		if tt.want == http.StatusUnauthorized && decodeBody(t, w)["code"] != "step_up_required" {
+			t.Errorf("%s: body %s lacks code step_up_required", tt.name, w.Body)
+		}
+	}
+}
+
+func TestNoStoreCoversErrorResponses(t *testing.T) {
+	app, _ := newTestApp(t)
+	h := app.noStore(app.handle(app.handleVerifyOTP))
+
+	for name, body := range map[string]string{
+		"bad request": `{`,
+		"wrong code":  `{"phone_number":"` + testPhone + `","otp":"000000"}`,
+	} {
+		w := postJSON(h, "/verify", body)
+		if got := w.Header().Get("Cache-Control"); got != "no-store" {
+			t.Errorf("%s (%d): Cache-Control = %q, want no-store", name, w.Code, got)
+		}
+		if got := w.Header().Get("Pragma"); got != "no-cache" {
+			t.Errorf("%s (%d): Pragma = %q, want no-cache", name, w.Code, got)
+		}
+	}
+}
+
+func TestEnforceAudience(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.audienceRules = map[string][]string{
+		"/admin/":      {"web"},
+		"/admin/jwt/":  {"ops"},
+		"/me/sessions": {"web", "mobile"},
+	}
+	h := app.enforceAudience(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	user := &data.User{ID: 7}
+
+	tests := []struct {
+		path string
+		user *data.User
+		aud  []string
+		want int
+	}{
+		{"/admin/users", user, []string{"web"}, http.StatusNoContent},
+		{"/admin/users", user, []string{"mobile"}, http.StatusForbidden},
+		{"/admin/users", user, nil, http.StatusForbidden},
+		// the longest prefix wins
+		{"/admin/jwt/rotate", user, []string{"web"}, http.StatusForbidden},
+		{"/admin/jwt/rotate", user, []string{"ops"}, http.StatusNoContent},
+		{"/me/sessions", user, []string{"other", "mobile"}, http.StatusNoContent},
+		{"/me", user, nil, http.StatusNoContent},
+		// anonymous requests are left to the route
+		{"/admin/users", data.AnonymousUser, nil, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		h.ServeHTTP(w, app.contextSetAudience(app.contextSetUser(r, tt.user), tt.aud))
+		if w.Code != tt.want {
+			t.Errorf("%s with aud %v: want %d, got %d", tt.path, tt.aud, tt.want, w.Code)
+		}
+	}
+}
+
+func TestVerifyOTPAudience(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.clientIDs = []string{"web"}
+	mock := mockDB(t, app)
+	expectLogin(mock, &data.User{ID: 7, PhoneNumber: testPhone}, false)
+	issueTestOTP(t, app, testPhone, "123456")
+
+	w := postJSON(app.handle(app.handleVerifyOTP), "/verify", `{"phone_number":"`+testPhone+`","otp":"123456","client_id":"mobile"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown client: want 400, got %d %s", w.Code, w.Body)
+	}
+
+	w = postJSON(app.handle(app.handleVerifyOTP), "/verify", `{"phone_number":"`+testPhone+`","otp":"123456","client_id":"web"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", w.Code, w.Body)
+	}
+	var claims authClaims
+	_, err := jwt.ParseWithClaims(decodeBody(t, w)["token"].(string), &claims, func(*jwt.Token) (interface{}, error) {
+		return app.jwtKeys.verificationKeys(), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(claims.Audience, jwt.ClaimStrings{"web"}) {
+		t.Errorf("aud = %v, want [web]", claims.Audience)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAuthenticateRejectsAnonymizedUsers(t *testing.T) {
+	app, _ := newTestApp(t)
+	mock := mockDB(t, app)
+	user := &data.User{ID: 7, PhoneNumber: "anonymized:0f1e2d3c", CreatedAt: time.Now()}
+	token, err := app.generateJWT(user.ID, "", time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectUserByID(mock, user)
+	w := httptest.NewRecorder()
+	app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("an anonymized user was authenticated")
+	})).ServeHTTP(w, authedRequest("/protected", token))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("want 401, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestTarpitDelay(t *testing.T) {
+	app, _ := newTestApp(t)
+	if d := app.tarpitDelay(10); d != 0 {
+		t.Fatalf("disabled tarpit delays %s", d)
+	}
+
+	app.conf.otp.tarpitBase = 100 * time.Millisecond
+	app.conf.otp.tarpitStep = time.Second
+	app.conf.otp.tarpitMax = 3 * time.Second
+	tests := map[int64]time.Duration{
+		0:  100 * time.Millisecond,
+		2:  2100 * time.Millisecond,
+		3:  3 * time.Second,
+		50: 3 * time.Second,
+	}
+	for attempts, want := range tests {
+		if got := app.tarpitDelay(attempts); got != want {
+			t.Errorf("tarpitDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+
+	// a client that gives up isn't held
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	app.tarpit(ctx, 50)
+	if took := time.Since(start); took > 100*time.Millisecond {
+		t.Fatalf("tarpit held a cancelled request for %s", took)
+	}
+}
+
+func TestVerifyTarpitGrowsWithFailures(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.otp.tarpitStep = 40 * time.Millisecond
+	app.conf.otp.tarpitMax = time.Second
+	issueTestOTP(t, app, testPhone, "123456")
+
+	verify := func(code string) time.Duration {
+		start := time.Now()
+		checkTestOTP(t, app, testPhone, code)
+		return time.Since(start)
+	}
+	if took := verify("000000"); took > 40*time.Millisecond {
+		t.Fatalf("first verify held for %s", took)
+	}
+	verify("000000")
+	if took := verify("123456"); took < 80*time.Millisecond {
+		t.Fatalf("verify after 2 failures took %s, want at least 80ms", took)
+	}
+}
+
+func TestAuthenticateNotBefore(t *testing.T) {
+	app, _ := newTestApp(t)
+	mock := mockDB(t, app)
+	user := &data.User{ID: 7, PhoneNumber: testPhone, CreatedAt: time.Now()}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	future, err := app.generateJWTNotBefore(user.ID, "", time.Now().Add(time.Hour), time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	app.authenticate(ok).ServeHTTP(w, authedRequest("/protected", future))
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "not valid yet") {
+		t.Fatalf("token before its nbf: got %d %s", w.Code, w.Body)
+	}
+
+	// tokens issued now carry an nbf and are usable right away
+	token, err := app.generateJWT(user.ID, "", time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return app.jwtKeys.verificationKeys(), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if claims["nbf"] == nil || claims["nbf"] != claims["iat"] {
+		t.Fatalf("nbf = %v, want iat %v", claims["nbf"], claims["iat"])
+	}
+	expectUserByID(mock, user)
+	w = httptest.NewRecorder()
+	app.authenticate(ok).ServeHTTP(w, authedRequest("/protected", token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("fresh token: got %d %s", w.Code, w.Body)
+	}
+}
+
+// countingReader records how many bytes were read from it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestLimitRequestBody(t *testing.T) {
+	app, _ := newTestApp(t)
+	reached := false
+	h := app.limitRequestBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	body := &countingReader{r: strings.NewReader(strings.Repeat("x", maxRequestBodyBytes+1))}
+	r := httptest.NewRequest(http.MethodPost, "/request", body)
+	r.ContentLength = maxRequestBodyBytes + 1
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge || reached || w.Header().Get("Connection") != "close" {
+		t.Fatalf("oversized Content-Length: got %d, handler reached %t", w.Code, reached)
+	}
+	if body.n != 0 {
+		t.Fatalf("read %d bytes of a rejected body", body.n)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/request", strings.NewReader(`{}`)))
+	if !reached {
+		t.Fatal("small body rejected")
+	}
+
+	// without a Content-Length readJSON still enforces the limit
+	r = httptest.NewRequest(http.MethodPost, "/request", io.MultiReader(strings.NewReader(`{"phone_number":"`),
+		strings.NewReader(strings.Repeat("9", maxRequestBodyBytes)), strings.NewReader(`"}`)))
+	r.ContentLength = -1
+	var dst struct {
+		PhoneNumber string `json:"phone_number"`
+	}
+	err := app.readJSON(httptest.NewRecorder(), r, &dst)
+	if err == nil || !strings.Contains(err.Error(), "must not be larger than") {
+		t.Fatalf("chunked oversized body: err = %v", err)
+	}
+}
+
+func TestAuthenticateLogsSigningMethodMismatch(t *testing.T) {
+	app, _ := newTestApp(t)
+	var out bytes.Buffer
+	app.logger = slog.New(slog.NewJSONHandler(&out, nil))
+	claims := jwt.MapClaims{"sub": "7", "exp": jwt.NewNumericDate(time.Now().Add(time.Hour))}
+
+	sign := func(method jwt.SigningMethod, key any) string {
+		t.Helper()
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs256 := sign(jwt.SigningMethodHS256, app.jwtKeys.signingKey())
+	unknown := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"XS999","typ":"JWT"}`)) +
+		hs256[strings.Index(hs256, "."):]
+	expired, err := app.generateJWT(7, "", -time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, token, level, msg, alg string
+	}{
+		{"none", sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType), "WARN", "jwt signing method mismatch", "none"},
+		{"RS256", sign(jwt.SigningMethodRS256, rsaKey), "WARN", "jwt signing method mismatch", "RS256"},
+		{"HS512", sign(jwt.SigningMethodHS512, app.jwtKeys.signingKey()), "WARN", "jwt signing method mismatch", "HS512"},
+		{"unknown alg", unknown, "WARN", "jwt signing method mismatch", "XS999"},
+		{"expired", expired, "INFO", "jwt expired", ""},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		w := httptest.NewRecorder()
+		app.authenticate(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			t.Errorf("%s: token accepted", tt.name)
+		})).ServeHTTP(w, authedRequest("/protected", tt.token))
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "Invalid or expired token") {
+			t.Errorf("%s: got %d %s", tt.name, w.Code, w.Body)
+		}
+
+		if strings.Contains(out.String(), tt.token) {
+			t.Errorf("%s: log %q contains the token", tt.name, out.String())
+		}
+		lines := logLines(t, &out)
+		if len(lines) != 1 {
+			t.Errorf("%s: got %d log lines, want 1", tt.name, len(lines))
+			continue
+		}
+		line := lines[0]
+		if line["level"] != tt.level || line["msg"] != tt.msg {
+			t.Errorf("%s: logged %v %q, want %s %q", tt.name, line["level"], line["msg"], tt.level, tt.msg)
+		}
+		if tt.alg != "" && line["alg"] != tt.alg {
+			t.Errorf("%s: alg = %v, want %s", tt.name, line["alg"], tt.alg)
+		}
+	}
+}
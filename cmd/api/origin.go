@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// otpOriginLogKey is a capped Redis list of recent OTP requests, newest
+// first, read by the fraud pipeline through /admin/otp/requests.
+const otpOriginLogKey = "otp_request_log"
+
+// otpOrigin is the metadata recorded for one OTP request.
+type otpOrigin struct {
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	Phone       string    `json:"phone"`
+	CountryCode string    `json:"country_code"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// two-digit country calling codes; 1 and 7 are the only one-digit ones and
+// every code not listed here has three digits (ITU-T E.164 assignments)
+var twoDigitCallingCodes = map[string]bool{
+	"20": true, "27": true, "30": true, "31": true, "32": true, "33": true,
+	"34": true, "36": true, "39": true, "40": true, "41": true, "43": true,
+	"44": true, "45": true, "46": true, "47": true, "48": true, "49": true,
+	"51": true, "52": true, "53": true, "54": true, "55": true, "56": true,
+	"57": true, "58": true, "60": true, "61": true, "62": true, "63": true,
+	"64": true, "65": true, "66": true, "81": true, "82": true, "84": true,
+	"86": true, "90": true, "91": true, "92": true, "93": true, "94": true,
+	"95": true, "98": true,
+}
+
+// callingCode returns the country calling code of an international number,
+// e.g. "+98" for "+989121234567", or "" when the number isn't in +E.164 form.
+func callingCode(phone string) string {
+	if !strings.HasPrefix(phone, "+") {
+		return ""
+	}
+	digits := phone[1:]
+	switch {
+	case len(digits) < 4:
+		return ""
+	case digits[0] == '1' || digits[0] == '7':
+		return "+" + digits[:1]
+	case twoDigitCallingCodes[digits[:2]]:
+		return "+" + digits[:2]
+	default:
+		return "+" + digits[:3]
+	}
+}
+
+// recordOTPOrigin appends the request's metadata to the origin log when
+// conf.otp.originLog is on. Failures are logged, never surfaced.
+func (app *application) recordOTPOrigin(ctx context.Context, r *http.Request, phone string) {
+	if !app.conf.otp.originLog {
+		return
+	}
+
+	entry, err := json.Marshal(otpOrigin{
+		IP:          app.clientIP(r),
+		UserAgent:   r.UserAgent(),
+		Phone:       phone,
+		CountryCode: callingCode(phone),
+		RequestedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), "Error encoding OTP origin", "error", err)
+		return
+	}
+
+	pipe := app.cache.TxPipeline()
+	pipe.LPush(ctx, otpOriginLogKey, entry)
+	pipe.LTrim(ctx, otpOriginLogKey, 0, int64(app.conf.otp.originLogSize)-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		app.logger.ErrorContext(r.Context(), "Error recording OTP origin", "error", err)
+	}
+}
+
+// recentOTPOrigins returns up to n of the newest origin records with the
+// phone numbers masked.
+func (app *application) recentOTPOrigins(ctx context.Context, n int) ([]otpOrigin, error) {
+	raw, err := app.cache.LRange(ctx, otpOriginLogKey, 0, int64(n)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	origins := make([]otpOrigin, 0, len(raw))
+	for _, s := range raw {
+		var o otpOrigin
+		if err := json.Unmarshal([]byte(s), &o); err != nil {
+			continue
+		}
+		o.Phone = maskPhone(o.Phone)
+		origins = append(origins, o)
+	}
+	return origins, nil
+}
+
+// otpOriginsForPhone returns every origin record still in the log for the
+// phone, newest first, with the phone masked.
+func (app *application) otpOriginsForPhone(ctx context.Context, phone string) ([]otpOrigin, error) {
+	raw, err := app.cache.LRange(ctx, otpOriginLogKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var origins []otpOrigin
+	for _, s := range raw {
+		var o otpOrigin
+		if err := json.Unmarshal([]byte(s), &o); err != nil || o.Phone != phone {
+			continue
+		}
+		o.Phone = maskPhone(o.Phone)
+		origins = append(origins, o)
+	}
+	return origins, nil
+}
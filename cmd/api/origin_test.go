@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCallingCode(t *testing.T) {
+	tests := map[string]string{
+		"+989121234567": "+98",
+		"+15551234567":  "+1",
+		"+79161234567":  "+7",
+		"+442079460958": "+44",
+		"+971501234567": "+971",
+		"989121234567":  "",
+		"+98":           "",
+	}
+	for phone, want := range tests {
+		if got := callingCode(phone); got != want {
+			t.Errorf("callingCode(%q) = %q, want %q", phone, got, want)
+		}
+	}
+}
+
+// originRequest is a /request for phone from ip with user agent ua.
+func originRequest(phone, ip, ua string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/request", strings.NewReader(`{"phone_number":"`+phone+`"}`))
+	r.RemoteAddr = ip + ":40000"
+	r.Header.Set("User-Agent", ua)
+	return r
+}
+
+func TestOTPOriginLog(t *testing.T) {
+	app, mr := newTestApp(t)
+	app.sms = &fakeSender{}
+
+	// off by default
+	app.handle(app.handleRequestOTP)(httptest.NewRecorder(), originRequest(testPhone, "203.0.113.1", "app/1"))
+	if mr.Exists(otpOriginLogKey) {
+		t.Fatal("origin recorded with the log disabled")
+	}
+
+	app.conf.otp.originLog = true
+	app.conf.otp.originLogSize = 2
+	for i, phone := range []string{"+989120000002", "+989120000003", "+989120000004"} {
+		ip := "203.0.113." + strconv.Itoa(2+i)
+		app.handle(app.handleRequestOTP)(httptest.NewRecorder(), originRequest(phone, ip, "app/2"))
+	}
+
+	w := httptest.NewRecorder()
+	withUser(app, testAdmin, app.handle(app.handleOTPOrigins))(w, httptest.NewRequest(http.MethodGet, "/admin/otp/requests", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", w.Code, w.Body)
+	}
+	requests, _ := decodeBody(t, w)["requests"].([]any)
+	if len(requests) != 2 {
+		t.Fatalf("requests = %v, want the newest 2", requests)
+	}
+	newest := requests[0].(map[string]any)
+	if newest["ip"] != "203.0.113.4" || newest["user_agent"] != "app/2" || newest["country_code"] != "+98" {
+		t.Errorf("newest = %v", newest)
+	}
+	if newest["phone"] != maskPhone("+989120000004") {
+		t.Errorf("phone = %v, want it masked", newest["phone"])
+	}
+
+	origins, err := app.otpOriginsForPhone(context.Background(), "+989120000003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(origins) != 1 || origins[0].IP != "203.0.113.3" {
+		t.Errorf("origins for one phone = %+v", origins)
+	}
+}
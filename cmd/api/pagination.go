@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// listEnvelopeKey is the top-level key list endpoints wrap their payload in;
+// legacyListEnvelopeKey is used instead when conf.legacyListEnvelope is set.
+const (
+	listEnvelopeKey       = "data"
+	legacyListEnvelopeKey = "response"
+)
+
+var errPaginationTooDeep = errors.New("pagination too deep, use cursor pagination")
+
+// Pagination describes the page returned by a list endpoint.
+type Pagination struct {
+	Page       int `json:"page"`
+	PageSize   int `json:"page_size"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+// parsePagination reads page and page_size from the query string, falling
+// back to the defaults for missing or invalid values. An oversized page_size
+// is capped, or rejected with an error when conf.strictPageSize is set.
+// Pages starting past conf.maxPageOffset rows are rejected.
+func (app *application) parsePagination(r *http.Request) (page, pageSize int, err error) {
+	qp := r.URL.Query()
+
+	page = atoiDefault(qp.Get("page"), 1)
+	if page < 1 {
+		page = 1
+	}
+	pageSize = atoiDefault(qp.Get("page_size"), defaultPageSize)
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		if app.conf.strictPageSize {
+			return 0, 0, fmt.Errorf("page_size exceeds maximum of %d", maxPageSize)
+		}
+		pageSize = maxPageSize
+	}
+	if limit := app.conf.maxPageOffset; limit > 0 && (page-1)*pageSize > limit {
+		return 0, 0, errPaginationTooDeep
+	}
+	return page, pageSize, nil
+}
+
+func paginationMeta(page, pageSize, total int) Pagination {
+	totalPages := 0
+	if pageSize > 0 {
+		totalPages = (total + pageSize - 1) / pageSize
+	}
+	return Pagination{
+		Page:       page,
+		PageSize:   pageSize,
+		Total:      total,
+		TotalPages: totalPages,
+	}
+}
+
+// listEnvelope wraps a list payload under the configured top-level key.
+func (app *application) listEnvelope(payload interface{}) envelope {
+	if app.conf.legacyListEnvelope {
+		return envelope{legacyListEnvelopeKey: payload}
+	}
+	return envelope{listEnvelopeKey: payload}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParsePagination(t *testing.T) {
+	app, _ := newTestApp(t)
+	tests := []struct {
+		query          string
+		page, pageSize int
+	}{
+		{"", 1, defaultPageSize},
+		{"page=3&page_size=10", 3, 10},
+		{"page=0&page_size=-5", 1, defaultPageSize},
+		{"page=x&page_size=y", 1, defaultPageSize},
+		{"page_size=1000", 1, maxPageSize},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/users?"+tt.query, nil)
+		page, pageSize, err := app.parsePagination(r)
+		if err != nil {
+			t.Errorf("%q: %v", tt.query, err)
+			continue
+		}
+		if page != tt.page || pageSize != tt.pageSize {
+			t.Errorf("%q: got page %d size %d, want %d and %d", tt.query, page, pageSize, tt.page, tt.pageSize)
+		}
+	}
+}
+
+func TestParsePaginationStrictPageSize(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.strictPageSize = true
+
+	r := httptest.NewRequest(http.MethodGet, "/users?page_size=100", nil)
+	if _, pageSize, err := app.parsePagination(r); err != nil || pageSize != maxPageSize {
+		t.Fatalf("page_size at the maximum: got %d, %v", pageSize, err)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/users?page_size=101", nil)
+	if _, _, err := app.parsePagination(r); err == nil {
+		t.Fatal("oversized page_size accepted in strict mode")
+	}
+
+	w := getUsers(app, "page_size=101")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("want 400, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestParsePaginationMaxOffset(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.maxPageOffset = 1000
+
+	tests := []struct {
+		query string
+		deep  bool
+	}{
+		{"page=11&page_size=100", false}, // starts at row 1000
+		{"page=12&page_size=100", true},
+		{"page=51&page_size=20", false},
+		{"page=52&page_size=20", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/users?"+tt.query, nil)
+		_, _, err := app.parsePagination(r)
+		if deep := errors.Is(err, errPaginationTooDeep); deep != tt.deep {
+			t.Errorf("%q: err = %v, want too deep %t", tt.query, err, tt.deep)
+		}
+	}
+
+	app.conf.maxPageOffset = 0
+	r := httptest.NewRequest(http.MethodGet, "/users?page=100000", nil)
+	if _, _, err := app.parsePagination(r); err != nil {
+		t.Errorf("limit disabled: %v", err)
+	}
+
+	app.conf.maxPageOffset = 1000
+	if w := getUsers(app, "page=52"); w.Code != http.StatusBadRequest {
+		t.Fatalf("too deep: want 400, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestPaginationMeta(t *testing.T) {
+	tests := []struct {
+		page, pageSize, total, totalPages int
+	}{
+		{1, 20, 0, 0},
+		{1, 20, 20, 1},
+		{2, 20, 21, 2},
+		{1, 0, 5, 0},
+	}
+	for _, tt := range tests {
+		got := paginationMeta(tt.page, tt.pageSize, tt.total)
+		want := Pagination{Page: tt.page, PageSize: tt.pageSize, Total: tt.total, TotalPages: tt.totalPages}
+		if got != want {
+			t.Errorf("paginationMeta(%d, %d, %d) = %+v, want %+v", tt.page, tt.pageSize, tt.total, got, want)
+		}
+	}
+}
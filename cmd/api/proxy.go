@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// parseTrustedProxies parses CIDRs (or bare IPs) of the reverse proxies
+// whose X-Forwarded-* headers are believed.
+func parseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if !strings.Contains(e, "/") {
+			addr, err := netip.ParseAddr(e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", e, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", e, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// remoteAddr returns the IP of the direct peer (not any forwarded client).
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// fromTrustedProxy reports whether the direct peer is a configured proxy.
+func (app *application) fromTrustedProxy(r *http.Request) bool {
+	addr, ok := remoteAddr(r)
+	if !ok {
+		return false
+	}
+	for _, p := range app.conf.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromInternalNetwork reports whether an unauthenticated request may skip
+// the guard of an allowInternal route: a GET or HEAD on an allowlisted route
+// whose direct peer is in conf.internalAccess.cidrs.
+func (app *application) fromInternalNetwork(r *http.Request) bool {
+	conf := app.conf.internalAccess
+	if len(conf.cidrs) == 0 || len(conf.routes) == 0 {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if !slices.ContainsFunc(conf.routes, func(route string) bool {
+		if strings.HasSuffix(route, "/") {
+			return strings.HasPrefix(r.URL.Path, route)
+		}
+		return r.URL.Path == route
+	}) {
+		return false
+	}
+	addr, ok := remoteAddr(r)
+	if !ok {
+		return false
+	}
+	for _, p := range conf.cidrs {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestScheme returns "https" or "http" for the original client request.
+// X-Forwarded-Proto is only honored when it comes from a trusted proxy.
+func (app *application) requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if app.fromTrustedProxy(r) {
+		// a proxy chain may append values; the first is the client-facing one
+		proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		if strings.EqualFold(strings.TrimSpace(proto), "https") {
+			return "https"
+		}
+	}
+	return "http"
+}
+
+// clientIP returns the originating client address: the first
+// X-Forwarded-For entry when the peer is a trusted proxy, else the peer.
+func (app *application) clientIP(r *http.Request) string {
+	if app.fromTrustedProxy(r) {
+		first, _, _ := strings.Cut(r.Header.Get("X-Forwarded-For"), ",")
+		if addr, err := netip.ParseAddr(strings.TrimSpace(first)); err == nil {
+			return addr.Unmap().String()
+		}
+	}
+	if addr, ok := remoteAddr(r); ok {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
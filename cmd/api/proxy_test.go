@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	got, err := parseTrustedProxies([]string{" 10.0.0.0/8", "", "127.0.0.1", "::1", "192.168.1.77/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "127.0.0.1/32", "::1/128", "192.168.1.0/24"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("entry %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "proxy.local"} {
+		if _, err := parseTrustedProxies([]string{bad}); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestSecureHeadersHSTSOnlyOverHTTPS(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	h := app.secureHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tt := range []struct {
+		name  string
+		peer  string
+		proto string
+		hsts  bool
+	}{
+		{"plain http", "203.0.113.7", "", false},
+		{"https via trusted proxy", "10.0.0.2", "https", true},
+		{"proxy chain, client-facing https", "10.0.0.2", "https, http", true},
+		{"spoofed header from untrusted peer", "203.0.113.7", "https", false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.peer + ":4321"
+		if tt.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if got := w.Header().Get("Strict-Transport-Security") != ""; got != tt.hsts {
+			t.Errorf("%s: HSTS sent = %v, want %v", tt.name, got, tt.hsts)
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: nosniff missing", tt.name)
+		}
+	}
+}
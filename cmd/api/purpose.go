@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// purposeLogin is the default OTP purpose, used by /verify. Its codes keep
+// the unqualified Redis key.
+const purposeLogin = "login"
+
+// otpPurposeConf overrides the code TTL and length for one OTP purpose;
+// zero values fall back to otpTTL and conf.otpLength.
+type otpPurposeConf struct {
+	ttl    time.Duration
+	length int
+}
+
+// parsePurpose validates a requested purpose, defaulting to login.
+func (app *application) parsePurpose(purpose string) (string, error) {
+	if purpose == "" {
+		return purposeLogin, nil
+	}
+	if _, ok := app.conf.otpPurposes[purpose]; !ok {
+		return "", fmt.Errorf("unsupported purpose %q", purpose)
+	}
+	return purpose, nil
+}
+
+// otpPurposeTTL is how long codes issued for purpose live.
+func (app *application) otpPurposeTTL(purpose string) time.Duration {
+	if p := app.conf.otpPurposes[purpose]; p.ttl > 0 {
+		return p.ttl
+	}
+	return otpTTL
+}
+
+// otpPurposeLength is the number of digits in codes issued for purpose.
+func (app *application) otpPurposeLength(purpose string) int {
+	if p := app.conf.otpPurposes[purpose]; p.length > 0 {
+		return p.length
+	}
+	return app.conf.otpLength
+}
+
+// parseOTPPurposes reads "name=ttl[:length]" entries separated by commas,
+// e.g. "phone_change=5m:6,step_up=1m".
+func parseOTPPurposes(s string) (map[string]otpPurposeConf, error) {
+	purposes := map[string]otpPurposeConf{}
+	for _, entry := range splitList(s) {
+		name, spec, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("purpose %q: expected name=ttl[:length]", entry)
+		}
+		ttlSpec, lengthSpec, hasLength := strings.Cut(spec, ":")
+
+		var p otpPurposeConf
+		ttl, err := parseDuration(name, ttlSpec)
+		if err != nil {
+			return nil, err
+		}
+		p.ttl = ttl
+		if hasLength {
+			if p.length, err = strconv.Atoi(strings.TrimSpace(lengthSpec)); err != nil {
+				return nil, fmt.Errorf("purpose %q: length %q is not a number", name, lengthSpec)
+			}
+		}
+		purposes[name] = p
+	}
+	return purposes, nil
+}
+
+// validateOTPPurposes checks every configured purpose against the same
+// bounds as the defaults.
+func validateOTPPurposes(purposes map[string]otpPurposeConf) error {
+	if _, ok := purposes[purposeLogin]; !ok {
+		return fmt.Errorf("otp purpose %q must be configured", purposeLogin)
+	}
+	for name, p := range purposes {
+		if p.length != 0 && !slices.Contains(otpLengths, p.length) {
+			return fmt.Errorf("otp purpose %q: length must be one of %v, got %d", name, otpLengths, p.length)
+		}
+	}
+	return nil
+}
+
+// purposeNames lists the configured purposes in sorted order.
+func purposeNames(purposes map[string]otpPurposeConf) []string {
+	names := make([]string, 0, len(purposes))
+	for name := range purposes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
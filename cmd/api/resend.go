@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+)
+
+func otpResendsKey(phone string) string {
+	return otpKeyPrefix(phone) + ":resends"
+}
+
+// countOTPResend adds one to the phone's resend counter, whose window starts
+// with the first resend, and returns the new count and the window's
+// remaining time.
+func (app *application) countOTPResend(ctx context.Context, phone string) (int64, time.Duration, error) {
+	key := otpResendsKey(phone)
+	ttlSec := int64(app.conf.otp.resendWindow / time.Second)
+	n, err := otpAttemptsScript.Run(ctx, app.cache, []string{key}, ttlSec).Int64()
+	if err != nil {
+		return 0, 0, err
+	}
+	ttl, err := app.cache.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	return n, max(ttl, 0), nil
+}
+
+// handleResendOTP godoc
+// @Summary     Resend OTP
+// @Description Replaces the pending OTP for phone_number with a new code and sends it again. Allowed once the resend cooldown has passed and, when otp.maxResends is set, at most that many times per resend window. Without a pending code, use /request.
+// @Tags        Auth
+// @Accept      json
+// @Produce     json
+// @Param       payload body     requestOTPReq true "OTP resend payload"
+// @Success     200     {object} map[string]interface{} "success/message/resend_available_in, plus resends_left when resends are capped"
+// @Failure     400     {object} map[string]string     "error"
+// @Failure     422     {object} map[string]string     "invalid phone number"
+// @Failure     429     {object} map[string]interface{} "error/retry_after"
+// @Failure     500     {object} map[string]string     "error"
+// @Failure     503     {object} map[string]string     "error"
+// @Header      200,429 {integer} RateLimit-Limit     "requests allowed per window"
+// @Header      200,429 {integer} RateLimit-Remaining "requests left in the window"
+// @Header      200,429 {integer} RateLimit-Reset     "seconds until the window resets"
+// @Router      /resend [post]
+func (app *application) handleResendOTP(w http.ResponseWriter, r *http.Request) error {
+	var input struct {
+		PhoneNumber string `json:"phone_number"`
+		Channel     string `json:"channel"`
+		Purpose     string `json:"purpose"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		return badRequest("Invalid request payload")
+	}
+	phone, err := data.ValidatePhoneNumber(input.PhoneNumber)
+	if err != nil {
+		return invalidPhone(err)
+	}
+	input.PhoneNumber = phone
+	channel, err := parseChannel(input.Channel)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	purpose, err := app.parsePurpose(input.Purpose)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 3*time.Second)
+	defer cancel()
+
+	pending, err := app.cache.Exists(ctx, otpCodeKey(input.PhoneNumber, purpose)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to look up pending OTP: %w", err)
+	}
+	if pending == 0 {
+		return badRequest("No pending OTP for this phone number. Request a new one.")
+	}
+
+	cooldown, err := app.otpCooldownRemaining(ctx, input.PhoneNumber)
+	if err != nil {
+		return fmt.Errorf("failed to read OTP cooldown: %w", err)
+	}
+	if cooldown > 0 {
+		return tooManyRequests("Please wait before requesting a new OTP.", cooldown)
+	}
+
+	// resends still count against the overall per-phone request limit;
+	// checked first so a rate-limited resend doesn't use up the cap
+	limit, err := app.allowOTPRequest(ctx, input.PhoneNumber)
+	if err != nil {
+		return fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	limit.setHeaders(w)
+	if !limit.allowed {
+		return tooManyRequests("Too many OTP requests. Please try again later.", limit.resetIn)
+	}
+
+	resp := envelope{
+		"success":             true,
+		"message":             "OTP resent",
+		"resend_available_in": ceilSeconds(app.conf.otp.resendCooldown),
+	}
+	if maxResends := int64(app.conf.otp.maxResends); maxResends > 0 {
+		resends, window, err := app.countOTPResend(ctx, input.PhoneNumber)
+		if err != nil {
+			return fmt.Errorf("failed to count resend: %w", err)
+		}
+		if resends > maxResends {
+			return tooManyRequests("Too many resends. Please try again later.", window)
+		}
+		resp["message"] = fmt.Sprintf("OTP resent (%d of %d)", resends, maxResends)
+		resp["resends_left"] = maxResends - resends
+	}
+
+	if err := app.issueOTP(r, input.PhoneNumber, purpose, channel); err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, http.StatusOK, resp, nil)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postResend calls handleResendOTP for phone.
+func postResend(app *application, phone string) *httptest.ResponseRecorder {
+	return postJSON(app.handle(app.handleResendOTP), "/resend", `{"phone_number":"`+phone+`"}`)
+}
+
+func TestResendOTPNeedsPendingCode(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	if w := postResend(app, testPhone); w.Code != http.StatusBadRequest {
+		t.Fatalf("resend without a pending code: want 400, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestResendOTPCooldown(t *testing.T) {
+	app, mr := newTestApp(t)
+	issueTestOTP(t, app, testPhone, "123456")
+
+	if w := postResend(app, testPhone); w.Code != http.StatusOK {
+		t.Fatalf("first resend: want 200, got %d %s", w.Code, w.Body)
+	}
+
+	w := postResend(app, testPhone)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("resend inside the cooldown: want 429, got %d %s", w.Code, w.Body)
+	}
+	retry, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retry <= 0 || retry > int(app.conf.otp.resendCooldown/time.Second) {
+		t.Fatalf("Retry-After = %q, want 1..%d", w.Header().Get("Retry-After"), int(app.conf.otp.resendCooldown/time.Second))
+	}
+
+	mr.FastForward(app.conf.otp.resendCooldown)
+	if w := postResend(app, testPhone); w.Code != http.StatusOK {
+		t.Fatalf("resend after the cooldown: want 200, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestResendOTPCap(t *testing.T) {
+	app, mr := newTestApp(t)
+	app.conf.otp.maxResends = 2
+	issueTestOTP(t, app, testPhone, "123456")
+
+	for i := 1; i <= app.conf.otp.maxResends; i++ {
+		w := postResend(app, testPhone)
+		if w.Code != http.StatusOK {
+			t.Fatalf("resend %d: want 200, got %d %s", i, w.Code, w.Body)
+		}
+		if got, want := decodeBody(t, w)["resends_left"], float64(app.conf.otp.maxResends-i); got != want {
+			t.Fatalf("resend %d: resends_left = %v, want %v", i, got, want)
+		}
+		mr.FastForward(app.conf.otp.resendCooldown)
+	}
+
+	w := postResend(app, testPhone)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("resend over the cap: want 429, got %d %s", w.Code, w.Body)
+	}
+	if !strings.Contains(decodeBody(t, w)["error"].(string), "Too many resends") {
+		t.Fatalf("resend over the cap: body = %s", w.Body)
+	}
+
+	// the cap lifts once the resend window, which began with the first
+	// resend, runs out
+	mr.FastForward(app.conf.otp.resendWindow)
+	issueTestOTP(t, app, testPhone, "123456")
+	if w := postResend(app, testPhone); w.Code != http.StatusOK {
+		t.Fatalf("resend in a new window: want 200, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestResendOTPUncappedWhenMaxIsZero(t *testing.T) {
+	app, mr := newTestApp(t)
+	app.conf.otp.maxResends = 0
+	app.conf.otp.resendWindow = 0
+	issueTestOTP(t, app, testPhone, "123456")
+
+	for i := 1; i <= 2; i++ {
+		w := postResend(app, testPhone)
+		if w.Code != http.StatusOK {
+			t.Fatalf("resend %d: want 200, got %d %s", i, w.Code, w.Body)
+		}
+		if _, ok := decodeBody(t, w)["resends_left"]; ok {
+			t.Fatalf("resend %d: uncapped resends report resends_left: %s", i, w.Body)
+		}
+		mr.FastForward(app.conf.otp.resendCooldown)
+	}
+	if mr.Exists(otpResendsKey(testPhone)) {
+		t.Fatal("uncapped resends were counted")
+	}
+}
+
+func TestResendOTPRateLimitedBeforeCounting(t *testing.T) {
+	app, mr := newTestApp(t)
+	issueTestOTP(t, app, testPhone, "123456")
+	for i := 0; i < app.conf.otp.rateLimitMax; i++ {
+		if _, err := app.allowOTPRequest(context.Background(), testPhone); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := postResend(app, testPhone)
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(decodeBody(t, w)["error"].(string), "Too many OTP requests") {
+		t.Fatalf("resend over the request limit: want 429, got %d %s", w.Code, w.Body)
+	}
+	if mr.Exists(otpResendsKey(testPhone)) {
+		t.Fatal("a rate-limited resend used up the resend cap")
+	}
+}
+
+func TestRequestOTPSurfacesResendCooldown(t *testing.T) {
+	app, mr := newTestApp(t)
+	app.sms = &fakeSender{}
+	cooldown := float64(app.conf.otp.resendCooldown / time.Second)
+	body := `{"phone_number":"` + testPhone + `"}`
+
+	w := postJSON(app.handle(app.handleRequestOTP), "/request", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("first request: want 200, got %d %s", w.Code, w.Body)
+	}
+	if got := decodeBody(t, w)["resend_available_in"]; got != cooldown {
+		t.Fatalf("resend_available_in = %v, want %v", got, cooldown)
+	}
+
+	w = postJSON(app.handle(app.handleRequestOTP), "/request", body)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request inside the cooldown: want 429, got %d %s", w.Code, w.Body)
+	}
+	if got, _ := decodeBody(t, w)["resend_available_in"].(float64); got <= 0 || got > cooldown {
+		t.Fatalf("resend_available_in = %v, want 1..%v", got, cooldown)
+	}
+
+	mr.FastForward(app.conf.otp.resendCooldown)
+	if w := postJSON(app.handle(app.handleRequestOTP), "/request", body); w.Code != http.StatusOK {
+		t.Fatalf("request after the cooldown: want 200, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestPreviousCodeGrace(t *testing.T) {
+	tests := []struct {
+		name    string
+		grace   time.Duration
+		expired bool
+		code    string
+		ok      bool
+	}{
+		{"new code", time.Minute, false, "222222", true},
+		{"previous code in grace", time.Minute, false, "111111", true},
+		{"previous code after grace", time.Minute, true, "111111", false},
+		{"previous code without grace", 0, false, "111111", false},
+	}
+	for _, tt := range tests {
+		app, mr := newTestApp(t)
+		app.conf.otp.previousCodeGrace = tt.grace
+		issueTestOTP(t, app, testPhone, "111111")
+		issueTestOTP(t, app, testPhone, "222222")
+		if tt.expired {
+			mr.HSet(otpCodeKey(testPhone, purposeLogin), "prev_until", "1")
+		}
+
+		w := checkTestOTP(t, app, testPhone, tt.code)
+		if ok := w == nil; ok != tt.ok {
+			t.Errorf("%s: accepted = %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+}
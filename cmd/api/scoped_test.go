@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"Go-OTP-Login/internal/data"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestVerifyOTPScopedIssuesScopedToken(t *testing.T) {
+	app, _ := newTestApp(t)
+	mock := mockDB(t, app)
+	mock.ExpectQuery(`INSERT INTO users`).
+		WithArgs(testPhone, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "phone_number", "name", "inserted"}).
+			AddRow(7, testSessionUser.CreatedAt, testPhone, "", false))
+	issueTestOTP(t, app, testPhone, "123456")
+
+	if w := postJSON(app.handle(app.handleVerifyOTPScoped), "/verify/scoped",
+		`{"phone_number":"`+testPhone+`","otp":"123456","scope":"admin"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown scope: want 400, got %d %s", w.Code, w.Body)
+	}
+
+	w := postJSON(app.handle(app.handleVerifyOTPScoped), "/verify/scoped",
+		`{"phone_number":"`+testPhone+`","otp":"123456","scope":"phone_verify"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", w.Code, w.Body)
+	}
+	body := decodeBody(t, w)
+	if _, ok := body["refresh_token"]; ok {
+		t.Error("scoped verify opened a session")
+	}
+
+	var claims authClaims
+	_, err := jwt.ParseWithClaims(body["token"].(string), &claims, func(*jwt.Token) (interface{}, error) {
+		return app.jwtKeys.verificationKeys(), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Scope != scopePhoneVerify {
+		t.Errorf("scope claim = %q, want %q", claims.Scope, scopePhoneVerify)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyOTPScopedOnlyLoginCreatesUsers(t *testing.T) {
+	const newPhone = "+989120000002"
+	expectLookup := func(mock sqlmock.Sqlmock, found bool) {
+		rows := sqlmock.NewRows([]string{"id", "created_at", "phone_number", "name"})
+		if found {
+			rows.AddRow(testSessionUser.ID, testSessionUser.CreatedAt, testPhone, "")
+		}
+		mock.ExpectQuery(`FROM users\s+WHERE phone_number = \$1`).WithArgs(testPhone).WillReturnRows(rows)
+	}
+
+	tests := []struct {
+		name    string
+		phone   string
+		purpose string
+		user    *data.User
+		scope   string
+		expect  func(sqlmock.Sqlmock)
+		want    int
+		wantSub int64
+	}{
+		{"existing account", testPhone, "step_up", data.AnonymousUser, "",
+			func(m sqlmock.Sqlmock) { expectLookup(m, true) }, http.StatusOK, testSessionUser.ID},
+		{"unknown number", testPhone, "step_up", data.AnonymousUser, "",
+			func(m sqlmock.Sqlmock) { expectLookup(m, false) }, http.StatusNotFound, 0},
+		{"new number for the signed-in user", newPhone, "phone_change", testSessionUser, "",
+			func(sqlmock.Sqlmock) {}, http.StatusOK, testSessionUser.ID},
+		{"scoped token", newPhone, "phone_change", testSessionUser, scopePhoneVerify,
+			func(sqlmock.Sqlmock) {}, http.StatusUnauthorized, 0},
+	}
+	for _, tt := range tests {
+		app, _ := newTestApp(t)
+		mock := mockDB(t, app)
+		tt.expect(mock)
+		err := app.storeOTPInRedis(context.Background(), tt.phone, tt.purpose, channelSMS, "123456", otpTTL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/verify/scoped", strings.NewReader(
+			`{"phone_number":"`+tt.phone+`","otp":"123456","scope":"phone_verify","purpose":"`+tt.purpose+`"}`))
+		app.handle(app.handleVerifyOTPScoped)(w, app.contextSetScope(app.contextSetUser(r, tt.user), tt.scope))
+		if w.Code != tt.want {
+			t.Errorf("%s: want %d, got %d %s", tt.name, tt.want, w.Code, w.Body)
+			continue
+		}
+		// no user is ever inserted, which sqlmock would reject
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.want != http.StatusOK {
+			continue
+		}
+
+		var claims struct {
+			jwt.RegisteredClaims
+			PhoneNumber string `json:"phone_number"`
+		}
+		token := decodeBody(t, w)["token"].(string)
+		if _, _, err := new(jwt.Parser).ParseUnverified(token, &claims); err != nil {
+			t.Fatal(err)
+		}
+		if claims.Subject != strconv.FormatInt(tt.wantSub, 10) || claims.PhoneNumber != tt.phone {
+			t.Errorf("%s: token for user %s phone %q, want %d %q", tt.name, claims.Subject, claims.PhoneNumber, tt.wantSub, tt.phone)
+		}
+	}
+}
+
+func TestCheckPhoneVerificationReportsVerifiedNumber(t *testing.T) {
+	app, _ := newTestApp(t)
+	const newPhone = "+989120000002"
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/verify/scoped/check", nil)
+	r = app.contextSetVerifiedPhone(app.contextSetUser(r, testSessionUser), newPhone)
+	app.handle(app.handleCheckPhoneVerification)(w, r)
+	if got := decodeBody(t, w)["phone_number"]; got != newPhone {
+		t.Fatalf("phone_number = %v, want the verified %s", got, newPhone)
+	}
+}
+
+func TestScopedTokensOnlyOpenTheirRoutes(t *testing.T) {
+	app, _ := newTestApp(t)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	user := &data.User{ID: 7, PhoneNumber: testPhone}
+
+	tests := []struct {
+		name  string
+		h     http.HandlerFunc
+		scope string
+		user  *data.User
+		want  int
+	}{
+		{"session token on session route", app.requireAuthenticatedUser(ok), "", user, http.StatusNoContent},
+		{"scoped token on session route", app.requireAuthenticatedUser(ok), scopePhoneVerify, user, http.StatusForbidden},
+		{"scoped token on its route", app.requireScope(scopePhoneVerify, ok), scopePhoneVerify, user, http.StatusNoContent},
+		{"session token on scoped route", app.requireScope(scopePhoneVerify, ok), "", user, http.StatusForbidden},
+		{"other scope on scoped route", app.requireScope(scopePhoneVerify, ok), "email_verify", user, http.StatusForbidden},
+		{"anonymous on scoped route", app.requireScope(scopePhoneVerify, ok), "", data.AnonymousUser, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/verify/scoped/check", nil)
+		tt.h(w, app.contextSetScope(app.contextSetUser(r, tt.user), tt.scope))
+		if w.Code != tt.want {
+			t.Errorf("%s: want %d, got %d %s", tt.name, tt.want, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// SessionsListResponse is the payload returned for session listing.
+type SessionsListResponse struct {
+	Items []data.Token `json:"items"`
+	Pagination
+}
+
+// SessionsListResponseEnvelope is used only for Swagger to document the envelope shape.
+type SessionsListResponseEnvelope struct {
+	Data SessionsListResponse `json:"data"`
+}
+
+// handleListSessions godoc
+// @Summary      List my sessions
+// @Description  Paginated list of the authenticated user's active sessions (refresh tokens).
+// @Tags         Sessions
+// @Produce      json
+// @Param        page       query     int     false  "Page number (1-based, default 1)"
+// @Param        page_size  query     int     false  "Page size (max 100, default 20)"
+// @Success      200  {object}  map[string]SessionsListResponseEnvelope  "envelope with 'data' key ('response' in legacy mode)"
+// @Failure      400  {object}  map[string]string  "page_size exceeds maximum (strict mode) or pagination too deep"
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string  "failed to fetch sessions"
+// @Security     BearerAuth
+// @Router       /me/sessions [get]
+func (app *application) handleListSessions(w http.ResponseWriter, r *http.Request) error {
+	user := app.contextGetUser(r)
+	page, pageSize, err := app.parsePagination(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	tokens, total, err := app.models.Token.GetAllForUser(ctx, user.ID, page, pageSize)
+	if err != nil {
+		return fmt.Errorf("failed to fetch sessions: %w", err)
+	}
+
+	resp := SessionsListResponse{
+		Items:      tokens,
+		Pagination: paginationMeta(page, pageSize, total),
+	}
+	return app.writeJSON(w, http.StatusOK, app.listEnvelope(resp), nil)
+}
+
+// handleRevokeSession godoc
+// @Summary      Revoke one session
+// @Description  Revokes a single session of the authenticated user, leaving the others signed in.
+// @Tags         Sessions
+// @Produce      json
+// @Param        id   path      int  true  "Session ID"
+// @Success      200  {object}  map[string]interface{}  "success/message"
+// @Failure      400  {object}  map[string]string  "invalid session id"
+// @Failure      401  {object}  map[string]string
+// @Failure      404  {object}  map[string]string  "error/code: not_found"
+// @Failure      500  {object}  map[string]string  "error/code: internal_error"
+// @Security     BearerAuth
+// @Router       /me/sessions/{id} [delete]
+func (app *application) handleRevokeSession(w http.ResponseWriter, r *http.Request) error {
+	user := app.contextGetUser(r)
+
+	ps := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+	if err != nil || id < 1 {
+		return badRequest("invalid session id")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	// scoped to the user, so another user's session id is simply not found
+	if err := app.models.Token.DeleteForUser(ctx, id, user.ID); err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{
+		"success": true,
+		"message": "Session revoked",
+	}, nil)
+}
+
+type rotateRefreshReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// handleRotateRefreshToken godoc
+// @Summary      Rotate my refresh token
+// @Description  Invalidates the presented refresh token and issues a new one with a fresh lifetime. Other sessions are not affected.
+// @Tags         Sessions
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      rotateRefreshReq  true  "Current refresh token"
+// @Success      200  {object}  map[string]interface{}  "success/refresh_token/expiry"
+// @Failure      400  {object}  map[string]string  "invalid body"
+// @Failure      401  {object}  map[string]string  "invalid or expired refresh token"
+// @Failure      500  {object}  map[string]string  "failed to rotate refresh token"
+// @Security     BearerAuth
+// @Router       /me/refresh/rotate [post]
+func (app *application) handleRotateRefreshToken(w http.ResponseWriter, r *http.Request) error {
+	user := app.contextGetUser(r)
+
+	var input rotateRefreshReq
+	if err := app.readJSON(w, r, &input); err != nil || input.RefreshToken == "" {
+		return badRequest("refresh_token is required")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	token, err := app.models.Token.Rotate(ctx, input.RefreshToken, user.ID, app.conf.refreshTokenTTL)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return &apiError{Status: http.StatusUnauthorized, Code: "invalid_refresh_token",
+				Message: "Invalid or expired refresh token"}
+		}
+		return fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	app.logger.InfoContext(r.Context(), "audit: rotated refresh token", "user_id", user.ID, "session_id", token.ID)
+
+	return app.writeJSON(w, http.StatusOK, envelope{
+		"success":       true,
+		"refresh_token": token.Plaintext,
+		"expiry":        token.Expiry,
+	}, nil)
+}
+
+type refreshReq struct {
+	RefreshToken string `json:"refresh_token"`
+	// optional; must be a known client, as on /verify
+	ClientID string `json:"client_id"`
+}
+
+// handleRefresh godoc
+// @Summary      Refresh the access token
+// @Description  Exchanges a refresh token for a new access JWT without another OTP. The JWT lives for OTP_REFRESH_ACCESS_TTL (15 minutes by default). The refresh token is single-use: it is replaced by the returned one, and presenting it again fails.
+// @Tags         Sessions
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      refreshReq  true  "Refresh token from /verify or an earlier /refresh"
+// @Success      200  {object}  map[string]interface{}  "success/token/refresh_token/expiry"
+// @Failure      400  {object}  map[string]string  "invalid body or unknown client_id"
+// @Failure      401  {object}  map[string]string  "invalid or expired refresh token"
+// @Failure      500  {object}  map[string]string  "failed to refresh session"
+// @Router       /refresh [post]
+func (app *application) handleRefresh(w http.ResponseWriter, r *http.Request) error {
+	var input refreshReq
+	if err := app.readJSON(w, r, &input); err != nil || input.RefreshToken == "" {
+		return badRequest("refresh_token is required")
+	}
+	if input.ClientID != "" && !app.isKnownClient(input.ClientID) {
+		return badRequest("Unknown client_id")
+	}
+
+	invalid := &apiError{Status: http.StatusUnauthorized, Code: "invalid_refresh_token",
+		Message: "Invalid or expired refresh token"}
+
+	user, err := app.models.User.GetForToken(input.RefreshToken)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return invalid
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up refresh token: %w", err)
+	}
+	if user.IsAnonymized() {
+		return invalid
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	// a concurrent refresh with the same token loses here, so each token
+	// is honored once
+	token, err := app.models.Token.Rotate(ctx, input.RefreshToken, user.ID, app.conf.refreshTokenTTL)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return invalid
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	jwtToken, err := app.generateJWT(user.ID, input.ClientID, app.conf.refreshAccessTTL, app.userClaims(user))
+	if err != nil {
+		return fmt.Errorf("failed to generate JWT for user %d: %w", user.ID, err)
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{
+		"success":       true,
+		"token":         jwtToken,
+		"refresh_token": token.Plaintext,
+		"expiry":        token.Expiry,
+	}, nil)
+}
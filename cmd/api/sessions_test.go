@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/julienschmidt/httprouter"
+)
+
+var testSessionUser = &data.User{ID: 7, PhoneNumber: testPhone}
+
+// withParams adds httprouter path params to r, as the router would.
+func withParams(r *http.Request, ps ...httprouter.Param) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params(ps)))
+}
+
+func TestListSessionsPaginates(t *testing.T) {
+	app, _ := newTestApp(t)
+	mock := mockDB(t, app)
+	mock.ExpectQuery(`SELECT id, user_id, created_at, expiry, COUNT\(\*\) OVER\(\) AS total_count\s+FROM tokens`).
+		WithArgs(testSessionUser.ID, sqlmock.AnyArg(), 2, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "created_at", "expiry", "total_count"}).
+			AddRow(3, 7, time.Now(), time.Now().Add(time.Hour), 5).
+			AddRow(2, 7, time.Now(), time.Now().Add(time.Hour), 5))
+
+	w := httptest.NewRecorder()
+	h := withUser(app, testSessionUser, app.handle(app.handleListSessions))
+	h(w, httptest.NewRequest(http.MethodGet, "/me/sessions?page=2&page_size=2", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", w.Code, w.Body)
+	}
+	resp := decodeBody(t, w)["data"].(map[string]any)
+	if items := resp["items"].([]any); len(items) != 2 {
+		t.Errorf("items = %v, want the page's two sessions", items)
+	}
+	if resp["total"] != float64(5) || resp["page"] != float64(2) {
+		t.Errorf("pagination = %v, want page 2 of 5 sessions", resp)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListSessionsLegacyEnvelope(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		app, _ := newTestApp(t)
+		app.conf.legacyListEnvelope = legacy
+		mock := mockDB(t, app)
+		mock.ExpectQuery(`FROM tokens`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "created_at", "expiry", "total_count"}))
+
+		w := httptest.NewRecorder()
+		withUser(app, testSessionUser, app.handle(app.handleListSessions))(w, httptest.NewRequest(http.MethodGet, "/me/sessions", nil))
+		body := decodeBody(t, w)
+
+		key, other := listEnvelopeKey, legacyListEnvelopeKey
+		if legacy {
+			key, other = other, key
+		}
+		if _, ok := body[key]; !ok {
+			t.Errorf("legacy=%v: body %v lacks %q", legacy, body, key)
+		}
+		if _, ok := body[other]; ok {
+			t.Errorf("legacy=%v: body %v also has %q", legacy, body, other)
+		}
+	}
+}
+
+func TestRevokeSessionIsScopedToUser(t *testing.T) {
+	app, _ := newTestApp(t)
+	mock := mockDB(t, app)
+	h := withUser(app, testSessionUser, app.handle(app.handleRevokeSession))
+	revoke := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, withParams(httptest.NewRequest(http.MethodDelete, "/me/sessions/"+id, nil), httprouter.Param{Key: "id", Value: id}))
+		return w
+	}
+
+	mock.ExpectExec(`DELETE FROM tokens WHERE id = \$1 AND user_id = \$2`).
+		WithArgs(3, testSessionUser.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	if w := revoke("3"); w.Code != http.StatusOK {
+		t.Fatalf("own session: want 200, got %d %s", w.Code, w.Body)
+	}
+
+	// another user's session matches no row
+	mock.ExpectExec(`DELETE FROM tokens WHERE id = \$1 AND user_id = \$2`).
+		WithArgs(4, testSessionUser.ID).WillReturnResult(sqlmock.NewResult(0, 0))
+	if w := revoke("4"); w.Code != http.StatusNotFound {
+		t.Fatalf("other user's session: want 404, got %d %s", w.Code, w.Body)
+	}
+
+	if w := revoke("abc"); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad id: want 400, got %d %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyOTPIssuesRefreshToken(t *testing.T) {
+	app, _ := newTestApp(t)
+	mock := mockDB(t, app)
+	expectLogin(mock, testSessionUser, false)
+	issueTestOTP(t, app, testPhone, "123456")
+
+	w := postJSON(app.handle(app.handleVerifyOTP), "/verify", `{"phone_number":"`+testPhone+`","otp":"123456"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", w.Code, w.Body)
+	}
+	if token, _ := decodeBody(t, w)["refresh_token"].(string); token == "" {
+		t.Fatal("verify returned no refresh_token")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	app, _ := newTestApp(t)
+	mock := mockDB(t, app)
+	h := withUser(app, testSessionUser, app.handle(app.handleRotateRefreshToken))
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM tokens WHERE hash = \$1 AND user_id = \$2`).
+		WithArgs(sqlmock.AnyArg(), testSessionUser.ID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO tokens`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(8, time.Now()))
+	mock.ExpectCommit()
+	w := postJSON(h, "/me/refresh/rotate", `{"refresh_token":"live"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("live token: want 200, got %d %s", w.Code, w.Body)
+	}
+	if token, _ := decodeBody(t, w)["refresh_token"].(string); token == "" || token == "live" {
+		t.Errorf("refresh_token = %q, want a new token", token)
+	}
+
+	// expired, revoked or another user's token deletes nothing
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM tokens`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if w := postJSON(h, "/me/refresh/rotate", `{"refresh_token":"stale"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("stale token: want 401, got %d %s", w.Code, w.Body)
+	}
+
+	if w := postJSON(h, "/me/refresh/rotate", `{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("missing token: want 400, got %d %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// expectTokenOwner answers GetForToken with testSessionUser.
+func expectTokenOwner(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`UPDATE tokens SET last_used_at = NOW\(\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "phone_number", "name"}).
+			AddRow(testSessionUser.ID, time.Now(), testSessionUser.PhoneNumber, ""))
+}
+
+func TestRefresh(t *testing.T) {
+	app, _ := newTestApp(t)
+	mock := mockDB(t, app)
+	h := app.handle(app.handleRefresh)
+
+	expectTokenOwner(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM tokens WHERE hash = \$1 AND user_id = \$2`).
+		WithArgs(sqlmock.AnyArg(), testSessionUser.ID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO tokens`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(8, time.Now()))
+	mock.ExpectCommit()
+	w := postJSON(h, "/refresh", `{"refresh_token":"live"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("live token: want 200, got %d %s", w.Code, w.Body)
+	}
+	body := decodeBody(t, w)
+	if token, _ := body["refresh_token"].(string); token == "" || token == "live" {
+		t.Errorf("refresh_token = %q, want a new token", token)
+	}
+	jwtToken, _ := body["token"].(string)
+	if err := verifyWith(app, jwtToken); err != nil {
+		t.Errorf("token: %v", err)
+	}
+	claims := &authClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(jwtToken, claims); err != nil || claims.ExpiresAt == nil {
+		t.Fatalf("token claims: %v", err)
+	}
+	// the refresh exchange issues a short-lived access token, not a session one
+	wantExp := time.Now().Add(app.conf.refreshAccessTTL)
+	if exp := claims.ExpiresAt.Time; exp.Before(wantExp.Add(-time.Minute)) || exp.After(wantExp.Add(time.Minute)) {
+		t.Errorf("token exp = %v, want about %v", exp, wantExp)
+	}
+
+	// unknown or expired
+	mock.ExpectQuery(`UPDATE tokens SET last_used_at = NOW\(\)`).WillReturnError(sql.ErrNoRows)
+	w = postJSON(h, "/refresh", `{"refresh_token":"unknown"}`)
+	if w.Code != http.StatusUnauthorized || decodeBody(t, w)["code"] != "invalid_refresh_token" {
+		t.Fatalf("unknown token: want 401 invalid_refresh_token, got %d %s", w.Code, w.Body)
+	}
+
+	// used by a concurrent refresh between the lookup and the rotation
+	expectTokenOwner(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM tokens`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if w := postJSON(h, "/refresh", `{"refresh_token":"raced"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("already used token: want 401, got %d %s", w.Code, w.Body)
+	}
+
+	if w := postJSON(h, "/refresh", `{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("missing token: want 400, got %d %s", w.Code, w.Body)
+	}
+	if w := postJSON(h, "/refresh", `{"refresh_token":"live","client_id":"tv"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown client: want 400, got %d %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
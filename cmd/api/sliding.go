@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+)
+
+// sessionTokenTTL is the lifetime of the session JWT issued on login.
+const sessionTokenTTL = 48 * time.Hour
+
+// slidingSessionConf lets active users stay signed in without calling a
+// refresh endpoint: a session token used within refreshWithin of its expiry
+// is answered with a fresh one in the X-Refreshed-Token header, as long as
+// the session started less than maxLifetime ago.
+type slidingSessionConf struct {
+	enabled       bool
+	refreshWithin time.Duration
+	maxLifetime   time.Duration
+}
+
+// slideSession sets X-Refreshed-Token when claims belong to a session token
+// close to expiry. The new token keeps the audience and session start of the
+// old one, and never outlives the session's maxLifetime.
+func (app *application) slideSession(w http.ResponseWriter, claims *authClaims, user *data.User) {
+	conf := app.conf.slidingSession
+	if !conf.enabled || claims.Scope != "" || claims.ExpiresAt == nil || claims.IssuedAt == nil {
+		return
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt.Sub(now) > conf.refreshWithin {
+		return
+	}
+
+	start := claims.IssuedAt.Time
+	if claims.SessionStart > 0 {
+		start = time.Unix(claims.SessionStart, 0)
+	}
+	ttl := min(sessionTokenTTL, start.Add(conf.maxLifetime).Sub(now))
+	if !now.Add(ttl).After(claims.ExpiresAt.Time) {
+		// the session is at its lifetime bound; let the token run out
+		return
+	}
+
+	var audience string
+	if len(claims.Audience) == 1 {
+		audience = claims.Audience[0]
+	}
+	custom := app.userClaims(user)
+	custom["session_start"] = start.Unix()
+
+	token, err := app.generateJWT(user.ID, audience, ttl, custom)
+	if err != nil {
+		app.logger.Error("Error refreshing session token", "error", err)
+		return
+	}
+	// the header carries a bearer token on an otherwise ordinary response,
+	// which shared caches must not keep
+	w.Header().Set("X-Refreshed-Token", token)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// sessionClaims are the claims of a session token issued age ago that
+// expires in remaining.
+func sessionClaims(age, remaining time.Duration) *authClaims {
+	now := time.Now()
+	return &authClaims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   "1",
+		IssuedAt:  jwt.NewNumericDate(now.Add(-age)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(remaining)),
+	}}
+}
+
+func newSlidingTestApp(t *testing.T) *application {
+	t.Helper()
+	app, _ := newTestApp(t)
+	app.conf.slidingSession.enabled = true
+	app.conf.slidingSession.refreshWithin = time.Hour
+	app.conf.slidingSession.maxLifetime = 30 * 24 * time.Hour
+	return app
+}
+
+func TestSlideSessionRenewsNearExpiry(t *testing.T) {
+	app := newSlidingTestApp(t)
+	w := httptest.NewRecorder()
+
+	app.slideSession(w, sessionClaims(23*time.Hour, 30*time.Minute), &data.User{ID: 1})
+
+	if w.Header().Get("X-Refreshed-Token") == "" {
+		t.Fatal("no X-Refreshed-Token for a token near expiry")
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Fatalf("Cache-Control = %q, want no-store", got)
+	}
+}
+
+func TestSlideSessionLeavesFreshTokens(t *testing.T) {
+	app := newSlidingTestApp(t)
+	w := httptest.NewRecorder()
+
+	app.slideSession(w, sessionClaims(time.Minute, 20*time.Hour), &data.User{ID: 1})
+
+	if w.Header().Get("X-Refreshed-Token") != "" {
+		t.Fatal("fresh token was renewed")
+	}
+	if w.Header().Get("Cache-Control") != "" {
+		t.Fatal("Cache-Control set without a refreshed token")
+	}
+}
+
+func TestSlideSessionSkipsScopedAndDisabled(t *testing.T) {
+	app := newSlidingTestApp(t)
+
+	scoped := sessionClaims(23*time.Hour, 30*time.Minute)
+	scoped.Scope = scopePhoneVerify
+	w := httptest.NewRecorder()
+	app.slideSession(w, scoped, &data.User{ID: 1})
+	if w.Header().Get("X-Refreshed-Token") != "" {
+		t.Fatal("scoped token was renewed")
+	}
+
+	app.conf.slidingSession.enabled = false
+	w = httptest.NewRecorder()
+	app.slideSession(w, sessionClaims(23*time.Hour, 30*time.Minute), &data.User{ID: 1})
+	if w.Header().Get("X-Refreshed-Token") != "" {
+		t.Fatal("token renewed with sliding sessions off")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+var dsnPasswordRx = regexp.MustCompile(`password=('(?:[^'\\]|\\.)*'|\S+)`)
+
+// redact the password of a key/value or URL postgres DSN
+func redactDSN(dsn string) string {
+	if strings.Contains(dsn, "://") {
+		if u, err := url.Parse(dsn); err == nil {
+			return u.Redacted()
+		}
+	}
+	return dsnPasswordRx.ReplaceAllString(dsn, "password="+redacted)
+}
+
+// configSummary renders the effective configuration with secrets redacted,
+// one line per group of settings.
+func configSummary(conf config) []string {
+	dsn, err := conf.db.buildDSN()
+	if err != nil {
+		dsn = "<invalid: " + err.Error() + ">"
+	}
+
+	lines := []string{
+		fmt.Sprintf("port=%d env=%s", conf.port, conf.env),
+		fmt.Sprintf("db.dsn=%q db.replica_dsn=%q", redactDSN(dsn), redactDSN(conf.dbReplicaDSN)),
+		fmt.Sprintf("db.max_open_conns=%d db.max_idle_conns=%d db.max_idle_time=%s",
+			conf.db.maxOpenConns, conf.db.maxIdleConns, conf.db.maxIdleTime),
+		fmt.Sprintf("redis.mode=%s redis.addr=%s redis.addrs=%s redis.master=%s redis.db=%d redis.password=%s",
+			conf.redis.mode, conf.redis.addr, strings.Join(conf.redis.addrs, ","), conf.redis.masterName,
+			conf.redis.db, redactIfSet(conf.redis.password)),
+		fmt.Sprintf("jwt.secret=%s jwt.key_ids=%s",
+			redactIfSet(conf.jwt.secret), strings.Join(jwtSecretIDs(conf.jwt.secret), ",")),
+		fmt.Sprintf("otp.length=%d otp.ttl=%s otp.reset_limit_on_verify=%t",
+			conf.otpLength, otpTTL, conf.otp.resetLimitOnVerify),
+		fmt.Sprintf("otp.purposes=%s", strings.Join(purposeNames(conf.otpPurposes), ",")),
+		fmt.Sprintf("otp.max_attempts=%d otp.attempts_ttl=%s otp.reuse_window=%d",
+			conf.otp.maxAttempts, conf.otp.attemptsTTL, conf.otp.reuseWindow),
+		fmt.Sprintf("rate_limit.max=%d rate_limit.window=%s rate_limit.algorithm=%s otp.resend_cooldown=%s",
+			conf.otp.rateLimitMax, conf.otp.rateLimitWindow, conf.otp.rateLimitAlgorithm, conf.otp.resendCooldown),
+		fmt.Sprintf("otp.max_resends=%d otp.resend_window=%s", conf.otp.maxResends, conf.otp.resendWindow),
+		fmt.Sprintf("otp.max_challenge_lifetime=%s otp.previous_code_grace=%s", conf.otp.maxChallengeLifetime, conf.otp.previousCodeGrace),
+		fmt.Sprintf("otp.tarpit_base=%s otp.tarpit_step=%s otp.tarpit_max=%s",
+			conf.otp.tarpitBase, conf.otp.tarpitStep, conf.otp.tarpitMax),
+		fmt.Sprintf("otp.origin_log=%t otp.origin_log_size=%d", conf.otp.originLog, conf.otp.originLogSize),
+		fmt.Sprintf("channels=sms sms.provider=%s sms.outbox_size=%d sms.api_key=%s sms.webhook_secret=%s",
+			conf.sms.provider, conf.sms.outboxSize, redactIfSet(conf.sms.apiKey), redactIfSet(conf.sms.webhookSecret)),
+		fmt.Sprintf("sms.breaker=%t sms.breaker_max_failures=%d sms.breaker_open_timeout=%s",
+			conf.sms.breakerEnabled, conf.sms.breakerMaxFailures, conf.sms.breakerOpenTimeout),
+		fmt.Sprintf("sms.quota_per_second=%d sms.quota_per_day=%d", conf.sms.quotaPerSecond, conf.sms.quotaPerDay),
+		fmt.Sprintf("sms.app_name=%q sms.support_url=%q sms.anti_phishing=%t sms.max_segments=%d",
+			conf.sms.branding.AppName, conf.sms.branding.SupportURL, conf.sms.branding.AntiPhishing, conf.sms.maxSegments),
+		fmt.Sprintf("line_check=%t line_check.provider=%s line_check.voip_prefixes=%d line_check.blocked=%s line_check.api_key=%s line_check.cache_ttl=%s",
+			conf.lineCheck.enabled, conf.lineCheck.provider, len(conf.lineCheck.voipPrefixes),
+			strings.Join(conf.lineCheck.blockedTypes, ","), redactIfSet(conf.lineCheck.apiKey), conf.lineCheck.cacheTTL),
+		fmt.Sprintf("metrics_push.url=%q metrics_push.interval=%s metrics_push.job=%s metrics_push.instance=%s",
+			conf.metricsPush.url, conf.metricsPush.interval, conf.metricsPush.job, conf.metricsPush.instance),
+		fmt.Sprintf("http_client.timeout=%s http_client.response_header_timeout=%s",
+			conf.http.timeout, conf.http.responseHeaderTimeout),
+		fmt.Sprintf("admins=%d json_naming=%s strict_page_size=%t max_page_offset=%d legacy_list_envelope=%t",
+			len(conf.adminIDs), conf.jsonNaming, conf.strictPageSize, conf.maxPageOffset, conf.legacyListEnvelope),
+		fmt.Sprintf("empty_search_lists_all=%t", conf.emptySearchListsAll),
+		fmt.Sprintf("features=%s", strings.Join(conf.features.enabledNames(), ",")),
+		fmt.Sprintf("client_ids=%s audience_rules=%d", strings.Join(conf.clientIDs, ","), len(conf.audienceRules)),
+		fmt.Sprintf("sliding_session=%t sliding_session.refresh_within=%s sliding_session.max_lifetime=%s",
+			conf.slidingSession.enabled, conf.slidingSession.refreshWithin, conf.slidingSession.maxLifetime),
+		fmt.Sprintf("auto_create_users=%t deleted_phone_cooldown=%s", conf.autoCreateUsers, conf.deletedPhoneCooldown),
+		fmt.Sprintf("refresh_token.ttl=%s refresh_token.access_ttl=%s refresh_token.bytes=%d",
+			conf.refreshTokenTTL, conf.refreshAccessTTL, conf.refreshTokenBytes),
+		fmt.Sprintf("trusted_proxies=%d mask_log_phones=%t", len(conf.trustedProxies), conf.maskLogPhones),
+		fmt.Sprintf("captcha.provider=%s captcha.secret=%s captcha.url=%q captcha.bypass_numbers=%d",
+			conf.captcha.provider, redactIfSet(conf.captcha.secret), conf.captcha.url, len(conf.captcha.bypassNumbers)),
+		fmt.Sprintf("host.allowed=%s host.canonical=%s", strings.Join(conf.host.allowed, ","), conf.host.canonical),
+		fmt.Sprintf("internal_access.cidrs=%d internal_access.routes=%s",
+			len(conf.internalAccess.cidrs), strings.Join(conf.internalAccess.routes, ",")),
+	}
+	return lines
+}
+
+func redactIfSet(s string) string {
+	if s == "" {
+		return "<unset>"
+	}
+	return redacted
+}
+
+// selfCheck logs the configuration summary and pings the critical
+// dependencies, returning an error if any of them is unreachable.
+func (app *application) selfCheck() error {
+	app.logger.Info("effective configuration", "config", configSummary(app.conf))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var errs []error
+
+	if err := app.db.PingContext(ctx); err != nil {
+		app.logger.Error("self-check: database DOWN", "error", err)
+		errs = append(errs, fmt.Errorf("database: %w", err))
+	} else {
+		app.logger.Info("self-check: database OK")
+	}
+
+	if app.replica != nil {
+		if err := app.replica.PingContext(ctx); err != nil {
+			app.logger.Error("self-check: database replica DOWN", "error", err)
+			errs = append(errs, fmt.Errorf("database replica: %w", err))
+		} else {
+			app.logger.Info("self-check: database replica OK")
+		}
+	}
+
+	if err := app.cache.Ping(ctx).Err(); err != nil {
+		app.logger.Error("self-check: redis DOWN", "error", err)
+		errs = append(errs, fmt.Errorf("redis: %w", err))
+	} else {
+		app.logger.Info("self-check: redis OK")
+	}
+
+	return errors.Join(errs...)
+}
+
+// jwtSecretIDs lists the key IDs of the configured secrets, primary first;
+// these are what /admin/jwt/rotate takes.
+func jwtSecretIDs(list string) []string {
+	primary, previous := parseJWTSecrets(list)
+	if primary == nil {
+		return nil
+	}
+	return newJWTKeySet(primary, previous).keyIDs()
+}
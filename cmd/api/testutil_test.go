@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"Go-OTP-Login/internal/data"
+	"Go-OTP-Login/internal/sms"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestApp returns an application with the default configuration backed
+// by an in-memory Redis. It has no database; tests needing one stub the
+// models they use.
+func newTestApp(t *testing.T) (*application, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	cache := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { cache.Close() })
+
+	conf := defaultConfig()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	app := &application{
+		conf:                 *conf,
+		logger:               logger,
+		cache:                cache,
+		sms:                  sms.LogSender{Logger: logger, Redact: true},
+		jwtKeys:              newJWTKeySet([]byte("test-secret-that-is-long-enough!"), nil),
+		workers:              newWorkerGroup(),
+		verifyFailureLimiter: &eventLimiter{perSecond: conf.otp.failureLogPerSecond},
+	}
+	return app, mr
+}
+
+// postJSON calls h with body as a JSON POST to path.
+func postJSON(h http.HandlerFunc, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	h(w, r)
+	return w
+}
+
+// withUser returns h running as the authenticated user.
+func withUser(app *application, user *data.User, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, app.contextSetUser(r, user))
+	}
+}
+
+// fakeSender records messages and fails every send with err when set.
+type fakeSender struct {
+	mu   sync.Mutex
+	sent []string // "to: message"
+	err  error
+}
+
+func (s *fakeSender) Send(ctx context.Context, to, message string) (*sms.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.sent = append(s.sent, to+": "+message)
+	return &sms.Result{Provider: "fake", MessageID: strconv.Itoa(len(s.sent)), Status: "sent", SentAt: time.Now()}, nil
+}
+
+// mockDB backs app's models with a sqlmock database.
+func mockDB(t *testing.T, app *application) sqlmock.Sqlmock {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	app.db = db
+	app.models = data.NewModels(db)
+	return mock
+}
+
+// expectUserByID answers the next user lookup by ID with user.
+func expectUserByID(mock sqlmock.Sqlmock, user *data.User) {
+	mock.ExpectQuery(`SELECT id, created_at, phone_number, name\s+FROM users\s+WHERE id = \$1`).
+		WithArgs(user.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "phone_number", "name"}).
+			AddRow(user.ID, user.CreatedAt, user.PhoneNumber, user.Name))
+}
+
+// expectLogin answers the user upsert and refresh token insert of a
+// login for user; inserted reports whether the user is new.
+func expectLogin(mock sqlmock.Sqlmock, user *data.User, inserted bool) {
+	mock.ExpectQuery(`INSERT INTO users`).
+		WithArgs(user.PhoneNumber, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "phone_number", "name", "inserted"}).
+			AddRow(user.ID, user.CreatedAt, user.PhoneNumber, user.Name, inserted))
+	mock.ExpectQuery(`INSERT INTO tokens`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+}
+
+// authedRequest is a GET to path carrying token as a Bearer token.
+func authedRequest(path, token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
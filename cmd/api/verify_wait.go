@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// verifyWaitTimeout is how long /verify/wait holds a request; it must
+	// stay below the server's WriteTimeout.
+	verifyWaitTimeout = 20 * time.Second
+	// verifyWaitPoll is how often a waiting request checks for the result.
+	verifyWaitPoll = 500 * time.Millisecond
+	// verifyWaitResultTTL is how long a verified challenge waits to be
+	// picked up.
+	verifyWaitResultTTL = time.Minute
+)
+
+// an open challenge holds this prefix followed by the phone it was opened
+// for; a verified one holds the verified prefix followed by the user ID
+const (
+	verifyWaitPendingPrefix  = "pending:"
+	verifyWaitVerifiedPrefix = "verified:"
+)
+
+var errWaitChallengeInvalid = errors.New("invalid or expired challenge")
+
+// only the hash is stored, as for magic tokens
+func verifyWaitKey(challengeID string) string {
+	hash := sha256.Sum256([]byte(challengeID))
+	return "verify_wait:" + hex.EncodeToString(hash[:])
+}
+
+// startVerifyWait opens a challenge /verify/wait can block on until a
+// /verify presenting its ID succeeds for the phone, and returns the ID. The
+// ID is a bearer secret: whoever holds it receives that verify's session.
+// Challenges are only opened for login codes and outlive them.
+func (app *application) startVerifyWait(ctx context.Context, phone string) (string, error) {
+	randomBytes := make([]byte, 20)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	challengeID := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	ttl := max(app.otpPurposeTTL(purposeLogin), app.conf.otp.maxChallengeLifetime)
+	if err := app.cache.Set(ctx, verifyWaitKey(challengeID), verifyWaitPendingPrefix+phone, ttl).Err(); err != nil {
+		return "", fmt.Errorf("failed to store wait challenge: %w", err)
+	}
+	return challengeID, nil
+}
+
+// completeVerifyWaitScript marks a challenge verified only while it is still
+// open for the verified phone. KEYS[1] is the challenge key; ARGV is the
+// expected pending value, the verified value and the result TTL in ms.
+var completeVerifyWaitScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+  return 0
+end
+redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+return 1
+`)
+
+// completeVerifyWait marks the challenge a /verify presented as verified by
+// userID. It is only completed if it was opened by /request for the same
+// phone, so a challenge can't be attached to someone else's login.
+func (app *application) completeVerifyWait(ctx context.Context, challengeID, phone string, userID int64) error {
+	ok, err := completeVerifyWaitScript.Run(ctx, app.cache, []string{verifyWaitKey(challengeID)},
+		verifyWaitPendingPrefix+phone,
+		verifyWaitVerifiedPrefix+strconv.FormatInt(userID, 10),
+		verifyWaitResultTTL.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return errWaitChallengeInvalid
+	}
+	return nil
+}
+
+// awaitVerifyWait polls the challenge until it is verified, returning the
+// user ID, or 0 once ctx is done. Picking up the result deletes it.
+func (app *application) awaitVerifyWait(ctx context.Context, challengeID string) (int64, error) {
+	key := verifyWaitKey(challengeID)
+	ticker := time.NewTicker(verifyWaitPoll)
+	defer ticker.Stop()
+
+	for {
+		state, err := app.cache.Get(ctx, key).Result()
+		switch {
+		case errors.Is(err, redis.Nil):
+			return 0, errWaitChallengeInvalid
+		case ctx.Err() != nil:
+			return 0, nil
+		case err != nil:
+			return 0, err
+		}
+
+		if strings.HasPrefix(state, verifyWaitVerifiedPrefix) {
+			// GETDEL so two waiters on one challenge get one session
+			state, err = app.cache.GetDel(ctx, key).Result()
+			if errors.Is(err, redis.Nil) {
+				return 0, errWaitChallengeInvalid
+			}
+			if err != nil {
+				return 0, err
+			}
+			return strconv.ParseInt(strings.TrimPrefix(state, verifyWaitVerifiedPrefix), 10, 64)
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleVerifyWait godoc
+// @Summary     Wait for verification
+// @Description Long-polls until a /verify carrying this challenge_id succeeds (e.g. the code auto-read and submitted by the phone, with the challenge_id passed along from the device that called /request), then returns a new session. Answers 204 after about 20 seconds without a result so the client can poll again. Only available when OTP_FEATURE_VERIFY_WAIT is enabled.
+// @Tags        Auth
+// @Produce     json
+// @Param       challenge_id query    string true "challenge_id from /request"
+// @Success     200          {object} map[string]interface{} "success/data/token/refresh_token"
+// @Success     204          "not verified yet, poll again"
+// @Failure     400          {object} map[string]string
+// @Failure     404          {object} map[string]string "unknown, expired or already used challenge"
+// @Failure     500          {object} map[string]string
+// @Router      /verify/wait [get]
+func (app *application) handleVerifyWait(w http.ResponseWriter, r *http.Request) error {
+	challengeID := r.URL.Query().Get("challenge_id")
+	if challengeID == "" {
+		return badRequest("challenge_id is required")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), verifyWaitTimeout)
+	defer cancel()
+
+	userID, err := app.awaitVerifyWait(ctx, challengeID)
+	if errors.Is(err, errWaitChallengeInvalid) {
+		return &apiError{Status: http.StatusNotFound, Code: "not_found", Message: "Unknown or expired challenge"}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read wait challenge: %w", err)
+	}
+	if userID == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	user, err := app.models.User.GetByIDPrimary(userID)
+	if err != nil {
+		return fmt.Errorf("failed to load user %d: %w", userID, err)
+	}
+	jwtToken, err := app.generateJWT(user.ID, "", sessionTokenTTL, app.userClaims(user))
+	if err != nil {
+		return fmt.Errorf("failed to generate JWT for user %d: %w", user.ID, err)
+	}
+	// a session of its own, separate from the one /verify returned
+	refreshToken, err := app.models.Token.New(user.ID, app.conf.refreshTokenTTL)
+	if err != nil {
+		return fmt.Errorf("failed to create session for user %d: %w", user.ID, err)
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{
+		"success":       true,
+		"data":          user,
+		"token":         jwtToken,
+		"refresh_token": refreshToken.Plaintext,
+	}, nil)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyWaitReturnsOnceVerified(t *testing.T) {
+	app, _ := newTestApp(t)
+	ctx := context.Background()
+
+	challengeID, err := app.startVerifyWait(ctx, "+989121234567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		if err := app.completeVerifyWait(ctx, challengeID, "+989121234567", 42); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	userID, err := app.awaitVerifyWait(waitCtx, challengeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != 42 {
+		t.Fatalf("user ID = %d, want 42", userID)
+	}
+
+	// the result is handed out once
+	if _, err := app.awaitVerifyWait(waitCtx, challengeID); !errors.Is(err, errWaitChallengeInvalid) {
+		t.Fatalf("second pick-up err = %v, want errWaitChallengeInvalid", err)
+	}
+}
+
+func TestVerifyWaitTimesOut(t *testing.T) {
+	app, _ := newTestApp(t)
+	ctx := context.Background()
+
+	challengeID, err := app.startVerifyWait(ctx, "+989121234567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	userID, err := app.awaitVerifyWait(waitCtx, challengeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != 0 {
+		t.Fatalf("user ID = %d, want 0 on timeout", userID)
+	}
+}
+
+func TestVerifyWaitUnknownChallenge(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	_, err := app.awaitVerifyWait(context.Background(), "no-such-challenge")
+	if !errors.Is(err, errWaitChallengeInvalid) {
+		t.Fatalf("err = %v, want errWaitChallengeInvalid", err)
+	}
+}
+
+// A challenge opened by someone else for the phone must not receive a
+// login that didn't present it, nor one for a different phone.
+func TestVerifyWaitBoundToChallengeAndPhone(t *testing.T) {
+	app, _ := newTestApp(t)
+	ctx := context.Background()
+
+	attacker, err := app.startVerifyWait(ctx, "+989121234567")
+	if err != nil {
+		t.Fatal(err)
+	}
+	victim, err := app.startVerifyWait(ctx, "+989121234567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.completeVerifyWait(ctx, victim, "+989121234567", 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.completeVerifyWait(ctx, attacker, "+989129999999", 7); !errors.Is(err, errWaitChallengeInvalid) {
+		t.Fatalf("completing with another phone: err = %v, want errWaitChallengeInvalid", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	userID, err := app.awaitVerifyWait(waitCtx, attacker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != 0 {
+		t.Fatalf("attacker's challenge got user %d", userID)
+	}
+}
+
+func TestVerifyWaitOutlivesLoginCode(t *testing.T) {
+	app, mr := newTestApp(t)
+	app.conf.otp.maxChallengeLifetime = time.Minute
+	app.conf.otpPurposes[purposeLogin] = otpPurposeConf{ttl: 4 * time.Minute}
+
+	challengeID, err := app.startVerifyWait(context.Background(), "+989121234567")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL(verifyWaitKey(challengeID)); ttl != 4*time.Minute {
+		t.Fatalf("challenge TTL = %v, want the login code TTL", ttl)
+	}
+}
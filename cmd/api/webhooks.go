@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"Go-OTP-Login/internal/webhook"
+)
+
+// swagger:model smsStatusCallback
+type smsStatusCallback struct {
+	MessageID string `json:"message_id"`
+	To        string `json:"to"`
+	Status    string `json:"status"`
+}
+
+// handleSMSStatusCallback godoc
+// @Summary     SMS delivery status callback
+// @Description Receives delivery reports from the SMS provider. Requests must carry a valid X-Signature header.
+// @Tags        Webhooks
+// @Accept      json
+// @Produce     json
+// @Param       X-Signature header   string            true "t=<unix>,v1=<hex hmac-sha256>"
+// @Param       payload     body     smsStatusCallback true "Delivery report"
+// @Success     200         {object} map[string]interface{} "success"
+// @Failure     400         {object} map[string]string
+// @Failure     401         {object} map[string]string
+// @Failure     404         {object} map[string]string
+// @Router      /webhooks/sms/status [post]
+func (app *application) handleSMSStatusCallback(w http.ResponseWriter, r *http.Request) error {
+	// an empty secret would make every signature trivially forgeable
+	if app.conf.sms.webhookSecret == "" {
+		return &apiError{Status: http.StatusNotFound, Code: "not_found", Message: "SMS callbacks are not configured"}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	if err != nil {
+		return badRequest("Invalid request payload")
+	}
+
+	if err := webhook.VerifySignature([]byte(app.conf.sms.webhookSecret), body, r.Header.Get("X-Signature")); err != nil {
+		app.logger.ErrorContext(r.Context(), "SMS callback signature rejected", "error", err)
+		return &apiError{Status: http.StatusUnauthorized, Code: "invalid_signature", Message: "Invalid signature"}
+	}
+
+	var input smsStatusCallback
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if err := dec.Decode(&input); err != nil {
+		return badRequest("Invalid request payload")
+	}
+
+	app.logger.InfoContext(r.Context(), "SMS status", "message_id", input.MessageID, "phone", input.To, "status", input.Status)
+
+	return app.writeJSON(w, http.StatusOK, envelope{"success": true}, nil)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"Go-OTP-Login/internal/webhook"
+)
+
+func TestSMSStatusCallbackSignature(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.conf.sms.webhookSecret = "webhook-secret"
+	h := app.handle(app.handleSMSStatusCallback)
+	body := `{"message_id":"42","to":"+989121234567","status":"delivered"}`
+
+	post := func(body, signature string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/webhooks/sms/status", strings.NewReader(body))
+		r.Header.Set("X-Signature", signature)
+		h(w, r)
+		return w.Code
+	}
+
+	signed := webhook.Sign([]byte("webhook-secret"), []byte(body), time.Now())
+	if code := post(body, signed); code != http.StatusOK {
+		t.Fatalf("valid signature: got %d", code)
+	}
+	if code := post(strings.Replace(body, "delivered", "failed", 1), signed); code != http.StatusUnauthorized {
+		t.Fatalf("tampered body: got %d, want 401", code)
+	}
+	stale := webhook.Sign([]byte("webhook-secret"), []byte(body), time.Now().Add(-time.Hour))
+	if code := post(body, stale); code != http.StatusUnauthorized {
+		t.Fatalf("stale signature: got %d, want 401", code)
+	}
+	if code := post(body, ""); code != http.StatusUnauthorized {
+		t.Fatalf("unsigned: got %d, want 401", code)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// workerGroup runs the application's background goroutines under one
+// context so shutdown can stop them all and wait for them to finish.
+type workerGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running []string
+}
+
+func newWorkerGroup() *workerGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workerGroup{ctx: ctx, cancel: cancel}
+}
+
+// Go starts fn in a goroutine. fn must return soon after ctx is done.
+func (g *workerGroup) Go(name string, fn func(ctx context.Context)) {
+	g.mu.Lock()
+	g.running = append(g.running, name)
+	g.mu.Unlock()
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer g.remove(name)
+		fn(g.ctx)
+	}()
+}
+
+func (g *workerGroup) remove(name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if i := slices.Index(g.running, name); i >= 0 {
+		g.running = slices.Delete(g.running, i, i+1)
+	}
+}
+
+// Shutdown cancels every worker and waits up to timeout for them to
+// return, reporting the ones that didn't.
+func (g *workerGroup) Shutdown(timeout time.Duration) error {
+	g.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return fmt.Errorf("workers still running after %s: %s", timeout, strings.Join(g.running, ", "))
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkerGroupShutdownWaitsForWorkers(t *testing.T) {
+	g := newWorkerGroup()
+	stopped := make(chan struct{})
+	g.Go("ticker", func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		close(stopped)
+	})
+
+	if err := g.Shutdown(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("Shutdown returned before the worker finished")
+	}
+}
+
+func TestWorkerGroupShutdownReportsStuckWorkers(t *testing.T) {
+	g := newWorkerGroup()
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	g.Go("well-behaved", func(ctx context.Context) { <-ctx.Done() })
+	g.Go("stuck", func(context.Context) { <-release })
+
+	err := g.Shutdown(50 * time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "stuck") || strings.Contains(err.Error(), "well-behaved") {
+		t.Fatalf("err = %v, want only the stuck worker named", err)
+	}
+}
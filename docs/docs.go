@@ -18,30 +18,63 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/protected": {
-            "get": {
+        "/admin/jwt/rotate": {
+            "post": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Requires Bearer token (Authorization: Bearer \u003ctoken\u003e)",
+                "description": "Makes the configured secret with the given ID the signing key on every instance. The secret must already be in OTP_JWT_SECRET everywhere; only its ID is shared through Redis. The other configured secrets keep verifying existing tokens. Changing the first OTP_JWT_SECRET entry overrides earlier rotations. Requires a recent OTP verification.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Protected"
+                    "Admin"
+                ],
+                "summary": "Rotate JWT secret",
+                "parameters": [
+                    {
+                        "description": "ID of the secret to sign with",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.rotateJWTSecretReq"
+                        }
+                    }
                 ],
-                "summary": "Protected resource",
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "success/message/signing_key_id/key_ids",
                         "schema": {
-                            "$ref": "#/definitions/main.protectedRes"
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
                         }
                     },
                     "401": {
-                        "description": "Unauthorized",
+                        "description": "error/code (step_up_required)",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -52,40 +85,40 @@ const docTemplate = `{
                 }
             }
         },
-        "/request": {
+        "/admin/otp/flush": {
             "post": {
-                "description": "Generates OTP and stores it in Redis for the given phone_number (2 min TTL).",
-                "consumes": [
-                    "application/json"
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
                 ],
+                "description": "Deletes every pending OTP code and challenge window, e.g. during a security incident. Users have to request a new code. Rate limits and lockouts are left in place. Requires a recent OTP verification.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Auth"
-                ],
-                "summary": "Request OTP",
-                "parameters": [
-                    {
-                        "description": "OTP request payload",
-                        "name": "payload",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/main.requestOTPReq"
-                        }
-                    }
+                    "Admin"
                 ],
+                "summary": "Invalidate all pending OTPs",
                 "responses": {
                     "200": {
-                        "description": "success/message",
+                        "description": "success/cleared",
                         "schema": {
                             "type": "object",
                             "additionalProperties": true
                         }
                     },
-                    "400": {
-                        "description": "error",
+                    "401": {
+                        "description": "error/code (step_up_required)",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -94,7 +127,7 @@ const docTemplate = `{
                         }
                     },
                     "500": {
-                        "description": "error",
+                        "description": "Internal Server Error",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -105,56 +138,48 @@ const docTemplate = `{
                 }
             }
         },
-        "/users": {
+        "/admin/otp/requests": {
             "get": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Paginated list of users. Supports search by phone or other fields via 'q'.",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Returns metadata (IP, user agent, masked phone, calling code, time) of the newest OTP requests for fraud scoring. Empty unless the origin log is enabled. Also served without a token to OTP_INTERNAL_CIDRS when listed in OTP_INTERNAL_ROUTES.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "Admin"
                 ],
-                "summary": "List users",
+                "summary": "Recent OTP request origins",
                 "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Search term (matches phone)",
-                        "name": "q",
-                        "in": "query"
-                    },
-                    {
-                        "type": "integer",
-                        "description": "Page number (1-based, default 1)",
-                        "name": "page",
-                        "in": "query"
-                    },
                     {
                         "type": "integer",
-                        "description": "Page size (max 100, default 20)",
-                        "name": "page_size",
+                        "description": "Number of entries (default 100, max 1000)",
+                        "name": "limit",
                         "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "envelope with 'response' key",
+                        "description": "envelope with 'requests' key",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
-                                "$ref": "#/definitions/main.UsersListResponseEnvelope"
+                                "type": "string"
                             }
                         }
                     },
                     "500": {
-                        "description": "failed to fetch users",
+                        "description": "Internal Server Error",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -165,42 +190,40 @@ const docTemplate = `{
                 }
             }
         },
-        "/users/{id}": {
+        "/admin/otp/status": {
             "get": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Retrieve a single user by its numeric ID.",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Shows whether a phone has a pending OTP, and for each purpose whether its code is pending and when it expires, plus failed attempts, resend cooldown and request rate-limit state. The codes themselves are never returned.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "Admin"
                 ],
-                "summary": "Get user by ID",
+                "summary": "OTP challenge status",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "User ID",
-                        "name": "id",
-                        "in": "path",
+                        "type": "string",
+                        "description": "Phone number",
+                        "name": "phone",
+                        "in": "query",
                         "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "phone/active/purposes/attempts/rate_limit/...",
                         "schema": {
-                            "$ref": "#/definitions/main.SingleUserEnvelope"
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     },
                     "400": {
-                        "description": "invalid user id",
+                        "description": "Bad Request",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -208,8 +231,17 @@ const docTemplate = `{
                             }
                         }
                     },
-                    "404": {
-                        "description": "user not found",
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -218,7 +250,7 @@ const docTemplate = `{
                         }
                     },
                     "500": {
-                        "description": "failed to fetch user",
+                        "description": "Internal Server Error",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -229,9 +261,14 @@ const docTemplate = `{
                 }
             }
         },
-        "/verify": {
+        "/admin/rate-limit/reset": {
             "post": {
-                "description": "Verifies OTP, creates user if needed, and returns a JWT.",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Clears the OTP rate-limit and lockout keys for a phone so it can request OTPs again immediately.",
                 "consumes": [
                     "application/json"
                 ],
@@ -239,25 +276,26 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Auth"
+                    "Admin"
                 ],
-                "summary": "Verify OTP",
+                "summary": "Reset OTP rate limit",
                 "parameters": [
                     {
-                        "description": "OTP verification payload",
+                        "description": "Phone to reset",
                         "name": "payload",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.verifyOTPReq"
+                            "$ref": "#/definitions/main.resetRateLimitReq"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "success/cleared",
                         "schema": {
-                            "$ref": "#/definitions/main.verifyOTPRes"
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     },
                     "400": {
@@ -269,8 +307,17 @@ const docTemplate = `{
                             }
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized",
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -289,60 +336,1976 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "data.User": {
-            "type": "object",
-            "properties": {
-                "created_at": {
-                    "type": "string"
-                },
-                "id": {
-                    "type": "integer"
-                },
-                "phone_number": {
-                    "type": "string"
-                }
-            }
-        },
-        "main.SingleUserEnvelope": {
-            "type": "object",
-            "properties": {
-                "user": {
-                    "$ref": "#/definitions/data.User"
-                }
-            }
         },
-        "main.UsersListResponse": {
-            "type": "object",
-            "properties": {
-                "items": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/data.User"
+        "/admin/runtime": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns goroutine count, memory and GC stats, and database/Redis connection pool stats. Also served without a token to OTP_INTERNAL_CIDRS when listed in OTP_INTERNAL_ROUTES.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Runtime stats",
+                "responses": {
+                    "200": {
+                        "description": "runtime/db/redis",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
                     }
-                },
-                "page": {
-                    "type": "integer"
-                },
-                "page_size": {
-                    "type": "integer"
-                },
-                "total": {
-                    "type": "integer"
                 }
             }
         },
-        "main.UsersListResponseEnvelope": {
-            "type": "object",
-            "properties": {
-                "response": {
-                    "$ref": "#/definitions/main.UsersListResponse"
+        "/admin/sms/outbox": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lists the latest messages captured by the \"simulate\" SMS provider, newest first. Only registered when that provider is configured (never in production).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Simulated SMS outbox",
+                "responses": {
+                    "200": {
+                        "description": "envelope with 'messages' key",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "array",
+                                "items": {
+                                    "$ref": "#/definitions/sms.OutboxEntry"
+                                }
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
                 }
             }
         },
-        "main.protectedRes": {
+        "/admin/sms/test": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Sends a test message through the configured SMS provider. Only numbers in the SMS test allowlist are accepted.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Send test SMS",
+                "parameters": [
+                    {
+                        "description": "Allowlisted destination",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.testSMSReq"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "success/result",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "422": {
+                        "description": "invalid phone number",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "502": {
+                        "description": "success/error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/tokens/introspect": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Looks up a refresh token by its plaintext and returns its owner and expiry. The token is not used or changed and no session is issued.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Introspect a refresh token",
+                "parameters": [
+                    {
+                        "description": "Refresh token to inspect",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.introspectTokenReq"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "token: id/user_id/created_at/expiry/expired",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/users/active": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lists users whose last login or refresh token use is at or after 'since', most recent first. last_active_at is when the user last logged in or used a refresh token; requests made with an access token are not tracked, so a user can be active for up to an access token lifetime (longer with sliding sessions) after it. Users who never signed in are not included.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Recently active users",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 timestamp, e.g. 2024-05-01T00:00:00Z",
+                        "name": "since",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page number (1-based, default 1)",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (max 100, default 20)",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "envelope with 'data' key ('response' in legacy mode)",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "$ref": "#/definitions/main.ActiveUsersListResponseEnvelope"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/config/limits": {
+            "get": {
+                "description": "Returns the OTP and rate-limit settings clients should respect. The OTP length and TTL are those of login codes; see /otp/meta for other purposes. Durations are in seconds. No secrets are included.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Effective limits",
+                "responses": {
+                    "200": {
+                        "description": "envelope with 'limits' key",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "$ref": "#/definitions/main.LimitsResponse"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/magic": {
+            "get": {
+                "description": "Exchanges a single-use magic token (from a scanned QR code) for a JWT.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Magic link login",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Magic token",
+                        "name": "token",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.verifyOTPRes"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/me": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Anonymizes the authenticated user's account: the phone number is replaced by a random placeholder, the name is cleared and every session is revoked. When OTP_DELETED_PHONE_COOLDOWN is set, the number can't sign up again until the cooldown ends.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sessions"
+                ],
+                "summary": "Delete my account",
+                "responses": {
+                    "200": {
+                        "description": "success/message",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/me/export": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the authenticated user's record, session metadata and OTP history as a downloadable JSON file. Token values are never included.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sessions"
+                ],
+                "summary": "Export my data",
+                "responses": {
+                    "200": {
+                        "description": "envelope with 'export' key",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "$ref": "#/definitions/main.UserExport"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/me/refresh/rotate": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Invalidates the presented refresh token and issues a new one with a fresh lifetime. Other sessions are not affected.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sessions"
+                ],
+                "summary": "Rotate my refresh token",
+                "parameters": [
+                    {
+                        "description": "Current refresh token",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.rotateRefreshReq"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "success/refresh_token/expiry",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid body",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "invalid or expired refresh token",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "failed to rotate refresh token",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/me/sessions": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Paginated list of the authenticated user's active sessions (refresh tokens).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sessions"
+                ],
+                "summary": "List my sessions",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page number (1-based, default 1)",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (max 100, default 20)",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "envelope with 'data' key ('response' in legacy mode)",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "$ref": "#/definitions/main.SessionsListResponseEnvelope"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "page_size exceeds maximum (strict mode) or pagination too deep",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "failed to fetch sessions",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/me/sessions/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Revokes a single session of the authenticated user, leaving the others signed in.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sessions"
+                ],
+                "summary": "Revoke one session",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Session ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "success/message",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid session id",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "error/code: not_found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error/code: internal_error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/otp/meta": {
+            "get": {
+                "description": "Returns the OTP length, character type and timings (in seconds) so clients can configure their code input and countdown.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "OTP format hints",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "OTP purpose (default login)",
+                        "name": "purpose",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "envelope with 'otp' key",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "$ref": "#/definitions/main.OTPMetaResponse"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/protected": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Requires Bearer token (Authorization: Bearer \u003ctoken\u003e)",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Protected"
+                ],
+                "summary": "Protected resource",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.protectedRes"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/refresh": {
+            "post": {
+                "description": "Exchanges a refresh token for a new access JWT without another OTP. The JWT lives for OTP_REFRESH_ACCESS_TTL (15 minutes by default). The refresh token is single-use: it is replaced by the returned one, and presenting it again fails.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sessions"
+                ],
+                "summary": "Refresh the access token",
+                "parameters": [
+                    {
+                        "description": "Refresh token from /verify or an earlier /refresh",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.refreshReq"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "success/token/refresh_token/expiry",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid body or unknown client_id",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "invalid or expired refresh token",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "failed to refresh session",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/request": {
+            "post": {
+                "description": "Generates OTP, stores it in Redis for the given phone_number (2 min TTL) and sends it by SMS. When CAPTCHA is enabled, captcha_token must be a solved CAPTCHA unless the number is allowlisted.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Request OTP",
+                "parameters": [
+                    {
+                        "description": "OTP request payload",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.requestOTPReq"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "success/message/resend_available_in, plus challenge_id for /verify/wait on login requests when enabled",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        },
+                        "headers": {
+                            "RateLimit-Limit": {
+                                "type": "integer",
+                                "description": "requests allowed per window"
+                            },
+                            "RateLimit-Remaining": {
+                                "type": "integer",
+                                "description": "requests left in the window"
+                            },
+                            "RateLimit-Reset": {
+                                "type": "integer",
+                                "description": "seconds until the window resets"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "422": {
+                        "description": "invalid phone number",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "429": {
+                        "description": "error/resend_available_in, or error/unlock_at for a recently deleted number",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        },
+                        "headers": {
+                            "RateLimit-Limit": {
+                                "type": "integer",
+                                "description": "requests allowed per window"
+                            },
+                            "RateLimit-Remaining": {
+                                "type": "integer",
+                                "description": "requests left in the window"
+                            },
+                            "RateLimit-Reset": {
+                                "type": "integer",
+                                "description": "seconds until the window resets"
+                            },
+                            "Retry-After": {
+                                "type": "integer",
+                                "description": "seconds until a new request can succeed"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/request/cancel": {
+            "post": {
+                "description": "Invalidates the pending OTP for the given phone_number so a fresh one can be requested. The caller must send the pending code (checked and counted like a verify) or be signed in as the number's owner. Counts against the same per-phone limit as /request.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Cancel OTP",
+                "parameters": [
+                    {
+                        "description": "OTP cancel payload",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.cancelOTPReq"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "success/message",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        },
+                        "headers": {
+                            "RateLimit-Limit": {
+                                "type": "integer",
+                                "description": "requests allowed per window"
+                            },
+                            "RateLimit-Remaining": {
+                                "type": "integer",
+                                "description": "requests left in the window"
+                            },
+                            "RateLimit-Reset": {
+                                "type": "integer",
+                                "description": "seconds until the window resets"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "error, or error/code: invalid_otp/attempts_remaining",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "409": {
+                        "description": "verification in progress",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "422": {
+                        "description": "invalid phone number",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "429": {
+                        "description": "error/retry_after, or error/code: too_many_attempts",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        },
+                        "headers": {
+                            "RateLimit-Limit": {
+                                "type": "integer",
+                                "description": "requests allowed per window"
+                            },
+                            "RateLimit-Remaining": {
+                                "type": "integer",
+                                "description": "requests left in the window"
+                            },
+                            "RateLimit-Reset": {
+                                "type": "integer",
+                                "description": "seconds until the window resets"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/resend": {
+            "post": {
+                "description": "Replaces the pending OTP for phone_number with a new code and sends it again. Allowed once the resend cooldown has passed and, when otp.maxResends is set, at most that many times per resend window. Without a pending code, use /request.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Resend OTP",
+                "parameters": [
+                    {
+                        "description": "OTP resend payload",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.requestOTPReq"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "success/message/resend_available_in, plus resends_left when resends are capped",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        },
+                        "headers": {
+                            "RateLimit-Limit": {
+                                "type": "integer",
+                                "description": "requests allowed per window"
+                            },
+                            "RateLimit-Remaining": {
+                                "type": "integer",
+                                "description": "requests left in the window"
+                            },
+                            "RateLimit-Reset": {
+                                "type": "integer",
+                                "description": "seconds until the window resets"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "422": {
+                        "description": "invalid phone number",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "429": {
+                        "description": "error/retry_after",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        },
+                        "headers": {
+                            "RateLimit-Limit": {
+                                "type": "integer",
+                                "description": "requests allowed per window"
+                            },
+                            "RateLimit-Remaining": {
+                                "type": "integer",
+                                "description": "requests left in the window"
+                            },
+                            "RateLimit-Reset": {
+                                "type": "integer",
+                                "description": "seconds until the window resets"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/users": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Paginated list of users. Supports search by phone or other fields via 'q'.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "List users",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Search term (matches phone); when empty, lists all users or returns an empty page depending on configuration",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "contains",
+                            "prefix"
+                        ],
+                        "type": "string",
+                        "description": "Search mode: contains (default) or prefix",
+                        "name": "match",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page number (1-based, default 1)",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (max 100, default 20)",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "envelope with 'data' key ('response' in legacy mode)",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "$ref": "#/definitions/main.UsersListResponseEnvelope"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "invalid match, page_size or pagination too deep",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "failed to fetch users",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Retrieve a single user by its numeric ID.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get user by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SingleUserEnvelope"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid user id",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "error/code: not_found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error/code: internal_error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/verify": {
+            "post": {
+                "description": "Verifies OTP, creates user if needed, and returns a JWT.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Verify OTP",
+                "parameters": [
+                    {
+                        "description": "OTP verification payload",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.verifyOTPReq"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.verifyOTPRes"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "error/code: invalid_otp/attempts_remaining",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "not registered (invite-only)",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "verification in progress",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "422": {
+                        "description": "invalid phone number",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "429": {
+                        "description": "error/code: too_many_attempts",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/verify/scoped": {
+            "post": {
+                "description": "Verifies an OTP like /verify but returns a short-lived token limited to one scope (e.g. \"phone_verify\") instead of a session. Scoped tokens are rejected by regular authenticated endpoints. Only login codes may register a new user; a code for another purpose is issued to the signed-in user, or without a session to the account that already uses the number.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Verify OTP for a single action",
+                "parameters": [
+                    {
+                        "description": "OTP and requested scope",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.verifyScopedReq"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "success/token/scope/expires_in",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "error/code: invalid_otp/attempts_remaining, or unauthorized for a non-login code with a scoped token",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "not registered (invite-only)",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "no account uses the number (non-login purpose without a session)",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "verification in progress",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "422": {
+                        "description": "invalid phone number",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "429": {
+                        "description": "error/code: too_many_attempts",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/verify/scoped/check": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lets a service confirm a phone_verify scoped token and read the number its OTP verified, which on phone_change is not yet the user's own. Only accepts tokens with that scope.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Check a phone_verify token",
+                "responses": {
+                    "200": {
+                        "description": "phone_number/scope",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "token has another scope",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/verify/wait": {
+            "get": {
+                "description": "Long-polls until a /verify carrying this challenge_id succeeds (e.g. the code auto-read and submitted by the phone, with the challenge_id passed along from the device that called /request), then returns a new session. Answers 204 after about 20 seconds without a result so the client can poll again. Only available when OTP_FEATURE_VERIFY_WAIT is enabled.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Wait for verification",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "challenge_id from /request",
+                        "name": "challenge_id",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "success/data/token/refresh_token",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "204": {
+                        "description": "not verified yet, poll again"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "unknown, expired or already used challenge",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/sms/status": {
+            "post": {
+                "description": "Receives delivery reports from the SMS provider. Requests must carry a valid X-Signature header.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhooks"
+                ],
+                "summary": "SMS delivery status callback",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "t=\u003cunix\u003e,v1=\u003chex hmac-sha256\u003e",
+                        "name": "X-Signature",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "Delivery report",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.smsStatusCallback"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "success",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "data.ActiveUser": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_active_at": {
+                    "description": "LastActiveAt is the latest last_used_at of the user's refresh tokens:\ntheir last login or refresh. Requests made with an access token\nin between are not tracked.",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "phone_number": {
+                    "type": "string"
+                }
+            }
+        },
+        "data.Token": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "expiry": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "plaintext": {
+                    "type": "string"
+                }
+            }
+        },
+        "data.User": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "phone_number": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.ActiveUsersListResponse": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/data.ActiveUser"
+                    }
+                },
+                "page": {
+                    "type": "integer"
+                },
+                "page_size": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                },
+                "total_pages": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.ActiveUsersListResponseEnvelope": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/main.ActiveUsersListResponse"
+                }
+            }
+        },
+        "main.LimitsResponse": {
+            "type": "object",
+            "properties": {
+                "max_requests": {
+                    "type": "integer"
+                },
+                "max_verify_attempts": {
+                    "type": "integer"
+                },
+                "otp_length": {
+                    "type": "integer"
+                },
+                "otp_ttl": {
+                    "type": "integer"
+                },
+                "rate_limit_algorithm": {
+                    "type": "string"
+                },
+                "request_window": {
+                    "type": "integer"
+                },
+                "resend_cooldown": {
+                    "type": "integer"
+                },
+                "verify_lockout_window": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.OTPExport": {
+            "type": "object",
+            "properties": {
+                "last_verified_at": {
+                    "type": "string"
+                },
+                "requests": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.otpOrigin"
+                    }
+                }
+            }
+        },
+        "main.OTPMetaResponse": {
+            "type": "object",
+            "properties": {
+                "length": {
+                    "type": "integer"
+                },
+                "resend_cooldown": {
+                    "type": "integer"
+                },
+                "ttl": {
+                    "type": "integer"
+                },
+                "type": {
+                    "type": "string",
+                    "enum": [
+                        "numeric",
+                        "alphanumeric"
+                    ]
+                }
+            }
+        },
+        "main.SessionsListResponse": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/data.Token"
+                    }
+                },
+                "page": {
+                    "type": "integer"
+                },
+                "page_size": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                },
+                "total_pages": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.SessionsListResponseEnvelope": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/main.SessionsListResponse"
+                }
+            }
+        },
+        "main.SingleUserEnvelope": {
+            "type": "object",
+            "properties": {
+                "user": {
+                    "$ref": "#/definitions/data.User"
+                }
+            }
+        },
+        "main.UserExport": {
+            "type": "object",
+            "properties": {
+                "exported_at": {
+                    "type": "string"
+                },
+                "otp": {
+                    "$ref": "#/definitions/main.OTPExport"
+                },
+                "sessions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/data.Token"
+                    }
+                },
+                "user": {
+                    "$ref": "#/definitions/data.User"
+                }
+            }
+        },
+        "main.UsersListResponse": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/data.User"
+                    }
+                },
+                "page": {
+                    "type": "integer"
+                },
+                "page_size": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                },
+                "total_pages": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.UsersListResponseEnvelope": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/main.UsersListResponse"
+                }
+            }
+        },
+        "main.cancelOTPReq": {
+            "type": "object",
+            "properties": {
+                "otp": {
+                    "description": "the pending login code; not needed when signed in as the number's owner",
+                    "type": "string"
+                },
+                "phone_number": {
+                    "description": "required: true",
+                    "type": "string"
+                }
+            }
+        },
+        "main.introspectTokenReq": {
+            "type": "object",
+            "properties": {
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.otpOrigin": {
+            "type": "object",
+            "properties": {
+                "country_code": {
+                    "type": "string"
+                },
+                "ip": {
+                    "type": "string"
+                },
+                "phone": {
+                    "type": "string"
+                },
+                "requested_at": {
+                    "type": "string"
+                },
+                "user_agent": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.protectedRes": {
             "type": "object",
             "properties": {
                 "expires_at": {
@@ -356,7 +2319,88 @@ const docTemplate = `{
                 }
             }
         },
+        "main.refreshReq": {
+            "type": "object",
+            "properties": {
+                "client_id": {
+                    "description": "optional; must be a known client, as on /verify",
+                    "type": "string"
+                },
+                "refresh_token": {
+                    "type": "string"
+                }
+            }
+        },
         "main.requestOTPReq": {
+            "type": "object",
+            "properties": {
+                "captcha_token": {
+                    "description": "response token of the CAPTCHA widget; required on /request when\nCAPTCHA is enabled, unless the number is allowlisted",
+                    "type": "string"
+                },
+                "channel": {
+                    "description": "delivery channel; only \"sms\" for now (default)",
+                    "type": "string",
+                    "enum": [
+                        "sms"
+                    ]
+                },
+                "phone_number": {
+                    "description": "required: true",
+                    "type": "string"
+                },
+                "purpose": {
+                    "description": "what the code is for, selecting its TTL and length; \"login\" (default)\ncodes are verified on /verify, others on /verify/scoped",
+                    "type": "string",
+                    "example": "login"
+                },
+                "qr": {
+                    "description": "return a scan-to-login QR code instead of sending an OTP;\nonly allowed for the authenticated user's own number and\nwhen OTP_FEATURE_QR_LOGIN is enabled",
+                    "type": "boolean"
+                }
+            }
+        },
+        "main.resetRateLimitReq": {
+            "type": "object",
+            "properties": {
+                "phone_number": {
+                    "description": "required: true",
+                    "type": "string"
+                }
+            }
+        },
+        "main.rotateJWTSecretReq": {
+            "type": "object",
+            "properties": {
+                "key_id": {
+                    "description": "ID of a secret already listed in OTP_JWT_SECRET on every instance, as\nshown in the startup summary (jwt.key_ids)\nrequired: true",
+                    "type": "string"
+                }
+            }
+        },
+        "main.rotateRefreshReq": {
+            "type": "object",
+            "properties": {
+                "refresh_token": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.smsStatusCallback": {
+            "type": "object",
+            "properties": {
+                "message_id": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "to": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.testSMSReq": {
             "type": "object",
             "properties": {
                 "phone_number": {
@@ -368,6 +2412,21 @@ const docTemplate = `{
         "main.verifyOTPReq": {
             "type": "object",
             "properties": {
+                "challenge_id": {
+                    "description": "optional; the challenge_id /request returned, completing that\n/verify/wait for the phone",
+                    "type": "string"
+                },
+                "channel": {
+                    "description": "channel the code was requested on (default \"sms\")",
+                    "type": "string",
+                    "enum": [
+                        "sms"
+                    ]
+                },
+                "client_id": {
+                    "description": "optional; must be a configured client and becomes the token's aud",
+                    "type": "string"
+                },
                 "otp": {
                     "description": "required: true",
                     "type": "string"
@@ -381,12 +2440,20 @@ const docTemplate = `{
         "main.verifyOTPRes": {
             "type": "object",
             "properties": {
+                "created": {
+                    "description": "true when this verify registered the user",
+                    "type": "boolean"
+                },
                 "data": {
                     "$ref": "#/definitions/data.User"
                 },
                 "message": {
                     "type": "string"
                 },
+                "refresh_token": {
+                    "description": "RefreshToken identifies the session; exchange it for a new JWT with\n/refresh or rotate it with /me/refresh/rotate.",
+                    "type": "string"
+                },
                 "success": {
                     "type": "boolean"
                 },
@@ -395,6 +2462,45 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "main.verifyScopedReq": {
+            "type": "object",
+            "properties": {
+                "channel": {
+                    "type": "string",
+                    "example": "sms"
+                },
+                "otp": {
+                    "type": "string",
+                    "example": "123456"
+                },
+                "phone_number": {
+                    "type": "string",
+                    "example": "+1234567890"
+                },
+                "purpose": {
+                    "type": "string",
+                    "example": "phone_change"
+                },
+                "scope": {
+                    "type": "string",
+                    "example": "phone_verify"
+                }
+            }
+        },
+        "sms.OutboxEntry": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "sent_at": {
+                    "type": "string"
+                },
+                "to": {
+                    "type": "string"
+                }
+            }
         }
     },
     "securityDefinitions": {
@@ -0,0 +1,39 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Duration of database queries by model method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"query"})
+
+	queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_query_errors_total",
+		Help: "Failed database queries by model method. Not-found results are not errors.",
+	}, []string{"query"})
+)
+
+// Collectors returns the query metrics so the caller can register them.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{queryDuration, queryErrors}
+}
+
+// observeQuery records the duration and outcome of a model method. The
+// label is a fixed name, never the SQL text. Call it deferred with a
+// pointer to the method's named error result:
+//
+//	defer observeQuery("users.get_by_id", time.Now(), &err)
+func observeQuery(query string, start time.Time, err *error) {
+	queryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+	if *err != nil && !errors.Is(*err, sql.ErrNoRows) && !errors.Is(*err, ErrRecordNotFound) {
+		queryErrors.WithLabelValues(query).Inc()
+	}
+}
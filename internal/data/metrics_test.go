@@ -0,0 +1,48 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestObserveQueryCountsOnlyFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		counts bool
+	}{
+		{"ok", nil, false},
+		{"no rows", sql.ErrNoRows, false},
+		{"not found", ErrRecordNotFound, false},
+		{"wrapped not found", errors.Join(errors.New("lookup"), ErrRecordNotFound), false},
+		{"failure", errors.New("connection reset"), true},
+	}
+	for _, tt := range tests {
+		label := "test." + tt.name
+		err := tt.err
+		observeQuery(label, time.Now(), &err)
+
+		var duration, errs dto.Metric
+		if err := queryDuration.WithLabelValues(label).(prometheus.Metric).Write(&duration); err != nil {
+			t.Fatal(err)
+		}
+		if n := duration.GetHistogram().GetSampleCount(); n != 1 {
+			t.Errorf("%s: %d durations observed, want 1", tt.name, n)
+		}
+		if err := queryErrors.WithLabelValues(label).Write(&errs); err != nil {
+			t.Fatal(err)
+		}
+		got, want := errs.GetCounter().GetValue(), 0.0
+		if tt.counts {
+			want = 1
+		}
+		if got != want {
+			t.Errorf("%s: errors = %v, want %v", tt.name, got, want)
+		}
+	}
+}
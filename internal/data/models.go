@@ -12,6 +12,9 @@ type Models struct {
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
+	ErrEditConflict   = errors.New("edit conflict")
+	ErrDuplicatePhone = errors.New("duplicate phone number")
+	ErrDuplicateToken = errors.New("duplicate token hash")
 )
 
 func NewModels(db *sql.DB) Models {
@@ -0,0 +1,63 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxPhoneInputLength caps raw phone input, separators included, before it
+// is parsed or used in Redis keys.
+const MaxPhoneInputLength = 20
+
+// E.164 allows at most 15 digits including the country code; nothing
+// shorter than 8 is a dialable international number in practice.
+const (
+	minPhoneDigits = 8
+	maxPhoneDigits = 15
+)
+
+// ValidatePhoneNumber checks that phone is an international number and
+// returns it in E.164 form, e.g. "+98 (912) 123-4567" and "0098 912 1234567"
+// both become "+989121234567". Spaces, dashes, dots and parentheses are
+// ignored. Numbers without a country code are rejected because the country
+// would be a guess. The error text is safe to show to clients.
+func ValidatePhoneNumber(phone string) (string, error) {
+	phone = strings.TrimSpace(phone)
+	if phone == "" {
+		return "", errors.New("Phone number is required")
+	}
+	if len(phone) > MaxPhoneInputLength {
+		return "", fmt.Errorf("Phone number must not be more than %d characters", MaxPhoneInputLength)
+	}
+
+	var digits strings.Builder
+	for i, c := range phone {
+		switch {
+		case c >= '0' && c <= '9':
+			digits.WriteRune(c)
+		case c == '+' && i == 0:
+		case c == ' ' || c == '-' || c == '.' || c == '(' || c == ')':
+		default:
+			return "", errors.New("Phone number contains invalid characters")
+		}
+	}
+
+	number := digits.String()
+	switch {
+	case strings.HasPrefix(phone, "+"):
+	case strings.HasPrefix(number, "00"):
+		// international call prefix used in most of the world
+		number = number[2:]
+	default:
+		return "", errors.New("Phone number must start with + and the country code, e.g. +989121234567")
+	}
+
+	if number == "" || number[0] == '0' {
+		return "", errors.New("Phone number has an invalid country code")
+	}
+	if len(number) < minPhoneDigits || len(number) > maxPhoneDigits {
+		return "", fmt.Errorf("Phone number must have between %d and %d digits including the country code", minPhoneDigits, maxPhoneDigits)
+	}
+	return "+" + number, nil
+}
@@ -0,0 +1,63 @@
+package data
+
+import "testing"
+
+func TestValidatePhoneNumberNormalizes(t *testing.T) {
+	tests := map[string]string{
+		"+989121234567":       "+989121234567",
+		"+98 (912) 123-4567":  "+989121234567",
+		"0098 912 1234567":    "+989121234567",
+		"  +1.555.123.4567  ": "+15551234567",
+		"+44 20 7946 0958":    "+442079460958",
+		"00 1 (555) 123-4567": "+15551234567",
+		"+12345678":           "+12345678",
+		"+123456789012345":    "+123456789012345",
+	}
+	for input, want := range tests {
+		got, err := ValidatePhoneNumber(input)
+		if err != nil {
+			t.Errorf("ValidatePhoneNumber(%q): %v", input, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ValidatePhoneNumber(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestValidatePhoneNumberRejects(t *testing.T) {
+	tests := []string{
+		"",
+		"   ",
+		"09121234567",            // no country code
+		"9121234567",             // no country code
+		"+0989121234567",         // country code can't start with 0
+		"000989121234567",        // same, after the 00 prefix
+		"+1234567",               // too short
+		"+1234567890123456",      // too long
+		"+98 (912) 123-4567 890", // over MaxPhoneInputLength
+		"+98912123456a",
+		"98+9121234567",
+		"+98/912/1234567",
+	}
+	for _, input := range tests {
+		if got, err := ValidatePhoneNumber(input); err == nil {
+			t.Errorf("ValidatePhoneNumber(%q) = %q, want an error", input, got)
+		}
+	}
+}
+
+// ValidatePhoneNumber must be idempotent: stored numbers are looked up by
+// passing them through it again.
+func TestValidatePhoneNumberIsIdempotent(t *testing.T) {
+	for _, input := range []string{"+98 (912) 123-4567", "0044 20 7946 0958"} {
+		once, err := ValidatePhoneNumber(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		twice, err := ValidatePhoneNumber(once)
+		if err != nil || twice != once {
+			t.Errorf("ValidatePhoneNumber(%q) = %q, %v; want %q", once, twice, err, once)
+		}
+	}
+}
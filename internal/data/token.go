@@ -6,29 +6,42 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 )
 
 // Token represents a user id token record.
 // swagger:model Token
 type Token struct {
-	Plaintext string    `json:"plaintext"`
+	ID        int64     `json:"id"`
+	Plaintext string    `json:"plaintext,omitempty"`
 	Hash      []byte    `json:"-"`
 	UserId    int64     `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
 	Expiry    time.Time `json:"expiry"`
 }
 
+// MinTokenBytes is the least randomness a token may carry.
+const MinTokenBytes = 16
+
 type TokenModel struct {
 	DB *sql.DB
+	// Bytes is how many random bytes a new token's plaintext encodes;
+	// zero means MinTokenBytes.
+	Bytes int
+}
+
+func (m TokenModel) tokenBytes() int {
+	return max(m.Bytes, MinTokenBytes)
 }
 
-func generateToken(userId int64, ttl time.Duration) (*Token, error) {
+func generateToken(userId int64, ttl time.Duration, n int) (*Token, error) {
 	token := &Token{
 		UserId: userId,
 		Expiry: time.Now().Add(ttl),
 	}
 
-	randomBytes := make([]byte, 16)
+	randomBytes := make([]byte, n)
 
 	_, err := rand.Read(randomBytes)
 	if err != nil {
@@ -43,35 +56,223 @@ func generateToken(userId int64, ttl time.Duration) (*Token, error) {
 
 }
 
-func (m TokenModel) Insert(token *Token) error {
-	query := `INSERT INTO tokens (hash, user_id, expiry)
-	VALUES ($1, $2, $3)`
-
-	args := []interface{}{token.Hash, token.UserId, token.Expiry}
+func (m TokenModel) Insert(token *Token) (err error) {
+	defer observeQuery("tokens.insert", time.Now(), &err)
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	return insertToken(ctx, m.DB, token)
+}
+
+// queryRower is a *sql.DB or *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// insertToken stores token, or returns ErrDuplicateToken if its hash is
+// taken. The conflict is skipped rather than raised so it doesn't abort an
+// enclosing transaction, which can then retry with another token.
+func insertToken(ctx context.Context, q queryRower, token *Token) error {
+	query := `INSERT INTO tokens (hash, user_id, expiry)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (hash) DO NOTHING
+	RETURNING id, created_at`
+
+	err := q.QueryRowContext(ctx, query, token.Hash, token.UserId, token.Expiry).Scan(&token.ID, &token.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrDuplicateToken
+	}
 	return err
 }
 
-func (m TokenModel) DeleteAllForUser(userID int64) error {
+// tokenInsertAttempts bounds how often a token whose hash is already taken
+// (tokens.hash is the primary key) is regenerated.
+const tokenInsertAttempts = 3
+
+// insertNewToken generates and stores a token for the user, regenerating
+// it when its hash is taken.
+func (m TokenModel) insertNewToken(ctx context.Context, q queryRower, userId int64, ttl time.Duration) (*Token, error) {
+	for attempt := 1; ; attempt++ {
+		token, err := generateToken(userId, ttl, m.tokenBytes())
+		if err != nil {
+			return nil, err
+		}
+
+		err = insertToken(ctx, q, token)
+		if errors.Is(err, ErrDuplicateToken) && attempt < tokenInsertAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return token, nil
+	}
+}
+
+// GetByHash returns the token with the given hash, expired or not, or
+// ErrRecordNotFound.
+func (m TokenModel) GetByHash(ctx context.Context, hash []byte) (_ *Token, err error) {
+	defer observeQuery("tokens.get_by_hash", time.Now(), &err)
+
+	query := `SELECT id, user_id, created_at, expiry FROM tokens WHERE hash = $1`
+
+	t := Token{Hash: hash}
+	err = m.DB.QueryRowContext(ctx, query, hash).Scan(&t.ID, &t.UserId, &t.CreatedAt, &t.Expiry)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetAllForUser returns a page of the user's unexpired tokens, newest first,
+// along with the total count.
+func (m TokenModel) GetAllForUser(ctx context.Context, userID int64, page, pageSize int) (_ []Token, _ int, err error) {
+	defer observeQuery("tokens.get_all_for_user", time.Now(), &err)
+
+	query := `
+		SELECT id, user_id, created_at, expiry, COUNT(*) OVER() AS total_count
+		FROM tokens
+		WHERE user_id = $1 AND expiry > $2
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, time.Now(), pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var (
+		tokens []Token
+		total  int
+	)
+	for rows.Next() {
+		var t Token
+		if err := rows.Scan(&t.ID, &t.UserId, &t.CreatedAt, &t.Expiry, &total); err != nil {
+			return nil, 0, err
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return tokens, total, nil
+}
+
+// AllForUser returns every token row of the user, expired ones included,
+// newest first. Plaintexts and hashes are never returned.
+func (m TokenModel) AllForUser(ctx context.Context, userID int64) (_ []Token, err error) {
+	defer observeQuery("tokens.all_for_user", time.Now(), &err)
+
+	query := `
+		SELECT id, user_id, created_at, expiry
+		FROM tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		var t Token
+		if err := rows.Scan(&t.ID, &t.UserId, &t.CreatedAt, &t.Expiry); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// DeleteForUser deletes a single token, only if it belongs to the user.
+func (m TokenModel) DeleteForUser(ctx context.Context, id, userID int64) (err error) {
+	defer observeQuery("tokens.delete_for_user", time.Now(), &err)
+
+	query := `DELETE FROM tokens WHERE id = $1 AND user_id = $2`
+
+	res, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Rotate replaces the user's unexpired token with a new one valid for ttl,
+// in one transaction. The new token's last_used_at starts now, recording
+// the use of the old one. It returns ErrRecordNotFound if plaintext is not a
+// live token of the user.
+func (m TokenModel) Rotate(ctx context.Context, plaintext string, userID int64, ttl time.Duration) (_ *Token, err error) {
+	defer observeQuery("tokens.rotate", time.Now(), &err)
+
+	hash := sha256.Sum256([]byte(plaintext))
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`DELETE FROM tokens WHERE hash = $1 AND user_id = $2 AND expiry > $3`,
+		hash[:], userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	token, err := m.insertNewToken(ctx, tx, userID, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (m TokenModel) DeleteAllForUser(userID int64) (err error) {
+	defer observeQuery("tokens.delete_all_for_user", time.Now(), &err)
+
 	query := `DELETE FROM tokens  WHERE user_id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, userID)
+	_, err = m.DB.ExecContext(ctx, query, userID)
 	return err
 }
 
-func (m TokenModel) New(userId int64, ttl time.Duration) (*Token, error) {
-	token, err := generateToken(userId, ttl)
-	if err != nil {
-		return nil, err
-	}
+func (m TokenModel) New(userId int64, ttl time.Duration) (_ *Token, err error) {
+	defer observeQuery("tokens.insert", time.Now(), &err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
 
-	err = m.Insert(token)
-	return token, err
+	return m.insertNewToken(ctx, m.DB, userId, ttl)
 }
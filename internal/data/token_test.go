@@ -0,0 +1,114 @@
+package data
+
+import (
+	"context"
+	"encoding/base32"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const tokenInsertQuery = `INSERT INTO tokens \(hash, user_id, expiry\) .* ON CONFLICT \(hash\) DO NOTHING RETURNING id, created_at`
+
+// expectTokenInsert expects one token insert, answered with a new row or,
+// when taken, with none.
+func expectTokenInsert(mock sqlmock.Sqlmock, taken bool) {
+	rows := sqlmock.NewRows([]string{"id", "created_at"})
+	if !taken {
+		rows.AddRow(9, time.Now())
+	}
+	mock.ExpectQuery(tokenInsertQuery).WillReturnRows(rows)
+}
+
+func TestTokenModelRetriesTakenHash(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(m TokenModel) (*Token, error)
+		// before and after set up what happens around the inserts
+		before, after func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name: "New",
+			call: func(m TokenModel) (*Token, error) { return m.New(7, time.Hour) },
+		},
+		{
+			name: "Rotate",
+			call: func(m TokenModel) (*Token, error) { return m.Rotate(context.Background(), "old", 7, time.Hour) },
+			before: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`DELETE FROM tokens WHERE hash = \$1 AND user_id = \$2`).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			after: func(mock sqlmock.Sqlmock) { mock.ExpectCommit() },
+		},
+	}
+	for _, tt := range tests {
+		db, mock := newMock(t)
+		m := TokenModel{DB: db}
+
+		if tt.before != nil {
+			tt.before(mock)
+		}
+		expectTokenInsert(mock, true)
+		expectTokenInsert(mock, false)
+		if tt.after != nil {
+			tt.after(mock)
+		}
+
+		token, err := tt.call(m)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if token.ID != 9 || token.Plaintext == "" {
+			t.Errorf("%s: token = %+v, want the retried insert", tt.name, token)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestTokenModelGivesUpOnTakenHashes(t *testing.T) {
+	db, mock := newMock(t)
+	m := TokenModel{DB: db}
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM tokens`).WillReturnResult(sqlmock.NewResult(0, 1))
+	for i := 0; i < tokenInsertAttempts; i++ {
+		expectTokenInsert(mock, true)
+	}
+	mock.ExpectRollback()
+
+	_, err := m.Rotate(context.Background(), "old", 7, time.Hour)
+	if !errors.Is(err, ErrDuplicateToken) {
+		t.Fatalf("err = %v, want ErrDuplicateToken", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTokenModelTokenBytes(t *testing.T) {
+	for _, tt := range []struct{ configured, want int }{
+		{0, MinTokenBytes},
+		{8, MinTokenBytes},
+		{32, 32},
+	} {
+		db, mock := newMock(t)
+		m := TokenModel{DB: db, Bytes: tt.configured}
+		expectTokenInsert(mock, false)
+
+		token, err := m.New(7, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(token.Plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(raw) != tt.want {
+			t.Errorf("Bytes %d: token carries %d random bytes, want %d", tt.configured, len(raw), tt.want)
+		}
+	}
+}
@@ -2,11 +2,16 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 // User represents a user record.
@@ -15,10 +20,25 @@ type User struct {
 	ID          int64     `json:"id"`
 	CreatedAt   time.Time `json:"created_at"`
 	PhoneNumber string    `json:"phone_number"`
+	Name        string    `json:"name"`
 }
 
 type UserModel struct {
 	DB *sql.DB
+	// Replica, when set, serves List, GetByID, GetByIDsOrdered and
+	// ListActiveSince: the /users and admin reads, which tolerate lag.
+	// Everything on the login and auth path (GetByIDPrimary,
+	// GetByPhoneNumber, GetForToken, writes) stays on DB so it sees rows
+	// written moments earlier.
+	Replica *sql.DB
+}
+
+// reader returns the handle for replica-safe reads.
+func (m UserModel) reader() *sql.DB {
+	if m.Replica != nil {
+		return m.Replica
+	}
+	return m.DB
 }
 
 var AnonymousUser = &User{}
@@ -27,35 +47,136 @@ func (m *User) IsAnonymous() bool {
 	return m == AnonymousUser
 }
 
+// anonymizedPhonePrefix starts the placeholder phone of an anonymized user.
+// It can't be a real number, so nobody can log in as that user again.
+const anonymizedPhonePrefix = "anonymized:"
+
+// IsAnonymized reports whether the user's personal data was erased.
+func (m *User) IsAnonymized() bool {
+	return strings.HasPrefix(m.PhoneNumber, anonymizedPhonePrefix)
+}
+
+// search modes for UserFilter.Match
+const (
+	MatchContains = "contains"
+	MatchPrefix   = "prefix"
+)
+
 type UserFilter struct {
-	Q        string
+	Q string
+	// Match is MatchContains (default) or MatchPrefix, which can use an index.
+	Match    string
 	Page     int
 	PageSize int
 }
 
-func (m UserModel) Insert(user *User) error {
+// escapeLike escapes LIKE wildcards so the term matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (m UserModel) Insert(user *User) (err error) {
+	defer observeQuery("users.insert", time.Now(), &err)
+
 	query := `
-		INSERT INTO users (phone_number)
-		VALUES ($1)
+		INSERT INTO users (phone_number, name)
+		VALUES ($1, $2)
 		RETURNING id, created_at
 	`
 
-	args := []interface{}{user.PhoneNumber}
+	args := []interface{}{user.PhoneNumber, user.Name}
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt)
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt)
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicatePhone
+		}
 		return err
 	}
 
 	return nil
 }
 
-func (m UserModel) GetByPhoneNumber(PhoneNumber string) (*User, error) {
+// Upsert inserts a user for the phone or returns the existing one, reporting
+// whether this call created the row. A non-empty name replaces the stored one.
+func (m UserModel) Upsert(ctx context.Context, phoneNumber, name string) (_ *User, _ bool, err error) {
+	defer observeQuery("users.upsert", time.Now(), &err)
+
+	query := `
+		INSERT INTO users (phone_number, name)
+		VALUES ($1, $2)
+		ON CONFLICT (phone_number) DO UPDATE
+		SET name = COALESCE(NULLIF(EXCLUDED.name, ''), users.name)
+		RETURNING id, created_at, phone_number, name, (xmax = 0) AS inserted
+	`
+
+	var (
+		user     User
+		inserted bool
+	)
+
+	err = m.DB.QueryRowContext(ctx, query, phoneNumber, name).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.PhoneNumber,
+		&user.Name,
+		&inserted,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &user, inserted, nil
+}
+
+// Anonymize erases the user's personal data but keeps the row, so its id
+// and created_at still line up with analytics: the phone number becomes a
+// random placeholder, the name is cleared and all tokens are revoked.
+// It returns ErrRecordNotFound for an unknown id.
+func (m UserModel) Anonymize(ctx context.Context, id int64) (err error) {
+	defer observeQuery("users.anonymize", time.Now(), &err)
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	placeholder := anonymizedPhonePrefix + hex.EncodeToString(b)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE users SET phone_number = $1, name = '' WHERE id = $2`,
+		placeholder, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRecordNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m UserModel) GetByPhoneNumber(PhoneNumber string) (_ *User, err error) {
+	defer observeQuery("users.get_by_phone_number", time.Now(), &err)
+
 	query := `
-        SELECT id, created_at, phone_number
+        SELECT id, created_at, phone_number, name
         FROM users
         WHERE phone_number = $1
     `
@@ -65,24 +186,29 @@ func (m UserModel) GetByPhoneNumber(PhoneNumber string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, PhoneNumber).Scan(&user.ID, &user.CreatedAt, &user.PhoneNumber)
-
+	err = m.DB.QueryRowContext(ctx, query, PhoneNumber).Scan(&user.ID, &user.CreatedAt, &user.PhoneNumber, &user.Name)
 	if err != nil {
-		return nil, err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 
 	return &user, nil
 }
 
-func (m UserModel) GetForToken(tokenPlainText string) (*User, error) {
+func (m UserModel) GetForToken(tokenPlainText string) (_ *User, err error) {
+	defer observeQuery("users.get_for_token", time.Now(), &err)
 
 	tokenHash := sha256.Sum256([]byte(tokenPlainText))
 
-	query := `SELECT users.id, users.created_at,  users.phone_number,  users.version
+	// the lookup records the token's use for ListActiveSince
+	query := `UPDATE tokens SET last_used_at = NOW()
 	FROM users
-	INNER JOIN tokens
-	ON users.id = tokens.user_id
-	WHERE tokens.hash = $1 AND tokens.expiry > $2
+	WHERE users.id = tokens.user_id AND tokens.hash = $1 AND tokens.expiry > $2
+	RETURNING users.id, users.created_at, users.phone_number, users.name
 	`
 
 	args := []interface{}{tokenHash[:], time.Now()}
@@ -92,7 +218,7 @@ func (m UserModel) GetForToken(tokenPlainText string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.PhoneNumber)
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.PhoneNumber, &user.Name)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -106,9 +232,23 @@ func (m UserModel) GetForToken(tokenPlainText string) (*User, error) {
 	return &user, nil
 }
 
+// GetByID looks a user up on the replica, if any, for listing-style reads
+// that can tolerate replication lag. Auth and login use GetByIDPrimary.
 func (m UserModel) GetByID(id int64) (*User, error) {
+	return m.getByID(m.reader(), "users.get_by_id", id)
+}
+
+// GetByIDPrimary looks a user up on the primary, so a user created moments
+// earlier is always found.
+func (m UserModel) GetByIDPrimary(id int64) (*User, error) {
+	return m.getByID(m.DB, "users.get_by_id_primary", id)
+}
+
+func (m UserModel) getByID(db *sql.DB, label string, id int64) (_ *User, err error) {
+	defer observeQuery(label, time.Now(), &err)
+
 	query := `
-        SELECT id, created_at, phone_number
+        SELECT id, created_at, phone_number, name
         FROM users
         WHERE id = $1
     `
@@ -117,10 +257,11 @@ func (m UserModel) GetByID(id int64) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err = db.QueryRowContext(ctx, query, id).Scan(
 		&user.ID,
 		&user.CreatedAt,
 		&user.PhoneNumber,
+		&user.Name,
 	)
 	if err != nil {
 		switch {
@@ -134,14 +275,22 @@ func (m UserModel) GetByID(id int64) (*User, error) {
 	return &user, nil
 }
 
-func (m *UserModel) List(ctx context.Context, f UserFilter) ([]User, int, error) {
+func (m *UserModel) List(ctx context.Context, f UserFilter) (_ []User, _ int, err error) {
+	defer observeQuery("users.list", time.Now(), &err)
+
 	where := `TRUE`
 	args := []any{}
 	i := 1
 
 	if f.Q != "" {
-		where += fmt.Sprintf(" AND (phone_number ILIKE $%d)", i)
-		args = append(args, "%"+f.Q+"%")
+		if f.Match == MatchPrefix {
+			// anchored and case-sensitive so the text_pattern_ops index applies
+			where += fmt.Sprintf(" AND (phone_number LIKE $%d)", i)
+			args = append(args, escapeLike(f.Q)+"%")
+		} else {
+			where += fmt.Sprintf(" AND (phone_number ILIKE $%d)", i)
+			args = append(args, "%"+escapeLike(f.Q)+"%")
+		}
 		i++
 	}
 
@@ -151,13 +300,13 @@ func (m *UserModel) List(ctx context.Context, f UserFilter) ([]User, int, error)
 	args = append(args, limit, offset)
 
 	q := fmt.Sprintf(`
-		SELECT id, phone_number, created_at, COUNT(*) OVER() AS total_count
+		SELECT id, phone_number, name, created_at, COUNT(*) OVER() AS total_count
 		FROM users
 		WHERE %s
 		LIMIT $%d OFFSET $%d
 	`, where, i, i+1)
 
-	rows, err := m.DB.QueryContext(ctx, q, args...)
+	rows, err := m.reader().QueryContext(ctx, q, args...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -170,7 +319,7 @@ func (m *UserModel) List(ctx context.Context, f UserFilter) ([]User, int, error)
 	for rows.Next() {
 		var u User
 		var t int
-		if err := rows.Scan(&u.ID, &u.PhoneNumber, &u.CreatedAt, &t); err != nil {
+		if err := rows.Scan(&u.ID, &u.PhoneNumber, &u.Name, &u.CreatedAt, &t); err != nil {
 			return nil, 0, err
 		}
 		items = append(items, u)
@@ -181,3 +330,102 @@ func (m *UserModel) List(ctx context.Context, f UserFilter) ([]User, int, error)
 	}
 	return items, total, nil
 }
+
+// GetByIDsOrdered looks up users by ID and returns them in the order of ids.
+// Missing IDs leave a nil entry in users and false at the same index in found.
+func (m UserModel) GetByIDsOrdered(ctx context.Context, ids []int64) (_ []*User, _ []bool, err error) {
+	defer observeQuery("users.get_by_ids_ordered", time.Now(), &err)
+
+	users := make([]*User, len(ids))
+	found := make([]bool, len(ids))
+	if len(ids) == 0 {
+		return users, found, nil
+	}
+
+	query := `
+        SELECT id, created_at, phone_number, name
+        FROM users
+        WHERE id = ANY($1)
+    `
+
+	rows, err := m.reader().QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[int64]*User, len(ids))
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.CreatedAt, &u.PhoneNumber, &u.Name); err != nil {
+			return nil, nil, err
+		}
+		byID[u.ID] = &u
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// duplicate IDs in the input share the same *User
+	for i, id := range ids {
+		if u, ok := byID[id]; ok {
+			users[i] = u
+			found[i] = true
+		}
+	}
+	return users, found, nil
+}
+
+// ActiveUser is a user with the time one of their sessions was last used.
+// swagger:model ActiveUser
+type ActiveUser struct {
+	User
+	// LastActiveAt is the latest last_used_at of the user's refresh tokens:
+	// their last login or refresh. Requests made with an access token
+	// in between are not tracked.
+	LastActiveAt time.Time `json:"last_active_at"`
+}
+
+// ListActiveSince returns users who logged in or presented a refresh token
+// at or after since, most recent first, going by the tokens' last_used_at.
+// That is the last time the user signed in or refreshed, not the last
+// request they made: an access token (or a sliding session extending one)
+// can stay in use long after. Users with no tokens are left out.
+func (m UserModel) ListActiveSince(ctx context.Context, since time.Time, page, pageSize int) (_ []ActiveUser, _ int, err error) {
+	defer observeQuery("users.list_active_since", time.Now(), &err)
+
+	query := `
+		SELECT u.id, u.phone_number, u.name, u.created_at, a.last_active_at, COUNT(*) OVER() AS total_count
+		FROM users u
+		JOIN (
+			SELECT user_id, MAX(last_used_at) AS last_active_at
+			FROM tokens
+			GROUP BY user_id
+		) a ON a.user_id = u.id
+		WHERE a.last_active_at >= $1
+		ORDER BY a.last_active_at DESC, u.id DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := m.reader().QueryContext(ctx, query, since, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var (
+		users []ActiveUser
+		total int
+	)
+	for rows.Next() {
+		var u ActiveUser
+		if err := rows.Scan(&u.ID, &u.PhoneNumber, &u.Name, &u.CreatedAt, &u.LastActiveAt, &total); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
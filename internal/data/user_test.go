@@ -0,0 +1,278 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// handleDriver fails every query with an error naming the DSN it was
+// opened with, so a test can tell which *sql.DB a method used.
+type handleDriver struct{}
+
+func (handleDriver) Open(name string) (driver.Conn, error) { return handleConn(name), nil }
+
+type handleConn string
+
+func (c handleConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c handleConn) Close() error                        { return nil }
+func (c handleConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c handleConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return nil, fmt.Errorf("queried %s", string(c))
+}
+
+func (c handleConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return nil, fmt.Errorf("queried %s", string(c))
+}
+
+func init() {
+	sql.Register("data-test-handle", handleDriver{})
+}
+
+func openHandle(t *testing.T, name string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("data-test-handle", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func queriedHandle(t *testing.T, err error) string {
+	t.Helper()
+	if err == nil || !strings.HasPrefix(err.Error(), "queried ") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return strings.TrimPrefix(err.Error(), "queried ")
+}
+
+func TestUserModelReadWriteHandles(t *testing.T) {
+	m := UserModel{DB: openHandle(t, "primary"), Replica: openHandle(t, "replica")}
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+		want string
+	}{
+		{"GetByID", func() error { _, err := m.GetByID(1); return err }, "replica"},
+		{"List", func() error { _, _, err := m.List(ctx, UserFilter{Page: 1, PageSize: 10}); return err }, "replica"},
+		{"GetByIDsOrdered", func() error { _, _, err := m.GetByIDsOrdered(ctx, []int64{1}); return err }, "replica"},
+		{"ListActiveSince", func() error { _, _, err := m.ListActiveSince(ctx, time.Now(), 1, 10); return err }, "replica"},
+		{"GetByIDPrimary", func() error { _, err := m.GetByIDPrimary(1); return err }, "primary"},
+		{"GetByPhoneNumber", func() error { _, err := m.GetByPhoneNumber("+989121234567"); return err }, "primary"},
+		{"GetForToken", func() error { _, err := m.GetForToken("token"); return err }, "primary"},
+		{"Upsert", func() error { _, _, err := m.Upsert(ctx, "+989121234567", ""); return err }, "primary"},
+	}
+	for _, tt := range tests {
+		if got := queriedHandle(t, tt.call()); got != tt.want {
+			t.Errorf("%s queried %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUserModelWithoutReplicaReadsPrimary(t *testing.T) {
+	m := UserModel{DB: openHandle(t, "primary")}
+
+	_, err := m.GetByID(1)
+	if got := queriedHandle(t, err); got != "primary" {
+		t.Fatalf("GetByID queried %s, want primary", got)
+	}
+}
+
+func newMock(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+func TestUserModelGetForTokenRecordsUse(t *testing.T) {
+	db, mock := newMock(t)
+	m := UserModel{DB: db}
+
+	hash := sha256.Sum256([]byte("token"))
+	mock.ExpectQuery(`UPDATE tokens SET last_used_at = NOW\(\)\s+FROM users\s+WHERE users.id = tokens.user_id AND tokens.hash = \$1 AND tokens.expiry > \$2\s+RETURNING users.id`).
+		WithArgs(hash[:], sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "phone_number", "name"}).
+			AddRow(7, time.Now(), "+989121234567", "Sara"))
+
+	user, err := m.GetForToken("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 7 {
+		t.Errorf("user = %+v, want the token's owner", user)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUserModelListActiveSinceUsesLastUse(t *testing.T) {
+	db, mock := newMock(t)
+	m := UserModel{DB: db}
+	since := time.Now().Add(-time.Hour)
+	lastUsed := time.Now().Add(-time.Minute)
+
+	mock.ExpectQuery(`SELECT user_id, MAX\(last_used_at\) AS last_active_at\s+FROM tokens`).
+		WithArgs(since, 10, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "phone_number", "name", "created_at", "last_active_at", "total_count"}).
+			AddRow(7, "+989121234567", "Sara", time.Now().Add(-48*time.Hour), lastUsed, 11))
+
+	users, total, err := m.ListActiveSince(context.Background(), since, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 11 || len(users) != 1 || !users[0].LastActiveAt.Equal(lastUsed) {
+		t.Errorf("got %+v (total %d), want the row's last use", users, total)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUserModelGetByIDsOrderedKeepsInputOrder(t *testing.T) {
+	db, mock := newMock(t)
+	m := UserModel{DB: db}
+
+	// the database answers in its own order
+	mock.ExpectQuery(`WHERE id = ANY\(\$1\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "phone_number", "name"}).
+			AddRow(1, time.Now(), "+989121111111", "a").
+			AddRow(3, time.Now(), "+989123333333", "c"))
+
+	users, found, err := m.GetByIDsOrdered(context.Background(), []int64{3, 2, 1, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotIDs []int64
+	for _, u := range users {
+		if u == nil {
+			gotIDs = append(gotIDs, 0)
+			continue
+		}
+		gotIDs = append(gotIDs, u.ID)
+	}
+	if want := []int64{3, 0, 1, 3}; !slices.Equal(gotIDs, want) {
+		t.Errorf("ids = %v, want %v", gotIDs, want)
+	}
+	if want := []bool{true, false, true, true}; !slices.Equal(found, want) {
+		t.Errorf("found = %v, want %v", found, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUserModelGetByIDsOrderedEmpty(t *testing.T) {
+	db, mock := newMock(t)
+
+	users, found, err := UserModel{DB: db}.GetByIDsOrdered(context.Background(), nil)
+	if err != nil || len(users) != 0 || len(found) != 0 {
+		t.Fatalf("got %v, %v, %v; want nothing and no query", users, found, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUserModelListMatchModes(t *testing.T) {
+	tests := []struct {
+		match   string
+		q       string
+		clause  string
+		pattern string
+	}{
+		{MatchPrefix, "+98912", `phone_number LIKE \$1`, "+98912%"},
+		{MatchContains, "912", `phone_number ILIKE \$1`, "%912%"},
+		// wildcards in the term match literally
+		{MatchPrefix, "9_%", `phone_number LIKE \$1`, `9\_\%%`},
+	}
+	for _, tt := range tests {
+		db, mock := newMock(t)
+		m := UserModel{DB: db}
+		mock.ExpectQuery(tt.clause).
+			WithArgs(tt.pattern, 10, 0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "phone_number", "name", "created_at", "total_count"}))
+
+		if _, _, err := m.List(context.Background(), UserFilter{Q: tt.q, Match: tt.match, Page: 1, PageSize: 10}); err != nil {
+			t.Fatalf("%s %q: %v", tt.match, tt.q, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s %q: %v", tt.match, tt.q, err)
+		}
+	}
+}
+
+func TestUserModelInsertDuplicatePhone(t *testing.T) {
+	db, mock := newMock(t)
+	m := UserModel{DB: db}
+
+	mock.ExpectQuery(`INSERT INTO users`).
+		WillReturnError(&pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint"})
+	if err := m.Insert(&User{PhoneNumber: "+989121234567"}); !errors.Is(err, ErrDuplicatePhone) {
+		t.Fatalf("err = %v, want ErrDuplicatePhone", err)
+	}
+
+	mock.ExpectQuery(`INSERT INTO users`).WillReturnError(&pq.Error{Code: "57014"})
+	if err := m.Insert(&User{PhoneNumber: "+989121234567"}); err == nil || errors.Is(err, ErrDuplicatePhone) {
+		t.Fatalf("err = %v, want the driver error", err)
+	}
+}
+
+// placeholderPhone matches the random phone of an anonymized user.
+type placeholderPhone struct{}
+
+func (placeholderPhone) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	return ok && (&User{PhoneNumber: s}).IsAnonymized() && len(s) > len(anonymizedPhonePrefix)
+}
+
+func TestUserModelAnonymize(t *testing.T) {
+	db, mock := newMock(t)
+	m := UserModel{DB: db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users SET phone_number = \$1, name = '' WHERE id = \$2`).
+		WithArgs(placeholderPhone{}, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM tokens WHERE user_id = \$1`).
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	// unknown users change nothing
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users`).WithArgs(placeholderPhone{}, 8).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if err := m.Anonymize(context.Background(), 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Anonymize(context.Background(), 8); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("unknown user: err = %v, want ErrRecordNotFound", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if (&User{PhoneNumber: "+989121234567"}).IsAnonymized() {
+		t.Error("a real number reported as anonymized")
+	}
+}
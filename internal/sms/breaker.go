@@ -0,0 +1,64 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// ErrCircuitOpen is returned without calling the provider while the
+// breaker is open after repeated failures.
+var ErrCircuitOpen = errors.New("sms provider unavailable: circuit open")
+
+// BreakerSender wraps a Sender with a circuit breaker that opens after
+// maxFailures consecutive failures and lets a probe through after openTimeout.
+type BreakerSender struct {
+	next Sender
+	cb   *gobreaker.CircuitBreaker
+}
+
+func NewBreakerSender(next Sender, maxFailures uint32, openTimeout time.Duration, onStateChange func(from, to string)) *BreakerSender {
+	settings := gobreaker.Settings{
+		Name:        "sms",
+		MaxRequests: 1,
+		Timeout:     openTimeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= maxFailures
+		},
+	}
+	if onStateChange != nil {
+		settings.OnStateChange = func(_ string, from, to gobreaker.State) {
+			onStateChange(from.String(), to.String())
+		}
+	}
+
+	return &BreakerSender{
+		next: next,
+		cb:   gobreaker.NewCircuitBreaker(settings),
+	}
+}
+
+func (b *BreakerSender) Send(ctx context.Context, to, message string) (*Result, error) {
+	res, err := b.cb.Execute(func() (interface{}, error) {
+		return b.next.Send(ctx, to, message)
+	})
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		return nil, ErrCircuitOpen
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res.(*Result), nil
+}
+
+// State returns "closed", "half-open" or "open".
+func (b *BreakerSender) State() string {
+	return b.cb.State().String()
+}
+
+// Unwrap returns the wrapped Sender.
+func (b *BreakerSender) Unwrap() Sender {
+	return b.next
+}
@@ -0,0 +1,60 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// stubSender fails while err is set and counts calls.
+type stubSender struct {
+	err   error
+	calls int
+}
+
+func (s *stubSender) Send(ctx context.Context, to, message string) (*Result, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &Result{Provider: "stub", Status: "sent"}, nil
+}
+
+func TestBreakerSenderOpensAndRecovers(t *testing.T) {
+	next := &stubSender{err: errors.New("provider down")}
+	var transitions []string
+	b := NewBreakerSender(next, 2, 50*time.Millisecond, func(from, to string) {
+		transitions = append(transitions, from+"->"+to)
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := b.Send(ctx, "+989121234567", "hi"); !errors.Is(err, next.err) {
+			t.Fatalf("send %d: err = %v, want the provider's error", i, err)
+		}
+	}
+	if b.State() != "open" {
+		t.Fatalf("state = %s after 2 failures, want open", b.State())
+	}
+	if _, err := b.Send(ctx, "+989121234567", "hi"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("send while open: err = %v, want ErrCircuitOpen", err)
+	}
+	if next.calls != 2 {
+		t.Fatalf("provider called %d times, want none while open", next.calls)
+	}
+
+	// after the open timeout one probe goes through and closes the breaker
+	next.err = nil
+	time.Sleep(60 * time.Millisecond)
+	if _, err := b.Send(ctx, "+989121234567", "hi"); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if b.State() != "closed" {
+		t.Fatalf("state = %s after a good probe, want closed", b.State())
+	}
+	if want := []string{"closed->open", "open->half-open", "half-open->closed"}; !slices.Equal(transitions, want) {
+		t.Fatalf("transitions = %q, want %q", transitions, want)
+	}
+}
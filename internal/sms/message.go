@@ -0,0 +1,84 @@
+package sms
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Branding is the optional text wrapped around a verification code.
+type Branding struct {
+	AppName    string
+	SupportURL string
+	// AntiPhishing appends a "never share this code" note.
+	AntiPhishing bool
+}
+
+// characters of the GSM 03.38 basic set (the escape-table ones are left out
+// and so force UCS-2, which only errs on the short side)
+const gsm7Chars = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+func isGSM7(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune(gsm7Chars, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Segments returns how many SMS segments the message needs: 160/153
+// characters per segment for GSM-7 text, 70/67 for anything else (UCS-2).
+func Segments(message string) int {
+	single, multi := 160, 153
+	if !isGSM7(message) {
+		single, multi = 70, 67
+	}
+	n := utf8.RuneCountInString(message)
+	if n <= single {
+		return 1
+	}
+	return (n + multi - 1) / multi
+}
+
+// RenderOTPMessage builds the SMS body for code. Branding parts are dropped,
+// least important first (support link, anti-phishing note, app name), until
+// the message fits in maxSegments; the code sentence itself is always kept.
+// maxSegments <= 0 means no limit.
+func RenderOTPMessage(b Branding, code string, maxSegments int) string {
+	appName, supportURL, antiPhishing := b.AppName, b.SupportURL, b.AntiPhishing
+
+	render := func() string {
+		msg := fmt.Sprintf("Your verification code is %s.", code)
+		if appName != "" {
+			msg = appName + ": " + msg
+		}
+		if antiPhishing {
+			msg += " Never share this code with anyone."
+		}
+		if supportURL != "" {
+			msg += " Help: " + supportURL
+		}
+		return msg
+	}
+
+	fits := func(msg string) bool {
+		return maxSegments <= 0 || Segments(msg) <= maxSegments
+	}
+
+	msg := render()
+	if fits(msg) {
+		return msg
+	}
+	supportURL = ""
+	if msg = render(); fits(msg) {
+		return msg
+	}
+	antiPhishing = false
+	if msg = render(); fits(msg) {
+		return msg
+	}
+	appName = ""
+	return render()
+}
@@ -0,0 +1,56 @@
+package sms
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSegments(t *testing.T) {
+	tests := []struct {
+		message string
+		want    int
+	}{
+		{strings.Repeat("a", 160), 1},
+		{strings.Repeat("a", 161), 2},
+		{strings.Repeat("a", 306), 2},
+		{strings.Repeat("a", 307), 3},
+		{strings.Repeat("ک", 70), 1},
+		{strings.Repeat("ک", 71), 2},
+		// one non-GSM character switches the whole message to UCS-2
+		{strings.Repeat("a", 70) + "ک", 2},
+	}
+	for _, tt := range tests {
+		if got := Segments(tt.message); got != tt.want {
+			t.Errorf("Segments(%d runes) = %d, want %d", len([]rune(tt.message)), got, tt.want)
+		}
+	}
+}
+
+func TestRenderOTPMessage(t *testing.T) {
+	full := Branding{AppName: "Acme", SupportURL: "https://acme.example/help", AntiPhishing: true}
+	longURL := "https://acme.example/" + strings.Repeat("h", 100)
+
+	tests := []struct {
+		name        string
+		branding    Branding
+		maxSegments int
+		want        string
+	}{
+		{"plain", Branding{}, 1, "Your verification code is 123456."},
+		{"fits", full, 1, "Acme: Your verification code is 123456. Never share this code with anyone. Help: https://acme.example/help"},
+		{"drops support link first", Branding{AppName: "Acme", SupportURL: longURL, AntiPhishing: true}, 1,
+			"Acme: Your verification code is 123456. Never share this code with anyone."},
+		{"unlimited keeps everything", Branding{AppName: "Acme", SupportURL: longURL}, 0,
+			"Acme: Your verification code is 123456. Help: " + longURL},
+		{"UCS-2 drops the anti-phishing note", Branding{AppName: "آکمه", AntiPhishing: true}, 1,
+			"آکمه: Your verification code is 123456."},
+		{"code sentence always kept", Branding{AppName: strings.Repeat("ک", 80)}, 1,
+			"Your verification code is 123456."},
+	}
+	for _, tt := range tests {
+		got := RenderOTPMessage(tt.branding, "123456", tt.maxSegments)
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is returned without calling the provider when the
+// provider-wide send quota for the current second or day is used up.
+var ErrQuotaExceeded = errors.New("sms provider quota exceeded")
+
+// QuotaSender caps the total number of messages handed to the wrapped Sender
+// per second and per (UTC) day, regardless of destination. A zero limit
+// disables that window. Counters are per process.
+type QuotaSender struct {
+	next      Sender
+	perSecond int
+	perDay    int
+	now       func() time.Time
+
+	mu          sync.Mutex
+	secondStart time.Time
+	secondCount int
+	dayStart    time.Time
+	dayCount    int
+}
+
+func NewQuotaSender(next Sender, perSecond, perDay int) *QuotaSender {
+	return &QuotaSender{
+		next:      next,
+		perSecond: perSecond,
+		perDay:    perDay,
+		now:       time.Now,
+	}
+}
+
+func (q *QuotaSender) Send(ctx context.Context, to, message string) (*Result, error) {
+	if !q.take() {
+		return nil, ErrQuotaExceeded
+	}
+	return q.next.Send(ctx, to, message)
+}
+
+// take reserves one send in both windows, or none if either is full.
+func (q *QuotaSender) take() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now().UTC()
+	if second := now.Truncate(time.Second); !second.Equal(q.secondStart) {
+		q.secondStart, q.secondCount = second, 0
+	}
+	if day := now.Truncate(24 * time.Hour); !day.Equal(q.dayStart) {
+		q.dayStart, q.dayCount = day, 0
+	}
+
+	if q.perSecond > 0 && q.secondCount >= q.perSecond {
+		return false
+	}
+	if q.perDay > 0 && q.dayCount >= q.perDay {
+		return false
+	}
+	q.secondCount++
+	q.dayCount++
+	return true
+}
+
+// Unwrap returns the wrapped Sender.
+func (q *QuotaSender) Unwrap() Sender {
+	return q.next
+}
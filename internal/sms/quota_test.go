@@ -0,0 +1,56 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuotaSenderWindows(t *testing.T) {
+	next := &stubSender{}
+	q := NewQuotaSender(next, 2, 3)
+	now := time.Date(2024, 5, 1, 23, 59, 58, 0, time.UTC)
+	q.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	send := func() error {
+		_, err := q.Send(ctx, "+989121234567", "hi")
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := send(); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	if err := send(); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("third send in a second: err = %v, want ErrQuotaExceeded", err)
+	}
+
+	now = now.Add(time.Second)
+	if err := send(); err != nil {
+		t.Fatalf("next second: %v", err)
+	}
+	if err := send(); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("fourth send in a day: err = %v, want ErrQuotaExceeded", err)
+	}
+
+	// a new UTC day starts a fresh daily count
+	now = now.Add(time.Second)
+	if err := send(); err != nil {
+		t.Fatalf("next day: %v", err)
+	}
+	if next.calls != 4 {
+		t.Fatalf("provider called %d times, want only the 4 allowed sends", next.calls)
+	}
+}
+
+func TestQuotaSenderZeroLimitsDisable(t *testing.T) {
+	q := NewQuotaSender(&stubSender{}, 0, 0)
+	for i := 0; i < 100; i++ {
+		if _, err := q.Send(context.Background(), "+989121234567", "hi"); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OutboxEntry is a message captured by SimulateSender.
+// swagger:model SMSOutboxEntry
+type OutboxEntry struct {
+	To      string    `json:"to"`
+	Message string    `json:"message"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// SimulateSender delivers nothing and keeps the last Size messages in
+// memory instead, so staging testers can read codes without log access.
+// It holds live codes, so it must never be used in production.
+type SimulateSender struct {
+	size int
+
+	mu      sync.Mutex
+	entries []OutboxEntry
+}
+
+func NewSimulateSender(size int) *SimulateSender {
+	return &SimulateSender{size: max(size, 1)}
+}
+
+func (s *SimulateSender) Send(ctx context.Context, to, message string) (*Result, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	s.entries = append(s.entries, OutboxEntry{To: to, Message: message, SentAt: now})
+	if len(s.entries) > s.size {
+		s.entries = s.entries[len(s.entries)-s.size:]
+	}
+	s.mu.Unlock()
+
+	return &Result{
+		Provider:  "simulate",
+		MessageID: fmt.Sprintf("simulate-%d", now.UnixNano()),
+		Status:    "delivered",
+		SentAt:    now,
+	}, nil
+}
+
+// Outbox returns the captured messages, newest first.
+func (s *SimulateSender) Outbox() []OutboxEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]OutboxEntry, len(s.entries))
+	for i, e := range s.entries {
+		out[len(out)-1-i] = e
+	}
+	return out
+}
@@ -0,0 +1,28 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestSimulateSenderKeepsLatestMessages(t *testing.T) {
+	s := NewSimulateSender(2)
+	for i := 1; i <= 3; i++ {
+		res, err := s.Send(context.Background(), "+989121234567", fmt.Sprintf("code %d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Provider != "simulate" || res.Status != "delivered" {
+			t.Fatalf("result = %+v", res)
+		}
+	}
+
+	outbox := s.Outbox()
+	if len(outbox) != 2 || outbox[0].Message != "code 3" || outbox[1].Message != "code 2" {
+		t.Fatalf("outbox = %+v, want the last two messages newest first", outbox)
+	}
+	if outbox[0].To != "+989121234567" || outbox[0].SentAt.IsZero() {
+		t.Fatalf("entry = %+v", outbox[0])
+	}
+}
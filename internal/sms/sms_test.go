@@ -0,0 +1,65 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPSenderSend(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"message_id":"m-1","status":"queued"}`))
+	}))
+	defer srv.Close()
+
+	s := HTTPSender{Client: srv.Client(), URL: srv.URL, APIKey: "key", From: "Acme"}
+	res, err := s.Send(context.Background(), "+989121234567", "code 1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.MessageID != "m-1" || res.Status != "queued" || res.Provider != "http" {
+		t.Errorf("result = %+v", res)
+	}
+	if got["to"] != "+989121234567" || got["message"] != "code 1234" || got["from"] != "Acme" {
+		t.Errorf("provider received %v", got)
+	}
+}
+
+func TestHTTPSenderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"error status", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "no credit", http.StatusPaymentRequired)
+		}, "402"},
+		{"invalid JSON", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}, "invalid JSON"},
+		{"timeout", func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}, "request failed"},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(tt.handler)
+		client := srv.Client()
+		client.Timeout = 50 * time.Millisecond
+
+		_, err := HTTPSender{Client: client, URL: srv.URL}.Send(context.Background(), "+989121234567", "hi")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want one mentioning %q", tt.name, err, tt.want)
+		}
+		srv.Close()
+	}
+}
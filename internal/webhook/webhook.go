@@ -0,0 +1,91 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTolerance is how far a signature timestamp may drift from now
+// before the request is treated as a replay.
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	ErrMissingSignature = errors.New("missing signature header")
+	ErrInvalidHeader    = errors.New("malformed signature header")
+	ErrStaleTimestamp   = errors.New("signature timestamp outside tolerance")
+	ErrInvalidSignature = errors.New("signature mismatch")
+)
+
+// Sign returns a signature header for body in the form "t=<unix>,v1=<hex>",
+// where v1 is the HMAC-SHA256 of "<unix>.<body>".
+func Sign(secret, body []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, computeMAC(secret, ts, body))
+}
+
+// VerifySignature checks a header produced by Sign against body, rejecting
+// timestamps more than DefaultTolerance away from the current time.
+func VerifySignature(secret []byte, body []byte, header string) error {
+	return VerifySignatureAt(secret, body, header, time.Now(), DefaultTolerance)
+}
+
+// VerifySignatureAt is VerifySignature with an explicit clock and tolerance.
+func VerifySignatureAt(secret []byte, body []byte, header string, now time.Time, tolerance time.Duration) error {
+	if header == "" {
+		return ErrMissingSignature
+	}
+
+	var (
+		ts         string
+		signatures []string
+	)
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return ErrInvalidHeader
+		}
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+	if ts == "" || len(signatures) == 0 {
+		return ErrInvalidHeader
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalidHeader
+	}
+	drift := now.Sub(time.Unix(unix, 0))
+	if drift < 0 {
+		drift = -drift
+	}
+	if drift > tolerance {
+		return ErrStaleTimestamp
+	}
+
+	expected := computeMAC(secret, ts, body)
+	for _, sig := range signatures {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func computeMAC(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,77 @@
+package webhook
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testSecret = []byte("webhook-secret")
+	testBody   = []byte(`{"message_id":"42","status":"delivered"}`)
+)
+
+func TestVerifySignatureValid(t *testing.T) {
+	now := time.Now()
+	header := Sign(testSecret, testBody, now.Add(-time.Minute))
+
+	if err := VerifySignatureAt(testSecret, testBody, header, now, DefaultTolerance); err != nil {
+		t.Fatal(err)
+	}
+	// a rotated secret is sent as a second v1 next to the current one
+	rotated := header + ",v1=" + strings.Repeat("0", 64)
+	if err := VerifySignatureAt(testSecret, testBody, rotated, now, DefaultTolerance); err != nil {
+		t.Fatalf("extra signature: %v", err)
+	}
+}
+
+func TestVerifySignatureTampered(t *testing.T) {
+	now := time.Now()
+	header := Sign(testSecret, testBody, now)
+	// the same signature presented with a different, still fresh timestamp
+	_, mac, _ := strings.Cut(header, ",")
+	retimed := Sign(testSecret, testBody, now.Add(time.Second))
+	retimed, _, _ = strings.Cut(retimed, ",")
+
+	tests := map[string]struct {
+		secret, body []byte
+		header       string
+	}{
+		"body":      {testSecret, []byte(`{"message_id":"42","status":"failed"}`), header},
+		"secret":    {[]byte("other-secret"), testBody, header},
+		"timestamp": {testSecret, testBody, retimed + "," + mac},
+	}
+	for name, tt := range tests {
+		err := VerifySignatureAt(tt.secret, tt.body, tt.header, now, DefaultTolerance)
+		if !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("tampered %s: err = %v, want ErrInvalidSignature", name, err)
+		}
+	}
+}
+
+func TestVerifySignatureStale(t *testing.T) {
+	now := time.Now()
+
+	for _, signedAt := range []time.Time{now.Add(-DefaultTolerance - time.Second), now.Add(DefaultTolerance + time.Second)} {
+		header := Sign(testSecret, testBody, signedAt)
+		if err := VerifySignatureAt(testSecret, testBody, header, now, DefaultTolerance); !errors.Is(err, ErrStaleTimestamp) {
+			t.Errorf("signed at %s: err = %v, want ErrStaleTimestamp", signedAt.Sub(now), err)
+		}
+	}
+}
+
+func TestVerifySignatureMalformed(t *testing.T) {
+	tests := map[string]error{
+		"":              ErrMissingSignature,
+		"garbage":       ErrInvalidHeader,
+		"t=123":         ErrInvalidHeader,
+		"v1=abc":        ErrInvalidHeader,
+		"t=soon,v1=abc": ErrInvalidHeader,
+	}
+	for header, want := range tests {
+		if err := VerifySignature(testSecret, testBody, header); !errors.Is(err, want) {
+			t.Errorf("header %q: err = %v, want %v", header, err, want)
+		}
+	}
+}
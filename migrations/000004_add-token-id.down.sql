@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS tokens_user_id_idx;
+ALTER TABLE tokens DROP COLUMN IF EXISTS created_at;
+ALTER TABLE tokens DROP COLUMN IF EXISTS id;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS id bigserial UNIQUE;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS created_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+CREATE INDEX IF NOT EXISTS tokens_user_id_idx ON tokens (user_id);
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_phone_number_trgm_idx;
+DROP INDEX IF EXISTS users_phone_number_prefix_idx;
\ No newline at end of file
@@ -0,0 +1,5 @@
+-- prefix mode (LIKE 'term%') can use a text_pattern_ops B-tree index;
+-- contains mode (ILIKE '%term%') needs a trigram GIN index.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS users_phone_number_prefix_idx ON users (phone_number text_pattern_ops);
+CREATE INDEX IF NOT EXISTS users_phone_number_trgm_idx ON users USING GIN (phone_number gin_trgm_ops);
\ No newline at end of file
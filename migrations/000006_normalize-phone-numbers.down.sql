@@ -0,0 +1,2 @@
+-- The original formatting isn't kept, so the rewrite can't be undone.
+SELECT 1;
\ No newline at end of file
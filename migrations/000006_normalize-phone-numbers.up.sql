@@ -0,0 +1,19 @@
+-- Rewrites phone numbers stored before input was normalized to E.164, the
+-- form data.ValidatePhoneNumber returns: separators are dropped and a
+-- leading 00 becomes +. Rows that would collide with another user, or that
+-- have no country code, are left alone for a manual merge; list them with
+--   SELECT id, phone_number FROM users WHERE phone_number !~ '^\+[1-9][0-9]{7,14}$';
+WITH candidates AS (
+    SELECT id,
+           '+' || regexp_replace(regexp_replace(btrim(phone_number), '[ .()-]', '', 'g'), '^(\+|00)', '') AS e164
+    FROM users
+    WHERE phone_number !~ '^\+[1-9][0-9]{7,14}$'
+      AND btrim(phone_number) ~ '^(\+|00)[0-9 .()-]+$'
+)
+UPDATE users u
+SET phone_number = c.e164
+FROM candidates c
+WHERE u.id = c.id
+  AND c.e164 ~ '^\+[1-9][0-9]{7,14}$'
+  AND NOT EXISTS (SELECT 1 FROM users o WHERE o.phone_number = c.e164)
+  AND (SELECT count(*) FROM candidates d WHERE d.e164 = c.e164) = 1;
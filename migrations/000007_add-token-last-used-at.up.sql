@@ -0,0 +1,5 @@
+-- Tracks when each refresh token was last presented, so activity reports
+-- see use rather than only issue. Existing tokens start from their
+-- creation time.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS last_used_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+UPDATE tokens SET last_used_at = created_at;